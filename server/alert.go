@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/syncresult"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// syncAlertMessage returns the alert posted for a finished sync, or "" when the run went well
+// enough not to need one
+func (c *configuration) syncAlertMessage(result *syncresult.Result) string {
+	var problems []string
+	if result.TimedOut {
+		problems = append(problems, "timed out")
+	}
+	if result.Aborted {
+		problems = append(problems, "aborted: "+result.AbortReason)
+	}
+	if result.FailedCount > c.AlertFailureThreshold {
+		problems = append(problems, fmt.Sprintf("%d failures (threshold %d)", result.FailedCount, c.AlertFailureThreshold))
+	}
+	if len(problems) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("#### :warning: ERPNext sync %s needs attention\n"+
+		"The %s sync %s.\n\n"+
+		"| Failed | Skipped | Created | Updated | Matched | Processed |\n"+
+		"|---|---|---|---|---|---|\n"+
+		"| %d | %d | %d | %d | %d | %d |\n\n"+
+		"Correlation ID: `%s`. Search the server logs for it; failed records can be retried with `POST /api/v1/sync/retry-failed`.",
+		result.Direction, result.Direction, strings.Join(problems, ", "),
+		result.FailedCount, result.SkippedCount, result.CreatedCount, result.UpdatedCount, result.MatchedCount, result.TotalProcessed,
+		result.CorrelationID)
+}
+
+// alertOnSyncProblems posts a summary to AlertChannelID when an unattended sync timed out, was
+// aborted or failed more records than AlertFailureThreshold
+func (p *Plugin) alertOnSyncProblems(result *syncresult.Result) {
+	config := p.getConfiguration()
+	channelID := strings.TrimSpace(config.AlertChannelID)
+	if channelID == "" {
+		return
+	}
+
+	message := config.syncAlertMessage(result)
+	if message == "" {
+		return
+	}
+
+	if _, err := p.postAsBot(&model.Post{ChannelId: channelID, Message: message}); err != nil {
+		p.API.LogError("Failed to post sync alert", "channel_id", channelID, "correlation_id", result.CorrelationID, "error", err.Error())
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/syncresult"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncAlertMessage(t *testing.T) {
+	c := &configuration{AlertFailureThreshold: 2}
+
+	result := syncresult.New(directionMMToERP)
+	result.FailedCount = 2
+	assert.Empty(t, c.syncAlertMessage(result), "failures at the threshold don't alert")
+
+	result.FailedCount = 3
+	message := c.syncAlertMessage(result)
+	assert.Contains(t, message, "3 failures (threshold 2)")
+	assert.Contains(t, message, result.CorrelationID)
+
+	result = syncresult.New(directionERPToMM)
+	result.MarkTimedOut()
+	assert.Contains(t, c.syncAlertMessage(result), "timed out")
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -9,8 +11,11 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/mattermost/mattermost-plugin-starter-template/server/erpnext"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/syncresult"
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/pkg/errors"
 )
 
 // ServeHTTP handles HTTP requests for the plugin.
@@ -33,12 +38,24 @@ func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Req
 	// Sync endpoints with descriptive paths
 	syncRouter.HandleFunc("/mm-to-erp", p.SyncUsers).Methods(http.MethodPost)
 	syncRouter.HandleFunc("/erp-to-mm", p.SyncEmployees).Methods(http.MethodPost)
+	syncRouter.HandleFunc("/check", p.CheckSyncState).Methods(http.MethodGet)
+	syncRouter.HandleFunc("/retry-failed", p.RetryFailedSyncs).Methods(http.MethodPost)
+	syncRouter.HandleFunc("/pause", p.PauseSyncs).Methods(http.MethodPost)
+	syncRouter.HandleFunc("/resume", p.ResumeSyncs).Methods(http.MethodPost)
+	syncRouter.HandleFunc("/schema", p.GetSyncSchema).Methods(http.MethodGet)
 
 	router.ServeHTTP(w, r)
 }
 
-// AdminAuthorizationRequired is middleware that checks if the user is a system admin
+// AdminAuthorizationRequired is middleware that checks if the user is a system admin,
+// or that the request carries the configured automation token
 func (p *Plugin) AdminAuthorizationRequired(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	if p.hasValidAutomationToken(r) {
+		p.API.LogDebug("Request authorized with automation token", "path", r.URL.Path)
+		next.ServeHTTP(w, r)
+		return
+	}
+
 	userID := r.Header.Get("Mattermost-User-ID")
 	p.API.LogDebug("Received request with user ID", "user_id", userID)
 
@@ -65,6 +82,24 @@ func (p *Plugin) AdminAuthorizationRequired(w http.ResponseWriter, r *http.Reque
 	next.ServeHTTP(w, r)
 }
 
+// hasValidAutomationToken reports whether the request carries a bearer token matching the
+// configured AutomationToken. An empty AutomationToken disables token access entirely.
+func (p *Plugin) hasValidAutomationToken(r *http.Request) bool {
+	expected := p.getConfiguration().AutomationToken
+	if expected == "" {
+		return false
+	}
+
+	const bearerPrefix = "Bearer "
+	header := r.Header.Get("Authorization")
+	if len(header) <= len(bearerPrefix) || !strings.EqualFold(header[:len(bearerPrefix)], bearerPrefix) {
+		return false
+	}
+
+	provided := strings.TrimSpace(header[len(bearerPrefix):])
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(expected)) == 1
+}
+
 func (p *Plugin) HelloWorld(w http.ResponseWriter, r *http.Request) {
 	if _, err := w.Write([]byte("Hello, world!")); err != nil {
 		p.API.LogError("Failed to write response", "error", err)
@@ -72,14 +107,13 @@ func (p *Plugin) HelloWorld(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// SyncUsers syncs Mattermost users with ERPNext employees and creates ERPNext users
+// SyncUsers syncs Mattermost users with ERPNext employees and creates ERPNext users. An optional
+// {"emails": [...]} body restricts the sync to the users with those emails.
 func (p *Plugin) SyncUsers(w http.ResponseWriter, r *http.Request) {
 	// Log the start of function for debugging
 	p.API.LogInfo("SyncUsers function started")
 
-	// Add timeout protection for large syncs
 	startTime := time.Now()
-	maxDuration := 15 * time.Minute // Increased timeout for large syncs
 
 	if p.erpNextClient == nil {
 		p.API.LogError("ERPNext client is not configured")
@@ -87,134 +121,135 @@ func (p *Plugin) SyncUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if the custom_chat_id field exists, and create it if it doesn't
-	p.API.LogInfo("Checking if custom_chat_id field exists in ERPNext")
-
-	exists, err := p.erpNextClient.CheckCustomFieldExists("custom_chat_id", "Employee")
+	targets, err := readSyncTargets(r)
 	if err != nil {
-		p.API.LogError("Failed to check if custom_chat_id field exists", "error", err)
-		http.Error(w, fmt.Sprintf("Failed to check if custom_chat_id field exists: %s", err.Error()), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if !exists {
-		p.API.LogInfo("Creating custom_chat_id field in ERPNext")
-
-		// Create the custom field
-		err = p.erpNextClient.CreateCustomField(
-			"custom_chat_id",   // Field name
-			"Workdone User ID", // Label
-			"Employee",         // Document type
-			"Data",             // Field type
-			false,              // Not required
-		)
-
-		if err != nil {
-			p.API.LogError("Failed to create custom_chat_id field", "error", err)
-			http.Error(w, fmt.Sprintf("Failed to create custom_chat_id field: %s", err.Error()), http.StatusInternalServerError)
-			return
-		}
-
-		p.API.LogInfo("Successfully created custom_chat_id field in ERPNext")
+	// ERPNext requests and retries stop when the plugin shuts down or the run runs out of time
+	ctx, cancel := p.syncContext(startTime, userSyncMaxDuration)
+	defer cancel()
+
+	// A targeted sync looks up only the listed users instead of the whole directory
+	var users []*model.User
+	var truncated bool
+	var missing []string
+	if targets != nil {
+		p.API.LogInfo("Syncing only the listed users", "emails", len(targets))
+		users, missing, err = p.loadUsersByEmail(targets)
 	} else {
-		p.API.LogInfo("custom_chat_id field already exists in ERPNext")
+		users, truncated, err = p.loadUsersForSync()
 	}
-
-	// Check if the "Mặc định" role profile exists, and create it if it doesn't
-	p.API.LogInfo("Checking if 'Mặc định' role profile exists in ERPNext")
-
-	roleProfileExists, err := p.erpNextClient.CheckRoleProfileExists("Mặc định")
 	if err != nil {
-		p.API.LogError("Failed to check if 'Mặc định' role profile exists", "error", err)
-		http.Error(w, fmt.Sprintf("Failed to check if 'Mặc định' role profile exists: %s", err.Error()), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if !roleProfileExists {
-		p.API.LogInfo("Creating 'Mặc định' role profile in ERPNext")
+	// Build response data
+	result := syncresult.New(directionMMToERP)
+	if truncated {
+		result.MarkTruncated()
+	}
+	if targets != nil {
+		result.MarkTargeted()
+	}
 
-		err = p.erpNextClient.CreateRoleProfile("Mặc định")
-		if err != nil {
-			p.API.LogError("Failed to create 'Mặc định' role profile", "error", err)
-			http.Error(w, fmt.Sprintf("Failed to create 'Mặc định' role profile: %s", err.Error()), http.StatusInternalServerError)
-			return
+	// Stream per-user results as NDJSON when requested, otherwise collect them for the JSON response
+	stream := p.streamSyncResults(w, r, result)
+	recordMissingTargets(result, missing, "Mattermost user")
+
+	p.runUserSync(ctx, r.Header.Get("Mattermost-User-ID"), startTime, users, result, false)
+
+	// Streamed responses already carry every result line, finish with the summary
+	if stream != nil {
+		if err := stream.WriteSummary(result); err != nil {
+			p.API.LogError("Failed to stream sync summary", "error", err)
 		}
+		return
+	}
 
-		p.API.LogInfo("Successfully created 'Mặc định' role profile in ERPNext")
-	} else {
-		p.API.LogInfo("'Mặc định' role profile already exists in ERPNext")
+	// Return the results as JSON, or CSV when requested
+	p.writeSyncResult(w, r, result)
+}
+
+// Timeout protection for large syncs, counted from the start of the run
+const (
+	userSyncMaxDuration     = 15 * time.Minute
+	employeeSyncMaxDuration = 20 * time.Minute
+)
+
+// loadUsersForSync makes sure every ERPNext instance is ready and returns the Mattermost users
+// to sync, most important first, and whether the list hit its safety limit
+func (p *Plugin) loadUsersForSync() ([]*model.User, bool, error) {
+	// Make sure every ERPNext instance has the chat ID field and the default role profile
+	if err := p.prepareERPNextInstances(); err != nil {
+		return nil, false, err
 	}
 
 	// Fetch all users from Mattermost with pagination
 	p.API.LogInfo("Fetching Mattermost users with pagination")
+	users, truncated, err := p.fetchActiveUsers()
+	if err != nil {
+		return nil, false, err
+	}
 
-	perPage := 200
-	var allUsers []*model.User
-	page := 0
+	// Process the most important accounts first in case the run times out
+	p.orderUsersForSync(users)
+	return users, truncated, nil
+}
 
-	// Fetch all users with pagination
-	for {
-		users, appErr := p.API.GetUsers(&model.UserGetOptions{
-			Page:    page,
-			PerPage: perPage,
-			Active:  true, // Only fetch active (non-deleted) users
-		})
-		if appErr != nil {
-			p.API.LogError("Failed to fetch users from Mattermost", "error", appErr.Error(), "page", page)
-			http.Error(w, fmt.Sprintf("Failed to fetch users: %s", appErr.Error()), http.StatusInternalServerError)
-			return
-		}
+// runUserSync syncs users to ERPNext within ctx, recording every record and the run itself.
+// actorID is the admin shown live progress, empty for none. A dry run writes nothing to ERPNext
+// and isn't recorded as a sync: records read "Would create"/"Would update".
+func (p *Plugin) runUserSync(ctx context.Context, actorID string, startTime time.Time, users []*model.User, result *syncresult.Result, dryRun bool) {
 
-		// Add users to our collection
-		allUsers = append(allUsers, users...)
+	// Deactivation waits for the run to stop at its next user
+	defer p.syncs.begin()()
 
-		p.API.LogInfo(fmt.Sprintf("Fetched page %d: %d users (total so far: %d)", page+1, len(users), len(allUsers)))
+	result.SetMaxEntries(p.getConfiguration().MaxResultEntries)
 
-		// If we got fewer users than the page size, we've reached the end
-		if len(users) < perPage {
-			break
-		}
-
-		page++
-
-		// Safety check to prevent infinite loops (allows up to 2000 users)
-		if page > 15 { // Increased limit: 15 pages * 200 per page = 3000 users max
-			p.API.LogWarn("Reached maximum page limit during user sync", "pages_fetched", page)
-			break
-		}
+	// Users sharing an email would overwrite each other's mapping, so only one of each is synced
+	emailConflicts := findEmailConflicts(users)
+	if len(emailConflicts) > 0 {
+		p.API.LogWarn("Found Mattermost users sharing an email address", "skipped_users", len(emailConflicts))
+	}
+	numberConflicts := p.getConfiguration().findEmployeeNumberConflicts(users)
+	if len(numberConflicts) > 0 {
+		p.API.LogWarn("Found Mattermost users sharing an employee number", "skipped_users", len(numberConflicts))
 	}
 
-	// Use allUsers for the rest of the function
-	users := allUsers
+	breaker := newCircuitBreaker(p.getConfiguration().getCircuitBreakerThreshold())
 
-	// Log summary of users fetched
-	p.API.LogInfo(fmt.Sprintf("Fetched %d total users from Mattermost across %d pages", len(users), page+1))
+	// Live progress for the admin watching the sync
+	progress := p.newProgressReporter(actorID, directionMMToERP, len(users))
+	progress.Start(result)
 
-	// Build response data
-	type SyncResult struct {
-		MatchedCount    int      `json:"matched_count"`
-		UpdatedCount    int      `json:"updated_count"`
-		CreatedCount    int      `json:"created_count"`
-		SkippedCount    int      `json:"skipped_count"`
-		ERPUsersCreated int      `json:"erp_users_created"`
-		ERPUsersAlready int      `json:"erp_users_already_exist"`
-		UserResults     []string `json:"user_results"`
-		TotalProcessed  int      `json:"total_processed"`
-		TimedOut        bool     `json:"timed_out"`
+	// Chat ID writes to existing employees are batched when configured
+	var batch *chatIDBatch
+	if !dryRun {
+		batch = newChatIDBatch(p.getConfiguration().ChatIDWriteBatchSize)
 	}
 
-	result := SyncResult{
-		UserResults: []string{},
-	}
+	// A sample of the written chat IDs is re-read once the run is done, when configured
+	verifier := newSyncVerifier(p.getConfiguration().VerifySamplePercent)
 
 	// Process each user
 	for i, user := range users {
+		// Stop cleanly when the plugin is being deactivated
+		if p.syncs.stopping() {
+			p.API.LogWarn("Plugin is shutting down, stopping sync", "processed_users", i)
+			reason := fmt.Sprintf("the plugin is shutting down, stopped after %d users", i)
+			result.RecordNote("ABORTED: " + reason)
+			result.MarkAborted(reason)
+			break
+		}
+
 		// Check for timeout
-		if time.Since(startTime) > maxDuration {
+		if time.Since(startTime) > userSyncMaxDuration {
 			p.API.LogWarn("Sync operation reached maximum duration, stopping", "processed_users", i)
-			result.UserResults = append(result.UserResults,
-				fmt.Sprintf("TIMEOUT: Sync stopped after processing %d users due to timeout", i))
-			result.TimedOut = true
+			result.RecordNote(fmt.Sprintf("TIMEOUT: Sync stopped after processing %d users due to timeout", i))
+			result.MarkTimedOut()
 			break
 		}
 
@@ -224,208 +259,79 @@ func (p *Plugin) SyncUsers(w http.ResponseWriter, r *http.Request) {
 				i, len(users), float64(i)/float64(len(users))*100))
 		}
 
-		// Skip if user has no email
-		if user.Email == "" {
-			p.API.LogDebug("Skipping user with no email", "username", user.Username)
-			result.SkippedCount++
-			result.UserResults = append(result.UserResults,
-				fmt.Sprintf("%s (%s) - Skipped (No Email)", user.Username, user.Email))
+		if kept, conflict := emailConflicts[user.Id]; conflict {
+			p.API.LogWarn("Skipping user sharing an email with another user",
+				"username", user.Username, "email", user.Email, "kept_username", kept.Username)
+			result.RecordSkipped("Duplicate Email", fmt.Sprintf("%s (%s) - Skipped (Duplicate Email, synced %s instead)", user.Username, user.Email, kept.Username))
+			progress.Processed(result)
 			continue
 		}
-
-		// Skip if user is a bot
-		if user.IsBot {
-			p.API.LogDebug("Skipping bot user", "username", user.Username)
-			result.SkippedCount++
-			result.UserResults = append(result.UserResults,
-				fmt.Sprintf("%s (%s) - Skipped (Bot)", user.Username, user.Email))
+		if number, conflict := numberConflicts[user.Id]; conflict {
+			result.RecordSkipped("Duplicate Employee Number", fmt.Sprintf("%s (%s) - Skipped (Duplicate Employee Number %s, shared with other users)", user.Username, user.Email, number))
+			progress.Processed(result)
 			continue
 		}
 
-		// Skip if user is deleted
-		if user.DeleteAt > 0 {
-			p.API.LogDebug("Skipping deleted user", "username", user.Username, "deleteAt", user.DeleteAt)
-			result.SkippedCount++
-			result.UserResults = append(result.UserResults,
-				fmt.Sprintf("%s (%s) - Skipped (Deleted)", user.Username, user.Email))
-			continue
-		}
-
-		// Try to find matching employee in ERPNext
-		employee, err := p.erpNextClient.GetEmployeeByEmail(user.Email)
-		if err != nil {
-			p.API.LogError("Error finding employee by email",
-				"email", user.Email,
-				"error", err)
-			result.UserResults = append(result.UserResults,
-				fmt.Sprintf("%s (%s) - Error: %s", user.Username, user.Email, err.Error()))
+		res := p.syncRecordWithTimeout(ctx, fmt.Sprintf("%s (%s)", user.Username, user.Email), func(ctx context.Context) recordSyncResult {
+			return p.syncUserToERPNext(ctx, user, dryRun, batch != nil)
+		})
+		if res.PendingChatID != nil {
+			// Recorded once the batch is written
+			if batch.add(res) {
+				p.flushChatIDBatch(ctx, batch, result, verifier)
+				progress.Processed(result)
+			}
 			continue
 		}
-
-		var isNewEmployee bool = false
-
-		if employee != nil {
-			// Employee found - check if we need to update the custom_chat_id
-			if employee.CustomChatID != user.Id {
-				// Need to update the custom_chat_id field
-				p.API.LogInfo("Updating custom_chat_id for existing employee",
-					"email", user.Email,
-					"employee_id", employee.Name,
-					"mattermost_id", user.Id)
-
-				// Create an employee object with the updated custom_chat_id
-				updatedEmployee := &erpnext.Employee{
-					Name:         employee.Name,
-					CustomChatID: user.Id,
-				}
-
-				// Call API to update the employee
-				_, err := p.erpNextClient.UpdateEmployee(updatedEmployee)
-				if err != nil {
-					p.API.LogError("Failed to update employee custom_chat_id in ERPNext",
-						"email", user.Email,
-						"error", err)
-					result.UserResults = append(result.UserResults,
-						fmt.Sprintf("%s (%s) - Update Failed: %s", user.Username, user.Email, err.Error()))
-					continue
-				}
-
-				result.UpdatedCount++
-			} else {
-				// Already mapped correctly
-				result.MatchedCount++
-			}
-		} else {
-			// Employee not found - create a new one
-			p.API.LogInfo("Creating new employee for Mattermost user",
-				"username", user.Username,
-				"email", user.Email)
-
-			// Create new employee with fixed values as specified
-			newEmployee := &erpnext.Employee{
-				CompanyEmail:  user.Email,
-				FirstName:     user.FirstName,
-				LastName:      user.LastName,
-				Gender:        "Male",       // Fixed as specified
-				DateOfBirth:   "2000-01-01", // Fixed as specified
-				DateOfJoining: "2000-01-01", // Fixed as specified
-				Status:        "Active",
-				CustomChatID:  user.Id, // Store Mattermost ID
-			}
-
-			// Call API to create the employee
-			_, err := p.erpNextClient.CreateEmployee(newEmployee)
-			if err != nil {
-				p.API.LogError("Failed to create employee in ERPNext",
-					"email", user.Email,
-					"error", err)
-				result.UserResults = append(result.UserResults,
-					fmt.Sprintf("%s (%s) - Creation Failed: %s", user.Username, user.Email, err.Error()))
-				continue
-			}
-
-			result.CreatedCount++
-			isNewEmployee = true
+		if res.Err != nil && !dryRun {
+			p.recordSyncFailure(directionMMToERP, user.Email, res.Err)
 		}
-
-		// Now check if ERPNext user exists for this employee
-		p.API.LogInfo("Checking if ERPNext user exists for employee", "email", user.Email)
-
-		erpUser, err := p.erpNextClient.GetUserByEmail(user.Email)
-		if err != nil {
-			p.API.LogError("Error checking ERPNext user by email", "email", user.Email, "error", err)
-			// Continue with the next user instead of failing completely
-			if isNewEmployee {
-				result.UserResults = append(result.UserResults,
-					fmt.Sprintf("%s (%s) - Employee Created, User Check Failed: %s", user.Username, user.Email, err.Error()))
-			} else {
-				result.UserResults = append(result.UserResults,
-					fmt.Sprintf("%s (%s) - Employee Updated, User Check Failed: %s", user.Username, user.Email, err.Error()))
-			}
-			continue
+		res.recordTo(result)
+		verifier.observe(res)
+		progress.Processed(result)
+
+		// Stop early when ERPNext is down instead of failing every remaining record the same way
+		if breaker.record(res.Err) {
+			reason := fmt.Sprintf("ERPNext appears unavailable, aborted after %d users", i+1)
+			p.API.LogError("Aborting sync after consecutive ERPNext failures", "processed_users", i+1, "error", res.Err.Error())
+			result.RecordNote("ABORTED: " + reason)
+			result.MarkAborted(reason)
+			break
 		}
+	}
 
-		if erpUser != nil {
-			// ERPNext user already exists
-			result.ERPUsersAlready++
-			if isNewEmployee {
-				result.UserResults = append(result.UserResults,
-					fmt.Sprintf("%s (%s) - Employee Created, ERPNext User Already Exists", user.Username, user.Email))
-			} else {
-				result.UserResults = append(result.UserResults,
-					fmt.Sprintf("%s (%s) - Already Mapped, ERPNext User Exists", user.Username, user.Email))
-			}
-		} else {
-			// Need to create ERPNext user
-			p.API.LogInfo("Creating ERPNext user for employee", "email", user.Email)
-
-			// Generate username from email (take part before @)
-			emailParts := strings.Split(user.Email, "@")
-			username := emailParts[0]
-			if len(username) == 0 {
-				username = fmt.Sprintf("user_%s", user.Id[:8]) // Fallback to partial Mattermost ID
-			}
+	// The run wraps up even when it stopped early for running out of time
+	wrapUp := context.WithoutCancel(ctx)
 
-			newERPUser := &erpnext.User{
-				Email:            user.Email,
-				FirstName:        user.FirstName,
-				LastName:         user.LastName,
-				Username:         username,
-				Enabled:          1, // 1 for enabled
-				RoleProfileName:  "Mặc định",
-				SendWelcomeEmail: 0, // Send welcome email
-			}
+	// Write the chat IDs still waiting in the batch, even when the run stopped early
+	p.flushChatIDBatch(wrapUp, batch, result, verifier)
 
-			_, err := p.erpNextClient.CreateUser(newERPUser)
-			if err != nil {
-				p.API.LogError("Failed to create ERPNext user", "email", user.Email, "error", err)
-				if isNewEmployee {
-					result.UserResults = append(result.UserResults,
-						fmt.Sprintf("%s (%s) - Employee Created, ERPNext User Creation Failed: %s", user.Username, user.Email, err.Error()))
-				} else {
-					result.UserResults = append(result.UserResults,
-						fmt.Sprintf("%s (%s) - Employee Updated, ERPNext User Creation Failed: %s", user.Username, user.Email, err.Error()))
-				}
-				continue
-			}
-
-			result.ERPUsersCreated++
-			if isNewEmployee {
-				result.UserResults = append(result.UserResults,
-					fmt.Sprintf("%s (%s) - Employee & ERPNext User Created", user.Username, user.Email))
-			} else {
-				result.UserResults = append(result.UserResults,
-					fmt.Sprintf("%s (%s) - Employee Updated, ERPNext User Created", user.Username, user.Email))
-			}
-		}
-	}
+	// Confirm a sample of the writes actually stuck
+	p.verifySyncWrites(wrapUp, verifier, result)
 
 	// Set total processed count
-	result.TotalProcessed = result.MatchedCount + result.UpdatedCount + result.CreatedCount + result.SkippedCount
-
-	// Create response summary
-	summary := fmt.Sprintf(
-		"Sync completed. Total Processed: %d, Matched: %d, Updated: %d, Created: %d, Skipped: %d, ERPNext Users Created: %d, ERPNext Users Already Exist: %d, Timed Out: %v",
-		result.TotalProcessed, result.MatchedCount, result.UpdatedCount, result.CreatedCount, result.SkippedCount, result.ERPUsersCreated, result.ERPUsersAlready, result.TimedOut,
-	)
-	p.API.LogInfo(summary)
-
-	// Return JSON response
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(result); err != nil {
-		p.API.LogError("Failed to encode response", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	budget := erpnext.RetryBudgetFromContext(ctx)
+	result.RecordRetryBudget(budget.Used(), budget.Limit())
+	result.Finish()
+	progress.Done(result)
+	if dryRun {
+		p.API.LogInfo("Dry run completed. "+result.Summary(), "correlation_id", result.CorrelationID)
+		return
+	}
+	p.recordCompletedSync(directionMMToERP, startTime, result)
+	p.API.LogInfo("Sync completed. "+result.Summary(), "correlation_id", result.CorrelationID)
+	if actorID == "" {
+		p.alertOnSyncProblems(result)
 	}
 }
 
-// SyncEmployees syncs ERPNext employees with Mattermost users - Enhanced for 500-700+ employees
+// SyncEmployees syncs ERPNext employees with Mattermost users - Enhanced for 500-700+ employees.
+// An optional {"emails": [...]} body restricts the sync to the employees with those emails.
 func (p *Plugin) SyncEmployees(w http.ResponseWriter, r *http.Request) {
 	// Log the start of function for debugging
 	p.API.LogInfo("SyncEmployees function started")
 
-	// Add timeout protection for large syncs
 	startTime := time.Now()
-	maxDuration := 20 * time.Minute // Increased timeout for large employee syncs
 
 	if p.erpNextClient == nil {
 		p.API.LogError("ERPNext client is not configured")
@@ -433,75 +339,138 @@ func (p *Plugin) SyncEmployees(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if the custom_chat_id field exists, and create it if it doesn't
-	p.API.LogInfo("Checking if custom_chat_id field exists in ERPNext")
+	targets, err := readSyncTargets(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	exists, err := p.erpNextClient.CheckCustomFieldExists("custom_chat_id", "Employee")
+	// ERPNext requests and retries stop when the plugin shuts down or the run runs out of time
+	ctx, cancel := p.syncContext(startTime, employeeSyncMaxDuration)
+	defer cancel()
+
+	// A targeted sync looks up only the listed employees instead of fetching all of them
+	var employees []erpnext.Employee
+	var missing []string
+	var lookupFailures map[string]error
+	if targets != nil {
+		p.API.LogInfo("Syncing only the listed employees", "emails", len(targets))
+		employees, missing, lookupFailures, err = p.loadEmployeesByEmail(ctx, targets)
+	} else {
+		employees, err = p.loadEmployeesForSync(ctx)
+	}
 	if err != nil {
-		p.API.LogError("Failed to check if custom_chat_id field exists", "error", err)
-		http.Error(w, fmt.Sprintf("Failed to check if custom_chat_id field exists: %s", err.Error()), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if !exists {
-		p.API.LogInfo("Creating custom_chat_id field in ERPNext")
+	// Build response data structure with enhanced tracking
+	result := syncresult.New(directionERPToMM)
+	if targets != nil {
+		result.MarkTargeted()
+	}
 
-		// Create the custom field
-		err = p.erpNextClient.CreateCustomField(
-			"custom_chat_id",   // Field name
-			"Workdone User ID", // Label
-			"Employee",         // Document type
-			"Data",             // Field type
-			false,              // Not required
-		)
+	// Stream per-employee results as NDJSON when requested, otherwise collect them for the JSON response
+	stream := p.streamSyncResults(w, r, result)
+	recordMissingTargets(result, missing, "ERPNext employee")
+	p.recordFailedTargets(result, targets, lookupFailures, directionERPToMM)
 
-		if err != nil {
-			p.API.LogError("Failed to create custom_chat_id field", "error", err)
-			http.Error(w, fmt.Sprintf("Failed to create custom_chat_id field: %s", err.Error()), http.StatusInternalServerError)
-			return
+	p.runEmployeeSync(ctx, r.Header.Get("Mattermost-User-ID"), startTime, employees, result)
+
+	// Streamed responses already carry every result line, finish with the summary
+	if stream != nil {
+		if err := stream.WriteSummary(result); err != nil {
+			p.API.LogError("Failed to stream sync summary", "error", err)
 		}
+		return
+	}
 
-		p.API.LogInfo("Successfully created custom_chat_id field in ERPNext")
-	} else {
-		p.API.LogInfo("custom_chat_id field already exists in ERPNext")
+	// Return the results as JSON, or CSV when requested
+	p.writeSyncResult(w, r, result)
+}
+
+// loadEmployeesForSync makes sure the chat ID field exists and returns the ERPNext employees to
+// sync, most important first. Inactive employees with a chat ID are included when their accounts
+// are deactivated.
+func (p *Plugin) loadEmployeesForSync(ctx context.Context) ([]erpnext.Employee, error) {
+	// Check if the chat ID field exists, and create it if it doesn't
+	if _, err := p.ensureChatIDField(p.erpNextClient); err != nil {
+		p.API.LogError("Failed to prepare chat ID field", "error", err)
+		return nil, err
 	}
 
 	// Fetch all employees from ERPNext (now with enhanced pagination)
 	p.API.LogInfo("Fetching ERPNext employees with enhanced pagination")
-	employees, err := p.erpNextClient.GetEmployees()
+	client := p.erpNextClient.WithContext(ctx)
+	employees, err := client.GetEmployees()
 	if err != nil {
 		p.API.LogError("Failed to fetch employees from ERPNext", "error", err)
-		http.Error(w, fmt.Sprintf("Failed to fetch employees: %s", err.Error()), http.StatusInternalServerError)
-		return
+		return nil, errors.Wrap(err, "failed to fetch employees")
+	}
+
+	// Employees that left still have to reach the sync for their accounts to be deactivated
+	if p.getConfiguration().getInactiveEmployeeMode() == inactiveEmployeeDeactivate {
+		inactive, err := client.GetInactiveMappedEmployees()
+		if err != nil {
+			p.API.LogError("Failed to fetch inactive employees from ERPNext", "error", err)
+			return nil, errors.Wrap(err, "failed to fetch inactive employees")
+		}
+		employees = append(employees, inactive...)
 	}
 
 	// Log summary of employees fetched
 	p.API.LogInfo(fmt.Sprintf("Fetched %d employees from ERPNext", len(employees)))
 
-	// Build response data structure with enhanced tracking
-	type SyncResult struct {
-		MatchedCount   int      `json:"matched_count"`
-		UpdatedCount   int      `json:"updated_count"`
-		CreatedCount   int      `json:"created_count"`
-		SkippedCount   int      `json:"skipped_count"`
-		UserResults    []string `json:"user_results"`
-		TotalProcessed int      `json:"total_processed"`
-		TimedOut       bool     `json:"timed_out"`
-		ProcessingTime string   `json:"processing_time"`
-	}
+	// Process the most important accounts first in case the run times out
+	p.orderEmployeesForSync(employees)
+	return employees, nil
+}
 
-	result := SyncResult{
-		UserResults: []string{},
+// runEmployeeSync syncs employees to Mattermost within ctx, recording every record and the run
+// itself. actorID is the admin shown live progress, empty for none.
+func (p *Plugin) runEmployeeSync(ctx context.Context, actorID string, startTime time.Time, employees []erpnext.Employee, result *syncresult.Result) {
+
+	// Deactivation waits for the run to stop at its next employee
+	defer p.syncs.begin()()
+
+	result.SetMaxEntries(p.getConfiguration().MaxResultEntries)
+
+	breaker := newCircuitBreaker(p.getConfiguration().getCircuitBreakerThreshold())
+
+	// A sample of the written chat IDs is re-read once the run is done, when configured
+	verifier := newSyncVerifier(p.getConfiguration().VerifySamplePercent)
+
+	// Guardrails against runaway provisioning and deactivation, mappings continue once they
+	// are reached
+	maxNewAccounts := p.getConfiguration().getMaxNewAccountsPerRun()
+	maxDeactivations := p.getConfiguration().getMaxDeactivationsPerRun()
+	limits := &employeeSyncLimits{
+		newAccounts:   newRunLimit(maxNewAccounts),
+		deactivations: newRunLimit(maxDeactivations),
 	}
+	creationLimitReported := false
+	deactivationLimitReported := false
+
+	// Live progress for the admin watching the sync
+	progress := p.newProgressReporter(actorID, directionERPToMM, len(employees))
+	progress.Start(result)
 
 	// Process each employee with enhanced progress tracking
 	for i, employee := range employees {
+		// Stop cleanly when the plugin is being deactivated
+		if p.syncs.stopping() {
+			p.API.LogWarn("Plugin is shutting down, stopping employee sync", "processed_employees", i)
+			reason := fmt.Sprintf("the plugin is shutting down, stopped after %d employees", i)
+			result.RecordNote("ABORTED: " + reason)
+			result.MarkAborted(reason)
+			break
+		}
+
 		// Check for timeout
-		if time.Since(startTime) > maxDuration {
+		if time.Since(startTime) > employeeSyncMaxDuration {
 			p.API.LogWarn("Employee sync operation reached maximum duration, stopping", "processed_employees", i)
-			result.UserResults = append(result.UserResults,
-				fmt.Sprintf("TIMEOUT: Sync stopped after processing %d employees due to timeout", i))
-			result.TimedOut = true
+			result.RecordNote(fmt.Sprintf("TIMEOUT: Sync stopped after processing %d employees due to timeout", i))
+			result.MarkTimedOut()
 			break
 		}
 
@@ -512,202 +481,345 @@ func (p *Plugin) SyncEmployees(w http.ResponseWriter, r *http.Request) {
 				i, len(employees), float64(i)/float64(len(employees))*100, elapsed))
 		}
 
-		// Skip if employee has no company email
-		if employee.CompanyEmail == "" {
-			p.API.LogDebug("Skipping employee with no company email", "employee_id", employee.Name)
-			result.SkippedCount++
-			result.UserResults = append(result.UserResults,
-				fmt.Sprintf("%s %s (%s) - Skipped (No Email)", employee.FirstName, employee.LastName, employee.Name))
-			continue
+		res := p.syncRecordWithTimeout(ctx, fmt.Sprintf("%s %s (%s)", employee.FirstName, employee.LastName, employee.CompanyEmail), func(ctx context.Context) recordSyncResult {
+			return p.syncEmployeeToMattermost(ctx, employee, limits)
+		})
+		if res.SkipReason == skipReasonCreationLimit && !creationLimitReported {
+			p.API.LogWarn("New account limit reached, no further Mattermost accounts will be created this run", "limit", maxNewAccounts)
+			result.RecordNote(fmt.Sprintf("LIMIT: Stopped creating accounts after %d new accounts (MaxNewAccountsPerRun), existing accounts are still mapped", maxNewAccounts))
+			creationLimitReported = true
 		}
+		if res.SkipReason == skipReasonDeactivationLimit && !deactivationLimitReported {
+			p.API.LogWarn("Deactivation limit reached, no further Mattermost accounts will be deactivated this run", "limit", maxDeactivations)
+			result.RecordNote(fmt.Sprintf("LIMIT: Stopped deactivating accounts after %d deactivations (MaxDeactivationsPerRun), review the inactive employees in ERPNext", maxDeactivations))
+			deactivationLimitReported = true
+		}
+		if res.Err != nil {
+			p.recordSyncFailure(directionERPToMM, employee.CompanyEmail, res.Err)
+		}
+		res.recordTo(result)
+		verifier.observe(res)
+		progress.Processed(result)
+
+		// Stop early when ERPNext is down instead of failing every remaining record the same way
+		if breaker.record(res.Err) {
+			reason := fmt.Sprintf("ERPNext appears unavailable, aborted after %d employees", i+1)
+			p.API.LogError("Aborting sync after consecutive ERPNext failures", "processed_employees", i+1, "error", res.Err.Error())
+			result.RecordNote("ABORTED: " + reason)
+			result.MarkAborted(reason)
+			break
+		}
+	}
 
-		// Skip if employee status is not Active
-		if employee.Status != "Active" {
-			p.API.LogDebug("Skipping inactive employee", "employee_id", employee.Name, "status", employee.Status)
-			result.SkippedCount++
-			result.UserResults = append(result.UserResults,
-				fmt.Sprintf("%s %s (%s) - Skipped (Inactive)", employee.FirstName, employee.LastName, employee.Name))
-			continue
+	// Confirm a sample of the writes actually stuck, even when the run stopped for running out of time
+	p.verifySyncWrites(context.WithoutCancel(ctx), verifier, result)
+
+	// Managers are resolved once every employee had the chance to be mapped
+	if p.getConfiguration().SyncManager && !result.Aborted && !result.TimedOut {
+		p.syncManagerAttributes(employees, result)
+	}
+
+	// Set final tracking values
+	budget := erpnext.RetryBudgetFromContext(ctx)
+	result.RecordRetryBudget(budget.Used(), budget.Limit())
+	result.Finish()
+	progress.Done(result)
+	p.recordCompletedSync(directionERPToMM, startTime, result)
+	p.API.LogInfo(fmt.Sprintf("Employee sync completed in %s. %s", result.ProcessingTime, result.Summary()), "correlation_id", result.CorrelationID)
+	if actorID == "" {
+		p.alertOnSyncProblems(result)
+	}
+}
+
+// streamSyncResults sets result up to stream its entries as NDJSON when the client asked for it,
+// returning nil when the results are sent once the sync is done
+func (p *Plugin) streamSyncResults(w http.ResponseWriter, r *http.Request, result *syncresult.Result) *resultStream {
+	if !wantsResultStream(r) {
+		return nil
+	}
+
+	stream := newResultStream(w)
+	failuresOnly := wantsFailuresOnly(r)
+	result.SetEntryHandler(func(entry syncresult.Entry) {
+		if failuresOnly && entry.Status != syncresult.StatusFailed {
+			return
 		}
+		if err := stream.WriteResult(entry.Message); err != nil {
+			p.API.LogError("Failed to stream sync result", "error", err)
+		}
+	})
+	return stream
+}
 
-		// Check if this employee already has a Mattermost account mapped
-		if employee.CustomChatID != "" {
-			// Check if the user still exists in Mattermost
-			user, appErr := p.API.GetUser(employee.CustomChatID)
-			if appErr == nil && user != nil && user.DeleteAt == 0 {
-				// User exists and is not deleted
-				result.MatchedCount++
-				result.UserResults = append(result.UserResults,
-					fmt.Sprintf("%s %s (%s) - Already Mapped", employee.FirstName, employee.LastName, employee.CompanyEmail))
-				continue
-			}
+// CheckSyncState reports the sync state of a single email address across Mattermost and ERPNext
+func (p *Plugin) CheckSyncState(w http.ResponseWriter, r *http.Request) {
+	email := strings.TrimSpace(r.URL.Query().Get("email"))
+	if email == "" {
+		http.Error(w, "Missing required query parameter: email", http.StatusBadRequest)
+		return
+	}
 
-			// If we get here, the mapped user doesn't exist or is deleted
-			// We'll try to find a user by email or create a new one
-			p.API.LogDebug("Mapped user no longer exists, will search for existing or create new",
-				"employee_email", employee.CompanyEmail, "old_user_id", employee.CustomChatID)
+	if p.erpNextClient == nil {
+		p.API.LogError("ERPNext client is not configured")
+		http.Error(w, "ERPNext client is not configured properly. Please check the plugin settings.", http.StatusInternalServerError)
+		return
+	}
+
+	// Per-aspect status returned to the caller
+	type AspectStatus struct {
+		OK     bool   `json:"ok"`
+		ID     string `json:"id,omitempty"`
+		Detail string `json:"detail"`
+	}
+
+	type CheckResult struct {
+		SchemaVersion    int          `json:"schema_version"`
+		Email            string       `json:"email"`
+		MattermostUser   AspectStatus `json:"mattermost_user"`
+		ERPNextEmployee  AspectStatus `json:"erpnext_employee"`
+		ERPNextUser      AspectStatus `json:"erpnext_user"`
+		EmployeeToMMLink AspectStatus `json:"employee_to_mattermost_link"`
+		MMToEmployeeLink AspectStatus `json:"mattermost_to_employee_link"`
+		FullySynced      bool         `json:"fully_synced"`
+	}
+
+	result := CheckResult{SchemaVersion: syncresult.SchemaVersion, Email: email}
+
+	// Check against the ERPNext instance serving this email domain
+	client := p.erpNextClientForEmail(email)
+	if client == nil {
+		http.Error(w, fmt.Sprintf("No ERPNext instance is configured for the domain of %s", email), http.StatusBadRequest)
+		return
+	}
+
+	// Mattermost user
+	mmUser, appErr := p.API.GetUserByEmail(email)
+	switch {
+	case appErr != nil && appErr.StatusCode != http.StatusNotFound:
+		result.MattermostUser.Detail = fmt.Sprintf("Lookup failed: %s", appErr.Error())
+	case appErr != nil || mmUser == nil:
+		mmUser = nil
+		result.MattermostUser.Detail = "No Mattermost user with this email"
+	case mmUser.DeleteAt > 0:
+		result.MattermostUser.ID = mmUser.Id
+		result.MattermostUser.Detail = "Mattermost user exists but is deactivated"
+	default:
+		result.MattermostUser.OK = true
+		result.MattermostUser.ID = mmUser.Id
+		result.MattermostUser.Detail = fmt.Sprintf("Found Mattermost user %s", mmUser.Username)
+	}
+
+	// ERPNext employee
+	employee, err := client.GetEmployeeByEmail(email)
+	switch {
+	case err != nil:
+		employee = nil
+		result.ERPNextEmployee.Detail = fmt.Sprintf("Lookup failed: %s", err.Error())
+	case employee == nil:
+		result.ERPNextEmployee.Detail = "No ERPNext employee with this company email"
+	default:
+		result.ERPNextEmployee.OK = true
+		result.ERPNextEmployee.ID = employee.Name
+		result.ERPNextEmployee.Detail = fmt.Sprintf("Found employee with status %s", employee.Status)
+	}
+
+	// ERPNext user
+	erpUser, err := client.GetUserByEmail(email)
+	switch {
+	case err != nil:
+		result.ERPNextUser.Detail = fmt.Sprintf("Lookup failed: %s", err.Error())
+	case erpUser == nil:
+		result.ERPNextUser.Detail = "No ERPNext user with this email"
+	default:
+		result.ERPNextUser.OK = true
+		result.ERPNextUser.ID = erpUser.Name
+		result.ERPNextUser.Detail = "Found ERPNext user"
+	}
+
+	// Employee -> Mattermost: the employee's chat ID field must point at a live Mattermost user with this email
+	chatIDField := client.ChatIDFieldName()
+	switch {
+	case employee == nil:
+		result.EmployeeToMMLink.Detail = "No employee to check"
+	case employee.CustomChatID == "":
+		result.EmployeeToMMLink.Detail = fmt.Sprintf("Employee has no %s", chatIDField)
+	default:
+		result.EmployeeToMMLink.ID = employee.CustomChatID
+		linkedUser, linkErr := p.API.GetUser(employee.CustomChatID)
+		switch {
+		case linkErr != nil || linkedUser == nil:
+			result.EmployeeToMMLink.Detail = fmt.Sprintf("%s points to a Mattermost user that does not exist", chatIDField)
+		case linkedUser.DeleteAt > 0:
+			result.EmployeeToMMLink.Detail = fmt.Sprintf("%s points to a deactivated Mattermost user", chatIDField)
+		case !strings.EqualFold(linkedUser.Email, email):
+			result.EmployeeToMMLink.Detail = fmt.Sprintf("%s points to a different Mattermost user (%s)", chatIDField, linkedUser.Email)
+		default:
+			result.EmployeeToMMLink.OK = true
+			result.EmployeeToMMLink.Detail = fmt.Sprintf("%s points to the matching Mattermost user", chatIDField)
 		}
+	}
 
-		// Try multiple approaches to find a Mattermost user with the same email
-		var existingUser *model.User = nil
-		var appErr *model.AppError = nil
+	// Mattermost -> Employee: the Mattermost user with this email must be the one stored on the employee
+	switch {
+	case mmUser == nil:
+		result.MMToEmployeeLink.Detail = "No Mattermost user to check"
+	case employee == nil:
+		result.MMToEmployeeLink.Detail = "No employee to check"
+	case employee.CustomChatID != mmUser.Id:
+		result.MMToEmployeeLink.Detail = fmt.Sprintf("Mattermost user %s is not stored on employee %s", mmUser.Id, employee.Name)
+	default:
+		result.MMToEmployeeLink.OK = true
+		result.MMToEmployeeLink.ID = employee.Name
+		result.MMToEmployeeLink.Detail = "Mattermost user is linked to the employee"
+	}
 
-		// First try: use GetUserByEmail which is most reliable for exact email matching
-		existingUser, appErr = p.API.GetUserByEmail(employee.CompanyEmail)
+	result.FullySynced = result.MattermostUser.OK && result.ERPNextEmployee.OK && result.ERPNextUser.OK &&
+		result.EmployeeToMMLink.OK && result.MMToEmployeeLink.OK
 
-		// If direct email lookup failed, try search as a fallback
-		if appErr != nil || existingUser == nil {
-			p.API.LogDebug("Direct email lookup failed, trying search", "email", employee.CompanyEmail, "error", appErr)
+	// Return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		p.API.LogError("Failed to encode response", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
 
-			// Try searching with broader criteria
-			userSearchOpts := &model.UserSearch{
-				AllowInactive: false,
-				Term:          employee.CompanyEmail,
-				Limit:         10, // Increased limit to catch more potential matches
-			}
+// RetryFailedSyncs re-processes only the records stored in the dead-letter list by earlier syncs,
+// removing the ones that now succeed
+func (p *Plugin) RetryFailedSyncs(w http.ResponseWriter, r *http.Request) {
+	if p.erpNextClient == nil {
+		p.API.LogError("ERPNext client is not configured")
+		http.Error(w, "ERPNext client is not configured properly. Please check the plugin settings.", http.StatusInternalServerError)
+		return
+	}
 
-			userList, searchErr := p.API.SearchUsers(userSearchOpts)
-
-			if searchErr == nil && len(userList) > 0 {
-				// Look for exact email match in search results
-				for _, user := range userList {
-					if strings.EqualFold(user.Email, employee.CompanyEmail) && user.DeleteAt == 0 {
-						existingUser = user
-						p.API.LogInfo("Found user by search", "user_id", user.Id, "email", user.Email)
-						break
-					}
-				}
-			}
+	entries, err := p.kvstore.GetFailedEntries()
+	if err != nil {
+		p.API.LogError("Failed to load failed sync entries", "error", err)
+		http.Error(w, fmt.Sprintf("Failed to load failed sync entries: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	p.API.LogInfo(fmt.Sprintf("Retrying %d failed sync entries", len(entries)))
+
+	type RetryEntryResult struct {
+		Email     string `json:"email"`
+		Direction string `json:"direction"`
+		Status    string `json:"status"`
+		Message   string `json:"message"`
+	}
+
+	type RetryResult struct {
+		SchemaVersion     int                `json:"schema_version"`
+		RetriedCount      int                `json:"retried_count"`
+		SucceededCount    int                `json:"succeeded_count"`
+		StillFailingCount int                `json:"still_failing_count"`
+		NotFoundCount     int                `json:"not_found_count"`
+		Results           []RetryEntryResult `json:"results"`
+	}
+
+	result := RetryResult{
+		SchemaVersion: syncresult.SchemaVersion,
+		Results:       []RetryEntryResult{},
+	}
+
+	// The retries are a run of their own, with their own retry budget
+	ctx, cancel := p.syncContext(time.Now(), userSyncMaxDuration)
+	defer cancel()
+
+	// Outcome of every retried entry by direction and email, without an entry once it no longer fails
+	type retryOutcome struct {
+		failedAt int64
+		entry    *kvstore.FailedEntry
+	}
+	retried := map[string]retryOutcome{}
+	for _, entry := range entries {
+		result.RetriedCount++
+		entryResult := RetryEntryResult{
+			Email:     entry.Email,
+			Direction: entry.Direction,
 		}
 
-		// Found existing user with matching email
-		if existingUser != nil && existingUser.DeleteAt == 0 {
-			// Update the employee's custom_chat_id in ERPNext
-			updatedEmployee := &erpnext.Employee{
-				Name:         employee.Name,
-				CustomChatID: existingUser.Id,
-			}
+		var res recordSyncResult
+		found := true
 
-			_, err := p.erpNextClient.UpdateEmployee(updatedEmployee)
-			if err != nil {
-				p.API.LogError("Failed to update employee custom_chat_id in ERPNext",
-					"employee_id", employee.Name,
-					"error", err)
-				result.UserResults = append(result.UserResults,
-					fmt.Sprintf("%s %s (%s) - Update Failed: %s", employee.FirstName, employee.LastName, employee.CompanyEmail, err.Error()))
-				continue
+		switch entry.Direction {
+		case directionMMToERP:
+			user, appErr := p.API.GetUserByEmail(entry.Email)
+			if appErr != nil && appErr.StatusCode != http.StatusNotFound {
+				// Only a user that is gone leaves the list, a lookup that failed is retried later
+				res = res.failed(appErr, fmt.Sprintf("%s - Error: %s", entry.Email, appErr.Error()))
+				break
 			}
-
-			result.UpdatedCount++
-			result.UserResults = append(result.UserResults,
-				fmt.Sprintf("%s %s (%s) - Mapped to existing user", employee.FirstName, employee.LastName, employee.CompanyEmail))
-		} else {
-			// Need to create a new Mattermost user
-			p.API.LogInfo("Creating new Mattermost user for ERPNext employee",
-				"employee_name", fmt.Sprintf("%s %s", employee.FirstName, employee.LastName),
-				"email", employee.CompanyEmail)
-
-			// Generate username from name (slug of employee name)
-			username := p.GenerateUsername(employee.FirstName, employee.LastName)
-
-			// Check if username already exists and make it unique if needed
-			for retries := 0; retries < 5; retries++ {
-				_, userErr := p.API.GetUserByUsername(username)
-				if userErr != nil {
-					// Username doesn't exist, we can use it
-					break
-				}
-				// Username exists, add a suffix
-				username = fmt.Sprintf("%s_%d", p.GenerateUsername(employee.FirstName, employee.LastName), retries+1)
+			if user == nil {
+				found = false
+				break
 			}
-
-			// Generate random password
-			password := p.GenerateRandomPassword(12)
-
-			// Create new user with enhanced error handling
-			newUser := &model.User{
-				Email:         employee.CompanyEmail,
-				Username:      username,
-				Password:      password,
-				EmailVerified: true,
-				FirstName:     employee.FirstName,
-				LastName:      employee.LastName,
+			res = p.syncUserToERPNext(ctx, user, false, false)
+		case directionERPToMM:
+			employee, lookupErr := p.erpNextClient.WithContext(ctx).GetEmployeeByEmail(entry.Email)
+			if lookupErr != nil {
+				res = res.failed(lookupErr, fmt.Sprintf("%s - Error: %s", entry.Email, lookupErr.Error()))
+				break
 			}
-
-			createdUser, appErr := p.API.CreateUser(newUser)
-			if appErr != nil {
-				p.API.LogError("Failed to create Mattermost user",
-					"email", employee.CompanyEmail,
-					"username", username,
-					"error", appErr.Error())
-
-				// Try with a different username if it's a username conflict
-				if strings.Contains(appErr.Error(), "username") {
-					// Generate a more unique username
-					timestamp := time.Now().Unix()
-					uniqueUsername := fmt.Sprintf("%s_%d", username, timestamp%10000)
-					newUser.Username = uniqueUsername
-
-					createdUser, appErr = p.API.CreateUser(newUser)
-					if appErr != nil {
-						result.UserResults = append(result.UserResults,
-							fmt.Sprintf("%s %s (%s) - User Creation Failed (retry): %s", employee.FirstName, employee.LastName, employee.CompanyEmail, appErr.Error()))
-						continue
-					}
-					username = uniqueUsername // Update for the response
-				} else {
-					result.UserResults = append(result.UserResults,
-						fmt.Sprintf("%s %s (%s) - User Creation Failed: %s", employee.FirstName, employee.LastName, employee.CompanyEmail, appErr.Error()))
-					continue
-				}
+			if employee == nil {
+				found = false
+				break
 			}
+			res = p.syncEmployeeToMattermost(ctx, *employee, nil)
+		default:
+			found = false
+		}
 
-			// Update the employee's custom_chat_id in ERPNext
-			updatedEmployee := &erpnext.Employee{
-				Name:         employee.Name,
-				CustomChatID: createdUser.Id,
-			}
+		key := entry.Direction + ":" + entry.Email
+		retried[key] = retryOutcome{failedAt: entry.FailedAt}
+
+		switch {
+		case !found:
+			// The record is gone, there is nothing left to retry
+			result.NotFoundCount++
+			entryResult.Status = "not_found"
+			entryResult.Message = fmt.Sprintf("%s - Record no longer exists, removed from retry list", entry.Email)
+		case res.Err != nil:
+			result.StillFailingCount++
+			entryResult.Status = "failed"
+			entryResult.Message = res.Text()
+			failed := entry
+			failed.Error = res.Err.Error()
+			failed.FailedAt = model.GetMillis()
+			retried[key] = retryOutcome{failedAt: entry.FailedAt, entry: &failed}
+		default:
+			result.SucceededCount++
+			entryResult.Status = "succeeded"
+			entryResult.Message = res.Text()
+		}
 
-			_, err := p.erpNextClient.UpdateEmployee(updatedEmployee)
-			if err != nil {
-				p.API.LogError("Failed to update employee custom_chat_id in ERPNext after user creation",
-					"employee_id", employee.Name,
-					"user_id", createdUser.Id,
-					"error", err)
-				result.UserResults = append(result.UserResults,
-					fmt.Sprintf("%s %s (%s) - User Created but Update Failed: %s", employee.FirstName, employee.LastName, employee.CompanyEmail, err.Error()))
+		result.Results = append(result.Results, entryResult)
+	}
+
+	// Syncs finishing meanwhile may have added or replaced entries, those are kept as they are
+	err = p.kvstore.UpdateFailedEntries(func(current []kvstore.FailedEntry) []kvstore.FailedEntry {
+		updated := make([]kvstore.FailedEntry, 0, len(current))
+		for _, entry := range current {
+			outcome, ok := retried[entry.Direction+":"+entry.Email]
+			if !ok || outcome.failedAt != entry.FailedAt {
+				updated = append(updated, entry)
 				continue
 			}
-
-			// Attempt to send email notification with credentials
-			emailSuccess := p.SendCredentialEmail(employee.CompanyEmail, username, password)
-
-			// Add credentials to result details with email status
-			emailStatus := ""
-			if emailSuccess {
-				emailStatus = " (Email sent)"
-			} else {
-				emailStatus = " (Email delivery attempted)"
+			if outcome.entry != nil {
+				updated = append(updated, *outcome.entry)
 			}
-
-			result.CreatedCount++
-			result.UserResults = append(result.UserResults,
-				fmt.Sprintf("%s %s (%s) - New User Created%s\nUsername: %s\nPassword: %s",
-					employee.FirstName, employee.LastName, employee.CompanyEmail,
-					emailStatus, username, password))
 		}
+		return updated
+	})
+	if err != nil {
+		p.API.LogError("Failed to update failed sync entries", "error", err)
+		http.Error(w, fmt.Sprintf("Failed to update failed sync entries: %s", err.Error()), http.StatusInternalServerError)
+		return
 	}
 
-	// Set final tracking values
-	result.TotalProcessed = result.MatchedCount + result.UpdatedCount + result.CreatedCount + result.SkippedCount
-	result.ProcessingTime = time.Since(startTime).String()
-
-	// Create response summary
-	summary := fmt.Sprintf(
-		"Employee sync completed in %s. Total Processed: %d, Matched: %d, Updated: %d, Created: %d, Skipped: %d, Timed Out: %v",
-		result.ProcessingTime, result.TotalProcessed, result.MatchedCount, result.UpdatedCount, result.CreatedCount, result.SkippedCount, result.TimedOut,
-	)
-	p.API.LogInfo(summary)
+	p.API.LogInfo(fmt.Sprintf("Retry of failed entries completed. Retried: %d, Succeeded: %d, Still Failing: %d, Not Found: %d",
+		result.RetriedCount, result.SucceededCount, result.StillFailingCount, result.NotFoundCount))
 
 	// Return JSON response
 	w.Header().Set("Content-Type", "application/json")
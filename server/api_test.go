@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/erpnext"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// allowLogs lets the mock API accept any log call, whatever the number of key-value pairs
+func allowLogs(api *plugintest.API) {
+	for _, method := range []string{"LogDebug", "LogInfo", "LogWarn", "LogError"} {
+		args := []interface{}{mock.Anything}
+		for i := 0; i < 10; i++ {
+			api.On(method, args...).Maybe()
+			args = append(args, mock.Anything)
+		}
+	}
+}
+
+// useMemoryKVStore backs p's KV store with an in-memory map through the mock API, honoring
+// compare-and-set writes, and returns the map
+func useMemoryKVStore(p *Plugin, api *plugintest.API) map[string][]byte {
+	data := map[string][]byte{}
+	api.On("KVGet", mock.AnythingOfType("string")).Return(
+		func(key string) []byte { return data[key] },
+		func(string) *model.AppError { return nil },
+	).Maybe()
+	api.On("KVSetWithOptions", mock.AnythingOfType("string"), mock.Anything, mock.Anything).Return(
+		func(key string, value []byte, options model.PluginKVSetOptions) bool {
+			if options.Atomic && !bytes.Equal(data[key], options.OldValue) {
+				return false
+			}
+			if value == nil {
+				delete(data, key)
+				return true
+			}
+			data[key] = value
+			return true
+		},
+		func(string, []byte, model.PluginKVSetOptions) *model.AppError { return nil },
+	).Maybe()
+
+	p.kvstore = kvstore.NewKVStore(pluginapi.NewClient(api, nil))
+	return data
+}
+
+// runAdminAuthorization runs a request through AdminAuthorizationRequired and reports the
+// response and whether the next handler was reached
+func runAdminAuthorization(p *Plugin, r *http.Request) (*httptest.ResponseRecorder, bool) {
+	called := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	p.AdminAuthorizationRequired(w, r, next)
+	return w, called
+}
+
+func TestAdminAuthorizationRequired(t *testing.T) {
+	t.Run("missing user ID", func(t *testing.T) {
+		api := &plugintest.API{}
+		allowLogs(api)
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/sync/mm-to-erp", nil)
+		w, called := runAdminAuthorization(p, r)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.False(t, called)
+	})
+
+	t.Run("GetUser error", func(t *testing.T) {
+		api := &plugintest.API{}
+		allowLogs(api)
+		api.On("GetUser", "user-id").Return(nil, model.NewAppError("GetUser", "app.user.get.app_error", nil, "", http.StatusInternalServerError))
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/sync/mm-to-erp", nil)
+		r.Header.Set("Mattermost-User-ID", "user-id")
+		w, called := runAdminAuthorization(p, r)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.False(t, called)
+		api.AssertExpectations(t)
+	})
+
+	t.Run("non-admin user", func(t *testing.T) {
+		api := &plugintest.API{}
+		allowLogs(api)
+		api.On("GetUser", "user-id").Return(&model.User{Id: "user-id", Roles: model.SystemUserRoleId}, nil)
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/sync/mm-to-erp", nil)
+		r.Header.Set("Mattermost-User-ID", "user-id")
+		w, called := runAdminAuthorization(p, r)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.False(t, called)
+		api.AssertExpectations(t)
+	})
+
+	t.Run("system admin", func(t *testing.T) {
+		api := &plugintest.API{}
+		allowLogs(api)
+		api.On("GetUser", "admin-id").Return(&model.User{Id: "admin-id", Roles: model.SystemUserRoleId + " " + model.SystemAdminRoleId}, nil)
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/sync/mm-to-erp", nil)
+		r.Header.Set("Mattermost-User-ID", "admin-id")
+		w, called := runAdminAuthorization(p, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, called)
+		api.AssertExpectations(t)
+	})
+}
+
+func TestRetryFailedSyncsKeepsEntriesWhoseLookupFailed(t *testing.T) {
+	api := &plugintest.API{}
+	allowLogs(api)
+	api.On("GetUserByEmail", "gone@example.com").Return(nil, model.NewAppError("GetUserByEmail", "app.user.missing_account.const", nil, "", http.StatusNotFound))
+	api.On("GetUserByEmail", "flaky@example.com").Return(nil, model.NewAppError("GetUserByEmail", "app.user.get_by_email.app_error", nil, "", http.StatusInternalServerError))
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{})
+	p.erpNextClient = erpnext.NewClient("http://erpnext.invalid", "key", "secret")
+	useMemoryKVStore(p, api)
+
+	for _, email := range []string{"gone@example.com", "flaky@example.com"} {
+		require.NoError(t, p.kvstore.AddFailedEntry(kvstore.FailedEntry{Email: email, Direction: directionMMToERP, Error: "boom", FailedAt: 1}))
+	}
+
+	w := httptest.NewRecorder()
+	p.RetryFailedSyncs(w, httptest.NewRequest(http.MethodPost, "/api/v1/sync/retry-failed", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	entries, err := p.kvstore.GetFailedEntries()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "flaky@example.com", entries[0].Email)
+	assert.Greater(t, entries[0].FailedAt, int64(1))
+}
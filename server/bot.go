@@ -0,0 +1,82 @@
+package main
+
+import (
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// botUsername is the username of the plugin's bot account
+const botUsername = "erpsync"
+
+// ensureBot makes sure the plugin's bot account exists and stores its user ID. All automated
+// posts from the plugin are made as this bot.
+func (p *Plugin) ensureBot() error {
+	botUserID, err := p.client.Bot.EnsureBot(&model.Bot{
+		Username:    botUsername,
+		DisplayName: "ERPNext Sync",
+		Description: "Created by the ERPNext Integration plugin.",
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to ensure bot account")
+	}
+
+	p.botUserID = botUserID
+	return nil
+}
+
+// postAsBot creates a post in a channel as the plugin bot. The post's UserId is always the bot.
+func (p *Plugin) postAsBot(post *model.Post) (*model.Post, error) {
+	if p.botUserID == "" {
+		return nil, errors.New("bot account is not set up")
+	}
+
+	post.UserId = p.botUserID
+	created, appErr := p.API.CreatePost(post)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to create bot post")
+	}
+	return created, nil
+}
+
+// dmAsBot sends a direct message from the plugin bot to a user
+func (p *Plugin) dmAsBot(userID, message string) error {
+	if p.botUserID == "" {
+		return errors.New("bot account is not set up")
+	}
+
+	channel, appErr := p.API.GetDirectChannel(p.botUserID, userID)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to open direct channel")
+	}
+
+	_, err := p.postAsBot(&model.Post{
+		ChannelId: channel.Id,
+		Message:   message,
+	})
+	return err
+}
+
+// dmFileAsBot sends a direct message from the plugin bot to a user with data attached as
+// filename, for reports that only that user may see
+func (p *Plugin) dmFileAsBot(userID, message, filename string, data []byte) error {
+	if p.botUserID == "" {
+		return errors.New("bot account is not set up")
+	}
+
+	channel, appErr := p.API.GetDirectChannel(p.botUserID, userID)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to open direct channel")
+	}
+
+	fileInfo, appErr := p.API.UploadFile(data, channel.Id, filename)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to upload file")
+	}
+
+	_, err := p.postAsBot(&model.Post{
+		ChannelId: channel.Id,
+		Message:   message,
+		FileIds:   []string{fileInfo.Id},
+	})
+	return err
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/erpnext"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/syncresult"
+	"github.com/pkg/errors"
+)
+
+// pendingChatIDWrite is an employee chat ID write waiting in a chatIDBatch
+type pendingChatIDWrite struct {
+	client       *erpnext.Client
+	employeeName string
+	chatID       string
+	email        string
+
+	// label identifies the user in the result message when the write fails
+	label string
+}
+
+// chatIDBatch collects chat ID writes so they can be sent through the bulk update API together.
+// The results of the batched records are held back until the batch is written.
+type chatIDBatch struct {
+	size    int
+	pending []recordSyncResult
+}
+
+// newChatIDBatch returns a batch of the given size, or nil when batching is disabled
+func newChatIDBatch(size int) *chatIDBatch {
+	if size <= 0 {
+		return nil
+	}
+	return &chatIDBatch{size: size}
+}
+
+// add queues a result with a pending chat ID write and reports whether the batch is full
+func (b *chatIDBatch) add(res recordSyncResult) bool {
+	b.pending = append(b.pending, res)
+	return len(b.pending) >= b.size
+}
+
+// flushChatIDBatch writes every pending chat ID within ctx, grouped by ERPNext instance, and
+// records the held back results, showing them to verifier. Records whose write failed are
+// recorded as failures.
+func (p *Plugin) flushChatIDBatch(ctx context.Context, batch *chatIDBatch, result *syncresult.Result, verifier *syncVerifier) {
+	if batch == nil || len(batch.pending) == 0 {
+		return
+	}
+
+	// Each instance gets its own bulk update. The records hold their own copies of the
+	// instance's client, so instances are told apart by URL.
+	clients := map[string]*erpnext.Client{}
+	chatIDsByInstance := map[string]map[string]string{}
+	for _, res := range batch.pending {
+		write := res.PendingChatID
+		if chatIDsByInstance[write.client.URL] == nil {
+			clients[write.client.URL] = write.client.WithContext(ctx)
+			chatIDsByInstance[write.client.URL] = map[string]string{}
+		}
+		chatIDsByInstance[write.client.URL][write.employeeName] = write.chatID
+	}
+
+	failedByInstance := map[string]error{}
+	for instanceURL, chatIDs := range chatIDsByInstance {
+		p.API.LogInfo("Writing batched employee chat IDs", "count", len(chatIDs))
+		if err := clients[instanceURL].UpdateEmployeesChatIDs(chatIDs); err != nil {
+			p.API.LogError("Failed to write some batched employee chat IDs", "error", err.Error())
+			failedByInstance[instanceURL] = err
+		}
+	}
+
+	for _, res := range batch.pending {
+		write := res.PendingChatID
+		res.PendingChatID = nil
+
+		client := clients[write.client.URL]
+		if err := chatIDWriteError(failedByInstance[write.client.URL], write.employeeName); err != nil {
+			// The chat ID write was the update, so nothing for this record succeeded
+			p.recordSyncFailure(directionMMToERP, write.email, err)
+			res.Outcome = outcomeNone
+			res = res.failed(err, fmt.Sprintf("%s - Update Failed: %s", write.label, err.Error()))
+		} else {
+			if note := p.addSyncComment(client, write.employeeName, syncCommentUpdated); note != "" {
+				res.Notes = append(res.Notes, note)
+			}
+			res.ChatIDClaim = &chatIDClaim{client: client, employeeName: write.employeeName, chatID: write.chatID, label: write.label}
+		}
+		res.recordTo(result)
+		verifier.observe(res)
+	}
+	batch.pending = nil
+}
+
+// chatIDWriteError returns the error for a single employee from the error of its batch
+func chatIDWriteError(batchErr error, employeeName string) error {
+	if batchErr == nil {
+		return nil
+	}
+	var bulkErr *erpnext.BulkUpdateError
+	if errors.As(batchErr, &bulkErr) {
+		return bulkErr.Failed[employeeName]
+	}
+	return batchErr
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/mattermost/mattermost-plugin-starter-template/server/erpnext"
+)
+
+// circuitBreaker stops a sync once ERPNext looks unavailable, instead of letting every remaining
+// record fail the same way until the timeout
+type circuitBreaker struct {
+	threshold   int
+	consecutive int
+}
+
+// newCircuitBreaker returns a breaker that trips after threshold consecutive connectivity or
+// auth failures. A threshold of 0 disables it.
+func newCircuitBreaker(threshold int) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold}
+}
+
+// record counts the outcome of one record and reports whether the breaker has tripped. Only
+// errors that mean ERPNext is unavailable count; anything else resets the run of failures.
+func (b *circuitBreaker) record(err error) bool {
+	if !erpnext.IsUnavailableError(err) {
+		b.consecutive = 0
+		return false
+	}
+
+	b.consecutive++
+	return b.threshold > 0 && b.consecutive >= b.threshold
+}
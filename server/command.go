@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/erpnext"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/syncresult"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/pkg/errors"
+)
+
+const commandTrigger = "erpsync"
+
+// unmappedInlineLimit is the longest unmapped list shown inline; longer lists are sent as a file
+const unmappedInlineLimit = 50
+
+// mapUsersInlineMaxRunes is the longest mapusers report returned inline. Mattermost cuts messages
+// off at model.PostMessageMaxRunesV2, so longer reports are attached as a file instead, leaving
+// headroom for the summary around it.
+const mapUsersInlineMaxRunes = 15000
+
+// registerCommands registers the plugin's slash command with its autocomplete data
+func (p *Plugin) registerCommands() error {
+	autocomplete := model.NewAutocompleteData(commandTrigger, "[command]", "ERPNext sync commands")
+	autocomplete.AddCommand(model.NewAutocompleteData("unmapped", "", "List active ERPNext employees that are not mapped to a Mattermost user"))
+	autocomplete.AddCommand(model.NewAutocompleteData("setup", "", "Create the chat ID field and role profiles in ERPNext ahead of the first sync"))
+	mapUsers := model.NewAutocompleteData("mapusers", "[dry]", "Map Mattermost users to ERPNext employees; add 'dry' to preview without writing")
+	mapUsers.AddStaticListArgument("", false, []model.AutocompleteListItem{
+		{Item: "dry", HelpText: "Show what would be done without writing to ERPNext"},
+	})
+	autocomplete.AddCommand(mapUsers)
+	autocomplete.AddCommand(model.NewAutocompleteData("pause", "[minutes]", "Pause the scheduled sync, e.g. during ERPNext maintenance"))
+	autocomplete.AddCommand(model.NewAutocompleteData("resume", "", "Resume the scheduled sync"))
+	syncDirection := model.NewAutocompleteData("syncdirection", "[direction]", "Show or set what the hourly scheduled sync does")
+	syncDirection.AddStaticListArgument("", false, []model.AutocompleteListItem{
+		{Item: directionMMToERP, HelpText: "Sync Mattermost users to ERPNext"},
+		{Item: directionERPToMM, HelpText: "Sync ERPNext employees to Mattermost"},
+		{Item: scheduledDirectionBoth, HelpText: "Sync both ways, Mattermost to ERPNext first"},
+		{Item: scheduledDirectionOff, HelpText: "Don't sync on schedule"},
+	})
+	autocomplete.AddCommand(syncDirection)
+	autocomplete.AddCommand(model.NewAutocompleteData("syncstats", "[runs]", fmt.Sprintf("Summarize the last sync runs (default %d)", defaultSyncStatsRuns)))
+
+	err := p.API.RegisterCommand(&model.Command{
+		Trigger:          commandTrigger,
+		AutoComplete:     true,
+		AutoCompleteDesc: "ERPNext sync commands. Available: unmapped, setup, mapusers, syncstats, syncdirection, pause, resume",
+		AutoCompleteHint: "[command]",
+		DisplayName:      "ERPNext Sync",
+		AutocompleteData: autocomplete,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to register command")
+	}
+	return nil
+}
+
+// ExecuteCommand handles the /erpsync slash command
+func (p *Plugin) ExecuteCommand(_ *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	fields := strings.Fields(args.Command)
+	if len(fields) == 0 || fields[0] != "/"+commandTrigger {
+		return ephemeralResponse(fmt.Sprintf("Unknown command: %s", args.Command)), nil
+	}
+
+	// Every sync command exposes HR data or changes accounts, so they are all admin-only
+	if !p.API.HasPermissionTo(args.UserId, model.PermissionManageSystem) {
+		return ephemeralResponse("Only system admins can use this command."), nil
+	}
+
+	subcommand := ""
+	if len(fields) > 1 {
+		subcommand = fields[1]
+	}
+
+	switch subcommand {
+	case "unmapped":
+		return p.executeUnmappedCommand(args), nil
+	case "setup":
+		return p.executeSetupCommand(), nil
+	case "mapusers":
+		dryRun := len(fields) > 2 && fields[2] == "dry"
+		return p.executeMapUsersCommand(args, dryRun), nil
+	case "syncstats":
+		limit := defaultSyncStatsRuns
+		if len(fields) > 2 {
+			n, err := strconv.Atoi(fields[2])
+			if err != nil || n <= 0 {
+				return ephemeralResponse(fmt.Sprintf("Usage: /%s syncstats [runs], where runs is a positive number", commandTrigger)), nil
+			}
+			limit = n
+		}
+		return p.executeSyncStatsCommand(limit), nil
+	case "pause":
+		var duration time.Duration
+		if len(fields) > 2 {
+			minutes, err := strconv.Atoi(fields[2])
+			if err != nil || minutes <= 0 {
+				return ephemeralResponse(fmt.Sprintf("Usage: /%s pause [minutes], where minutes is a positive number", commandTrigger)), nil
+			}
+			duration = time.Duration(minutes) * time.Minute
+		}
+		pause, err := p.pauseScheduledSyncs(args.UserId, duration)
+		if err != nil {
+			return ephemeralResponse(fmt.Sprintf("Failed to pause scheduled syncs: %s", err.Error())), nil
+		}
+		return ephemeralResponse(describeSyncPause(pause)), nil
+	case "resume":
+		if err := p.resumeScheduledSyncs(args.UserId); err != nil {
+			return ephemeralResponse(fmt.Sprintf("Failed to resume scheduled syncs: %s", err.Error())), nil
+		}
+		return ephemeralResponse(describeSyncPause(nil)), nil
+	case "syncdirection":
+		return p.executeSyncDirectionCommand(args.UserId, fields[2:]), nil
+	default:
+		return ephemeralResponse(fmt.Sprintf("Usage: /%s unmapped|setup|mapusers [dry]|syncstats [runs]|syncdirection [direction]|pause [minutes]|resume", commandTrigger)), nil
+	}
+}
+
+// executeSyncDirectionCommand shows the scheduled sync direction, or sets it when one is given
+func (p *Plugin) executeSyncDirectionCommand(userID string, args []string) *model.CommandResponse {
+	if len(args) == 0 {
+		direction, err := p.scheduledSyncDirection()
+		if err != nil {
+			return ephemeralResponse(fmt.Sprintf("Failed to read the scheduled sync direction: %s", err.Error()))
+		}
+		return ephemeralResponse(describeScheduledDirection(direction))
+	}
+
+	direction := strings.ToLower(args[0])
+	valid := false
+	for _, candidate := range validScheduledDirections {
+		if direction == candidate {
+			valid = true
+		}
+	}
+	if !valid || len(args) > 1 {
+		return ephemeralResponse(fmt.Sprintf("Usage: /%s syncdirection [%s]", commandTrigger, strings.Join(validScheduledDirections, "|")))
+	}
+
+	if err := p.setScheduledSyncDirection(userID, direction); err != nil {
+		return ephemeralResponse(fmt.Sprintf("Failed to set the scheduled sync direction: %s", err.Error()))
+	}
+	return ephemeralResponse(describeScheduledDirection(direction))
+}
+
+// executeUnmappedCommand lists active ERPNext employees without a chat ID, across every instance
+func (p *Plugin) executeUnmappedCommand(args *model.CommandArgs) *model.CommandResponse {
+	if p.erpNextClient == nil {
+		return ephemeralResponse("ERPNext client is not configured properly. Please check the plugin settings.")
+	}
+
+	var unmapped []erpnext.Employee
+	for _, instance := range p.erpNextInstances {
+		employees, err := p.erpNextClients[instance.Name].WithContext(p.syncs.context()).GetEmployees()
+		if err != nil {
+			p.API.LogError("Failed to fetch employees for unmapped command", "instance", instance.Name, "error", err)
+			return ephemeralResponse(fmt.Sprintf("Failed to fetch employees from ERPNext instance '%s': %s", instance.Name, err.Error()))
+		}
+		for _, employee := range employees {
+			if employee.CustomChatID == "" && strings.EqualFold(employee.Status, "Active") {
+				unmapped = append(unmapped, employee)
+			}
+		}
+	}
+
+	if len(unmapped) == 0 {
+		return ephemeralResponse("Every active ERPNext employee is mapped to a Mattermost user.")
+	}
+
+	if len(unmapped) <= unmappedInlineLimit {
+		var b strings.Builder
+		fmt.Fprintf(&b, "#### %d unmapped ERPNext employees\n\n", len(unmapped))
+		b.WriteString("| Employee | Name | Email |\n|---|---|---|\n")
+		for _, employee := range unmapped {
+			fmt.Fprintf(&b, "| %s | %s | %s |\n", employee.Name, employeeDisplayName(employee), employee.CompanyEmail)
+		}
+		return ephemeralResponse(b.String())
+	}
+
+	// Long lists are sent as CSV, only to the admin who asked since they hold HR data
+	data, err := unmappedEmployeesCSV(unmapped)
+	if err != nil {
+		p.API.LogError("Failed to build unmapped employees CSV", "error", err)
+		return ephemeralResponse(fmt.Sprintf("Failed to build the employee list: %s", err.Error()))
+	}
+
+	message := fmt.Sprintf("%d active ERPNext employees are not mapped to a Mattermost user. The full list is attached.", len(unmapped))
+	if err := p.dmFileAsBot(args.UserId, message, "unmapped-employees.csv", data); err != nil {
+		p.API.LogError("Failed to send unmapped employees list", "error", err.Error())
+		return ephemeralResponse(fmt.Sprintf("Failed to send the employee list: %s", err.Error()))
+	}
+
+	return ephemeralResponse(fmt.Sprintf("%d active ERPNext employees are not mapped to a Mattermost user. The full list was sent to you as a direct message from @%s.", len(unmapped), botUsername))
+}
+
+// executeMapUsersCommand runs the mm→erp mapping for every active Mattermost user and returns
+// the result as a Markdown table. With dryRun nothing is written to ERPNext and rows read
+// "Would create"/"Would update".
+func (p *Plugin) executeMapUsersCommand(args *model.CommandArgs, dryRun bool) *model.CommandResponse {
+	if p.erpNextClient == nil {
+		return ephemeralResponse("ERPNext client is not configured properly. Please check the plugin settings.")
+	}
+
+	// A dry run must not create the custom field or role profiles either
+	if !dryRun {
+		if err := p.prepareERPNextInstances(); err != nil {
+			return ephemeralResponse(fmt.Sprintf("Failed to prepare ERPNext: %s", err.Error()))
+		}
+	}
+
+	users, truncated, err := p.fetchActiveUsers()
+	if err != nil {
+		return ephemeralResponse(err.Error())
+	}
+	p.orderUsersForSync(users)
+
+	result := syncresult.New(directionMMToERP)
+	if truncated {
+		result.MarkTruncated()
+	}
+
+	// The same run as the API sync, with its deadline, circuit breaker and shutdown handling
+	startTime := time.Now()
+	ctx, cancel := p.syncContext(startTime, userSyncMaxDuration)
+	defer cancel()
+	p.runUserSync(ctx, args.UserId, startTime, users, result, dryRun)
+
+	title := "#### Mapped Mattermost users to ERPNext"
+	if dryRun {
+		title = "#### Dry run: nothing was written to ERPNext"
+	}
+	report := title + "\n\n" + result.Markdown()
+	if utf8.RuneCountInString(report) <= mapUsersInlineMaxRunes {
+		return ephemeralResponse(report)
+	}
+
+	// A cut-off table would silently lose rows, so the full report is attached and the counts,
+	// which always cover the whole run, shown. It names users, so only the admin who asked gets it.
+	message := fmt.Sprintf("%s\n\n%s\n\nThe full results are attached.", title, result.Summary())
+	if err := p.dmFileAsBot(args.UserId, message, "mapusers-results.md", []byte(report)); err != nil {
+		p.API.LogError("Failed to send mapusers results", "error", err.Error())
+		return ephemeralResponse(fmt.Sprintf("%s\n\n%s\n\nThe full results were too long to show and could not be sent: %s", title, result.Summary(), err.Error()))
+	}
+
+	return ephemeralResponse(fmt.Sprintf("%s\n\n%s\n\nThe full results were too long to show and were sent to you as a direct message from @%s.", title, result.Summary(), botUsername))
+}
+
+// executeSetupCommand prepares every ERPNext instance for syncing: the chat ID custom field, the
+// default role profile, every mapped role profile and the sync source field of created users when
+// configured. Reports what was created or already present.
+func (p *Plugin) executeSetupCommand() *model.CommandResponse {
+	if p.erpNextClient == nil {
+		return ephemeralResponse("ERPNext client is not configured properly. Please check the plugin settings.")
+	}
+
+	config := p.getConfiguration()
+	roleProfiles := []string{config.getDefaultRoleProfile()}
+	for _, mapping := range config.getRoleProfileMappings() {
+		roleProfiles = append(roleProfiles, mapping.Profile)
+	}
+
+	var b strings.Builder
+	b.WriteString("#### ERPNext setup\n\n| Instance | Item | Status |\n|---|---|---|\n")
+
+	for _, instance := range p.erpNextInstances {
+		client := p.erpNextClients[instance.Name]
+
+		created, err := p.ensureChatIDField(client)
+		fmt.Fprintf(&b, "| %s | Field `%s` | %s |\n", instance.Name, client.ChatIDFieldName(), setupStatus(created, err))
+
+		seen := map[string]bool{}
+		for _, roleProfile := range roleProfiles {
+			if seen[roleProfile] {
+				continue
+			}
+			seen[roleProfile] = true
+
+			created, err := p.ensureRoleProfile(client, roleProfile)
+			fmt.Fprintf(&b, "| %s | Role profile `%s` | %s |\n", instance.Name, roleProfile, setupStatus(created, err))
+		}
+
+		if sourceField, _ := config.getCreatedBySource(); sourceField != "" {
+			created, err := p.ensureCreatedBySourceField(client, sourceField)
+			fmt.Fprintf(&b, "| %s | User field `%s` | %s |\n", instance.Name, sourceField, setupStatus(created, err))
+		}
+	}
+
+	return ephemeralResponse(b.String())
+}
+
+// setupStatus describes the outcome of an ensure step for the setup report
+func setupStatus(created bool, err error) string {
+	switch {
+	case err != nil:
+		return fmt.Sprintf("Failed: %s", err.Error())
+	case created:
+		return "Created"
+	default:
+		return "Already present"
+	}
+}
+
+// unmappedEmployeesCSV renders employees as CSV with a header row
+func unmappedEmployeesCSV(employees []erpnext.Employee) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+
+	if err := writer.Write([]string{"employee", "name", "email"}); err != nil {
+		return nil, err
+	}
+	for _, employee := range employees {
+		if err := writer.Write([]string{employee.Name, employeeDisplayName(employee), employee.CompanyEmail}); err != nil {
+			return nil, err
+		}
+	}
+
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+// employeeDisplayName returns the employee's full name as ERPNext composed it, falling back to
+// joining first and last name when employee_name wasn't fetched
+func employeeDisplayName(employee erpnext.Employee) string {
+	if name := strings.TrimSpace(employee.EmployeeName); name != "" {
+		return name
+	}
+	return localFullName(employee.FirstName, employee.LastName)
+}
+
+// ephemeralResponse returns a command response only the caller can see
+func ephemeralResponse(text string) *model.CommandResponse {
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         text,
+	}
+}
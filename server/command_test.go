@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/erpnext"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// useSingleInstance makes p's ERPNext client its only instance, as a single configured
+// connection does
+func useSingleInstance(p *Plugin) {
+	p.erpNextInstances = []erpNextInstance{{Name: defaultERPNextInstanceName}}
+	p.erpNextClients = map[string]*erpnext.Client{defaultERPNextInstanceName: p.erpNextClient}
+}
+
+func TestUnmappedListIsSentOnlyToTheAdmin(t *testing.T) {
+	api := &plugintest.API{}
+	allowLogs(api)
+	api.On("GetDirectChannel", "bot-id", "admin-id").Return(&model.Channel{Id: "dm-id"}, nil)
+	api.On("UploadFile", mock.Anything, "dm-id", "unmapped-employees.csv").Return(&model.FileInfo{Id: "file-id"}, nil)
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.ChannelId == "dm-id" && post.UserId == "bot-id" && len(post.FileIds) == 1
+	})).Return(&model.Post{}, nil).Once()
+	p := &Plugin{botUserID: "bot-id"}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{})
+	newERPNextStub(t, p, func(w http.ResponseWriter, r *http.Request) {
+		employees := make([]map[string]string, 0, unmappedInlineLimit+1)
+		for i := 0; i <= unmappedInlineLimit; i++ {
+			employees = append(employees, map[string]string{"name": fmt.Sprintf("HR-EMP-%d", i), "status": "Active"})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": employees})
+	})
+	useSingleInstance(p)
+
+	response := p.executeUnmappedCommand(&model.CommandArgs{UserId: "admin-id", ChannelId: "town-square"})
+
+	require.NotNil(t, response)
+	assert.Equal(t, model.CommandResponseTypeEphemeral, response.ResponseType)
+	assert.Contains(t, response.Text, "sent to you as a direct message")
+	api.AssertExpectations(t)
+	api.AssertNotCalled(t, "UploadFile", mock.Anything, "town-square", mock.Anything)
+}
+
+func TestMapUsersRunsLikeASync(t *testing.T) {
+	api := &plugintest.API{}
+	allowLogs(api)
+	api.On("GetUsers", mock.Anything).Return([]*model.User{
+		{Id: "a", Username: "a", Email: "a@example.com", FirstName: "A"},
+		{Id: "b", Username: "b", Email: "b@example.com", FirstName: "B"},
+	}, nil)
+	p := &Plugin{syncs: newSyncTracker()}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{})
+	newERPNextStub(t, p, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("no record is synced once the plugin is shutting down, got %s %s", r.Method, r.URL.Path)
+	})
+
+	// Like every sync run, mapusers stops at its next user once the plugin shuts down
+	p.syncs.cancel()
+	response := p.executeMapUsersCommand(&model.CommandArgs{UserId: "admin-id"}, true)
+
+	require.NotNil(t, response)
+	assert.Contains(t, response.Text, "the plugin is shutting down, stopped after 0 users")
+}
+
+func TestLongMapUsersResultsAreSentOnlyToTheAdmin(t *testing.T) {
+	api := &plugintest.API{}
+	allowLogs(api)
+	users := make([]*model.User, 0, 150)
+	for i := 0; i < 150; i++ {
+		name := fmt.Sprintf("employee.with.a.rather.long.username.%03d", i)
+		users = append(users, &model.User{Id: fmt.Sprintf("user-%03d", i), Username: name, Email: name + "@example.com", FirstName: "Employee"})
+	}
+	api.On("GetUsers", mock.Anything).Return(users, nil)
+	api.On("GetDirectChannel", "bot-id", "admin-id").Return(&model.Channel{Id: "dm-id"}, nil)
+	api.On("UploadFile", mock.Anything, "dm-id", "mapusers-results.md").Return(&model.FileInfo{Id: "file-id"}, nil)
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.ChannelId == "dm-id" && post.UserId == "bot-id" && len(post.FileIds) == 1
+	})).Return(&model.Post{}, nil).Once()
+	p := &Plugin{botUserID: "bot-id", syncs: newSyncTracker()}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{})
+	newERPNextStub(t, p, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data": []}`))
+	})
+
+	response := p.executeMapUsersCommand(&model.CommandArgs{UserId: "admin-id", ChannelId: "town-square"}, true)
+
+	require.NotNil(t, response)
+	assert.Equal(t, model.CommandResponseTypeEphemeral, response.ResponseType)
+	assert.Contains(t, response.Text, "sent to you as a direct message")
+	api.AssertExpectations(t)
+	api.AssertNotCalled(t, "UploadFile", mock.Anything, "town-square", mock.Anything)
+}
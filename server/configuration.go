@@ -1,7 +1,15 @@
 package main
 
 import (
+	"encoding/json"
+	"path"
 	"reflect"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/erpnext"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
 )
 
 // configuration captures the plugin's external configuration as exposed in the Mattermost server
@@ -11,6 +19,361 @@ type configuration struct {
 	ERPNextURL       string
 	ERPNextAPIKey    string
 	ERPNextAPISecret string
+
+	// CreateUserMaxRetries bounds how many times a Mattermost CreateUser call is retried
+	// when it fails with a transient (server-side) error.
+	CreateUserMaxRetries int
+
+	// DefaultLastName is used as the Mattermost last name for employees that have none in ERPNext.
+	// Leaving it empty keeps the last name empty.
+	DefaultLastName string
+
+	// AutomationToken is an optional static bearer token that authorizes calls to the sync
+	// endpoints without a system admin session, for use by scripts and scheduled jobs.
+	AutomationToken string
+
+	// DefaultRoleProfile is the ERPNext role profile given to synced users whose Mattermost
+	// roles have no entry in RoleProfileMapping.
+	DefaultRoleProfile string
+
+	// RoleProfileMapping maps Mattermost roles to ERPNext role profiles, one "role=profile"
+	// pair per line (or comma separated). Earlier entries take precedence.
+	RoleProfileMapping string
+
+	// ERPNextInstances optionally configures additional ERPNext connections as a JSON list of
+	// {"name", "url", "key", "secret", "domainPattern"} objects. Users are routed to the first
+	// instance whose domain pattern matches their email domain.
+	ERPNextInstances string
+
+	// TagCreatedUsers stores erp_sync preferences on Mattermost users created by the plugin,
+	// recording that the plugin provisioned them and from which employee.
+	TagCreatedUsers bool
+
+	// DefaultFieldValues is a JSON object of Employee field values used to retry employee creation
+	// when ERPNext reports those fields as mandatory, e.g. {"company": "My Company"}.
+	DefaultFieldValues string
+
+	// ExcludedEmails lists email addresses that are never synced to ERPNext, comma or newline separated.
+	ExcludedEmails string
+
+	// ExcludedDomains lists email domains that are never synced to ERPNext, comma or newline separated.
+	// Entries may be globs ("*.contractors.example.com") or suffixes (".example.com").
+	ExcludedDomains string
+
+	// ExcludedUsernames lists Mattermost usernames that are never synced to ERPNext, e.g.
+	// integration accounts, comma or newline separated. Entries may be globs ("svc_*").
+	ExcludedUsernames string
+
+	// SyncProfileImages sets the ERPNext employee photo as the profile image of Mattermost users
+	// created by the erp→mm sync.
+	SyncProfileImages bool
+
+	// PushProfileImages uploads Mattermost profile images to ERPNext as the employee image during
+	// the mm→erp sync, when the employee has no image or a different one.
+	PushProfileImages bool
+
+	// SyncOrder is the order records are processed in: "none" (as returned), "email", or
+	// "admins_first" (system admins first, then by email).
+	SyncOrder string
+
+	// CircuitBreakerThreshold is the number of consecutive connectivity or auth failures after
+	// which a sync is aborted. 0 disables the breaker.
+	CircuitBreakerThreshold int
+
+	// ERPNextChatIDField is the Employee field that stores the Mattermost user ID, for instances
+	// that already have their own field for it. Defaults to custom_chat_id.
+	ERPNextChatIDField string
+
+	// CreatedUserAuthService, when set (e.g. "saml"), creates Mattermost users for ERPNext employees
+	// as SSO accounts with their email as AuthData, without a password or credential email.
+	CreatedUserAuthService string
+
+	// HTTPMaxIdleConnsPerHost, HTTPMaxConnsPerHost and HTTPIdleConnTimeoutSeconds tune connection
+	// pooling to ERPNext. Zero values use erpnext.DefaultTransportSettings.
+	HTTPMaxIdleConnsPerHost    int
+	HTTPMaxConnsPerHost        int
+	HTTPIdleConnTimeoutSeconds int
+
+	// WelcomeMessageEnabled sends WelcomeMessage as a bot DM to users created by the erp→mm sync.
+	WelcomeMessageEnabled bool
+
+	// WelcomeMessage is the Markdown welcome text; {{username}} and {{first_name}} are substituted.
+	WelcomeMessage string
+
+	// ERPNextReadTimeoutSeconds and ERPNextReadMaxRetries control reads from ERPNext, which are
+	// safe to retry. A zero timeout uses erpnext.DefaultReadPolicy's, while 0 retries turns read
+	// retries off; only a negative retry count keeps the default.
+	ERPNextReadTimeoutSeconds int
+	ERPNextReadMaxRetries     int
+
+	// ERPNextWriteTimeoutSeconds and ERPNextWriteMaxRetries control creates and updates. Writes
+	// are only retried when ERPNext certainly didn't apply them. Zero timeout uses the default,
+	// a negative retry count keeps the default of no retries.
+	ERPNextWriteTimeoutSeconds int
+	ERPNextWriteMaxRetries     int
+
+	// ERPNextUserExtraFields is a JSON object of extra fields sent when creating ERPNext users,
+	// e.g. {"user_type": "System User"}. Reserved fields such as email can't be overridden.
+	ERPNextUserExtraFields string
+
+	// KVRetentionDays is how long stored sync data (failed records, results, ...) is kept before
+	// the scheduled job prunes it. 0 keeps data forever.
+	KVRetentionDays int
+
+	// SyncMatchKey is how Mattermost users are matched to employees in the mm→erp sync: "email",
+	// or "employee_number" to match the user attribute EmployeeNumberAttribute against the
+	// employee's employee_number. Users can edit that attribute, so a number only matches an
+	// employee already mapped to the user or carrying their email.
+	SyncMatchKey string
+
+	// EmployeeNumberAttribute is the Mattermost user Props key holding the employee number.
+	EmployeeNumberAttribute string
+
+	// MaxUserPages is a safety bound on the pages of 200 Mattermost users fetched by the mm→erp
+	// sync. Reaching it marks the result as truncated.
+	MaxUserPages int
+
+	// NewEmployeeStatus is the status of employees created by the mm→erp sync, e.g. "Inactive"
+	// to hold them for HR review. Must be a valid ERPNext employee status; defaults to Active.
+	NewEmployeeStatus string
+
+	// ProgressEventInterval is how many records pass between WebSocket progress events sent to
+	// the admin running a sync. 0 disables the events.
+	ProgressEventInterval int
+
+	// ChatIDFieldInListView, ChatIDFieldInStandardFilter and ChatIDFieldInGlobalSearch set where
+	// the chat ID custom field shows up in ERPNext when the plugin creates it. Leaving it out of
+	// global search keeps the search index smaller on large instances.
+	ChatIDFieldInListView       bool
+	ChatIDFieldInStandardFilter bool
+	ChatIDFieldInGlobalSearch   bool
+
+	// SetPersonalEmail also fills personal_email on employees created by the mm→erp sync, for
+	// ERPNext workflows that notify via personal_email. PersonalEmailAttribute is the Mattermost
+	// user Props key to read it from; empty uses the user's Mattermost email.
+	SetPersonalEmail       bool
+	PersonalEmailAttribute string
+
+	// MaxNewAccountsPerRun caps the Mattermost accounts a single erp→mm sync may create, as a
+	// guardrail against runaway provisioning. Existing mappings still happen past the cap.
+	// 0 means unlimited.
+	MaxNewAccountsPerRun int
+
+	// MaxDeactivationsPerRun caps the Mattermost accounts a single erp→mm sync may deactivate
+	// under InactiveEmployeeMode "deactivate", so a bad ERPNext import can't lock out everyone.
+	// 0 means unlimited.
+	MaxDeactivationsPerRun int
+
+	// ERPNextUseCSRF fetches a CSRF token from ERPNext and sends it with every write, for
+	// hardened sites that reject token-authenticated writes without one.
+	ERPNextUseCSRF bool
+
+	// IncludeGuests makes the mm→erp sync treat Mattermost guest accounts like regular users.
+	// By default guests are skipped, as they are usually external collaborators.
+	IncludeGuests bool
+
+	// DeterministicUsernames derives the fallback parts of generated usernames from the
+	// employee's email and ID instead of randomness, so re-running a sync proposes the same
+	// username for the same person.
+	DeterministicUsernames bool
+
+	// EmailDomainRewrite rewrites email domains when creating ERPNext employees and users, e.g.
+	// "@company.com=@staging.company.com" so a test instance never emails real inboxes. One
+	// "from=to" pair per line (or comma separated). Matching still uses the original email.
+	EmailDomainRewrite string
+
+	// DeriveFirstNameFromEmail uses the email local part as the first name of Mattermost users
+	// without one ("jane.doe@..." becomes "Jane Doe"). Otherwise such users only map onto
+	// existing employees, as ERPNext requires a first name to create one.
+	DeriveFirstNameFromEmail bool
+
+	// SyncPhoneNumbers syncs the employee's cell_number with the Mattermost user Props key
+	// PhoneNumberAttribute: from Mattermost when creating or updating employees, and to
+	// Mattermost in the erp→mm sync.
+	SyncPhoneNumbers     bool
+	PhoneNumberAttribute string
+
+	// SyncPauseAutoResumeMinutes is how long a pause of the scheduled sync lasts before it
+	// resumes by itself. 0 keeps syncs paused until an admin resumes them.
+	SyncPauseAutoResumeMinutes int
+
+	// ChatIDWriteBatchSize batches chat ID writes to existing employees in the mm→erp sync,
+	// writing this many at a time through ERPNext's bulk update API. 0 writes each one
+	// separately.
+	ChatIDWriteBatchSize int
+
+	// SkipArchivedTeamMembers skips Mattermost users in the mm→erp sync whose only team
+	// memberships are in archived teams, e.g. of departed departments.
+	SkipArchivedTeamMembers bool
+
+	// MatchByName maps employees whose email finds no Mattermost user, or who have no email,
+	// to the single user with exactly the same accent-normalized full name who isn't mapped to
+	// another employee yet
+	MatchByName bool
+
+	// ERPNextRateLimitMinRemaining pauses paged employee reads once ERPNext's
+	// X-RateLimit-Remaining header drops to this value, until X-RateLimit-Reset, waiting at most
+	// ERPNextRateLimitMaxWaitSeconds per page. 0 disables the pacing.
+	ERPNextRateLimitMinRemaining   int
+	ERPNextRateLimitMaxWaitSeconds int
+
+	// AlwaysUpdateEmployees writes employee updates even when they wouldn't change anything,
+	// e.g. to re-trigger ERPNext hooks. By default unchanged employees aren't written.
+	AlwaysUpdateEmployees bool
+
+	// OnboardedStatusField is an Employee field, e.g. a "chat onboarded" check, set to
+	// OnboardedStatusValue once a sync confirms the employee's Mattermost user is live and
+	// mapped. Empty disables it; the value defaults to 1.
+	OnboardedStatusField string
+	OnboardedStatusValue string
+
+	// SyncRetryBudgetSeconds caps the total time a single sync spends waiting to retry ERPNext
+	// calls and Mattermost user creation. Once spent, remaining records fail fast instead of
+	// being retried. 0 means unlimited.
+	SyncRetryBudgetSeconds int
+
+	// EmployeeChildTables is a JSON object of child table rows added to employees created by the
+	// mm→erp sync, keyed by table field, for multi-company instances that need e.g. company
+	// assignments: {"company_assignments": [{"company": "Entity A"}, {"company": "Entity B"}]}.
+	// Empty sends no child tables.
+	EmployeeChildTables string
+
+	// InitialSyncOnActivate runs a full sync in the background when the plugin is activated,
+	// e.g. after an install or upgrade, once ERPNext is confirmed reachable.
+	InitialSyncOnActivate bool
+
+	// NonActiveEmployeeUserPolicy decides what the mm→erp sync does when it would create an
+	// ERPNext login for an employee whose status isn't Active, e.g. staff already marked Left:
+	// "create" (default) creates it as usual, "skip" leaves the employee without a login and
+	// "disabled" creates the login disabled.
+	NonActiveEmployeeUserPolicy string
+
+	// RequireVerifiedEmail skips Mattermost users whose email isn't verified in the mm→erp sync,
+	// so typo'd addresses never become employees or ERPNext logins.
+	RequireVerifiedEmail bool
+
+	// FieldSourceOfTruth is a JSON object naming, per field, which system owns it when both sync
+	// directions are scheduled: {"phone": "erpnext", "image": "mattermost"}. Sources are
+	// "mattermost", "erpnext" or "both", and fields are phone and image. A sync never overwrites
+	// a field on an existing record unless its source system is authoritative for it; new
+	// records are still filled in, as there is nothing to overwrite. Unlisted fields default to
+	// "both", where the last sync to run wins.
+	FieldSourceOfTruth string
+
+	// AddSyncComments adds a comment like "Created by Mattermost ERP Sync on <date>" to the
+	// timeline of every employee the plugin creates or updates, so HR can see automated changes
+	// in ERPNext itself.
+	AddSyncComments bool
+
+	// ReservedUsernames lists usernames the erp→mm sync never creates, comma or newline
+	// separated. Generated usernames that match one get a distinguishing suffix. Mattermost's
+	// own restricted names (all, channel, matterbot, system) are always reserved; empty reserves
+	// here, admin, administrator, root and support on top.
+	ReservedUsernames string
+
+	// DateOfJoiningSource is the date_of_joining of employees created by the mm→erp sync:
+	// "fixed" (default) uses 2000-01-01, "account_created" the date the Mattermost account was
+	// created. Existing employees' dates are never overwritten.
+	DateOfJoiningSource string
+
+	// AdditiveOnly limits both syncs to creating new records and mapping records for the first
+	// time: nothing that already exists is updated or overwritten, and every update that was
+	// left out is reported. The safest mode for risk-averse deployments.
+	AdditiveOnly bool
+
+	// ERPNextResponseShape is how the ERPNext version wraps single documents in responses:
+	// "data" ({"data": {...}}), "message" ({"message": {...}}) or "auto" (default), which
+	// accepts both so upgrades don't break creation.
+	ERPNextResponseShape string
+
+	// AlertChannelID is a channel the plugin bot posts to when a sync nobody is watching, e.g.
+	// the scheduled job's, one triggered with the AutomationToken or the sync on activation,
+	// times out, is aborted or has more than AlertFailureThreshold failures. Empty disables
+	// alerts.
+	AlertChannelID        string
+	AlertFailureThreshold int
+
+	// MaxResultEntries caps the per-record results kept for a sync response, keeping failures in
+	// preference, to bound memory on very large syncs. The counts always cover every record.
+	// 0 keeps every result.
+	MaxResultEntries int
+
+	// ERPNextUserNamingSeries is sent as naming_series when creating ERPNext users, for instances
+	// whose User doctype is named by a series, e.g. "USR-.#####". Empty omits it, for instances
+	// that name users by email.
+	ERPNextUserNamingSeries string
+
+	// CredentialEmailAllowlist limits credential emails to the listed addresses and domains
+	// ("jane@example.com", "example.com"), comma or newline separated, so a staging instance
+	// synced with production-like data never emails real users. Empty sends to everyone.
+	CredentialEmailAllowlist string
+
+	// SyncBranch sets the employee's branch (office location) as a user Props attribute on
+	// Mattermost users created by the erp→mm sync, named by BranchAttribute ("branch" when empty).
+	SyncBranch      bool
+	BranchAttribute string
+
+	// VerifyRoleProfile re-fetches every ERPNext user the sync creates to confirm the role
+	// profile was applied, and sets it with a follow-up update when it wasn't. Some ERPNext
+	// versions ignore role_profile_name on creation and expect the role_profiles child table.
+	VerifyRoleProfile bool
+
+	// ERPNextMaxConcurrentRequests caps the requests each ERPNext client has in flight at once,
+	// however many workers drive it. 0 leaves the number unlimited.
+	ERPNextMaxConcurrentRequests int
+
+	// SyncManager sets the Mattermost username of each employee's manager, from reports_to, as a
+	// user Props attribute named by ManagerAttribute ("manager" when empty) during the erp→mm sync
+	SyncManager      bool
+	ManagerAttribute string
+
+	// VerifySamplePercent is the percentage of the employees a sync wrote a chat ID to that are
+	// re-read from ERPNext once the run is done, reporting any whose chat ID didn't stick.
+	// 0 disables the verification.
+	VerifySamplePercent int
+
+	// UniqueERPNextUsernames checks the username derived from the email local part against the
+	// existing ERPNext users when creating one, and appends a numeric suffix on collision, e.g.
+	// j.smith_1 for j.smith@b.com when j.smith@a.com already took j.smith.
+	UniqueERPNextUsernames bool
+
+	// CreatedBySourceField is a custom field of the ERPNext User doctype, e.g. custom_created_by,
+	// set to CreatedBySourceValue ("mattermost-sync" when empty) on the users the sync creates, so
+	// ERPNext admins can filter automated accounts. The field is created when missing. Empty
+	// disables it.
+	CreatedBySourceField string
+	CreatedBySourceValue string
+
+	// RecordTimeoutSeconds bounds the time a sync spends on a single user or employee. A record
+	// still running after it is counted as failed and the sync moves on. 0 disables the limit.
+	RecordTimeoutSeconds int
+
+	// InactiveEmployeeMode decides what the erp→mm sync does with employees whose status isn't
+	// Active: "skip" (default) leaves them alone, "deactivate" deactivates their mapped live
+	// Mattermost user unless it is a system admin or bot.
+	InactiveEmployeeMode string
+}
+
+// erpNextInstance is a single ERPNext connection parsed from ERPNextInstances.
+type erpNextInstance struct {
+	Name          string `json:"name"`
+	URL           string `json:"url"`
+	Key           string `json:"key"`
+	Secret        string `json:"secret"`
+	DomainPattern string `json:"domainPattern"`
+}
+
+// defaultERPNextInstanceName is the name given to the connection built from the
+// ERPNextURL/ERPNextAPIKey/ERPNextAPISecret settings.
+const defaultERPNextInstanceName = "default"
+
+// defaultRoleProfileName is the ERPNext role profile used when none is configured.
+const defaultRoleProfileName = "Mặc định"
+
+// roleProfileMapping is a single parsed entry of RoleProfileMapping.
+type roleProfileMapping struct {
+	Role    string
+	Profile string
 }
 
 // Clone shallow copies the configuration. Your implementation may require a deep copy if
@@ -20,6 +383,562 @@ func (c *configuration) Clone() *configuration {
 	return &clone
 }
 
+// getCreateUserMaxRetries returns the configured retry count for transient CreateUser
+// failures, treating negative values as "no retries".
+func (c *configuration) getCreateUserMaxRetries() int {
+	if c.CreateUserMaxRetries < 0 {
+		return 0
+	}
+	return c.CreateUserMaxRetries
+}
+
+// getCircuitBreakerThreshold returns the consecutive failure count that aborts a sync,
+// treating negative values as disabled.
+func (c *configuration) getCircuitBreakerThreshold() int {
+	if c.CircuitBreakerThreshold < 0 {
+		return 0
+	}
+	return c.CircuitBreakerThreshold
+}
+
+// getMaxNewAccountsPerRun returns the account creation cap for an erp→mm sync, treating
+// negative values as unlimited (0).
+func (c *configuration) getMaxNewAccountsPerRun() int {
+	if c.MaxNewAccountsPerRun < 0 {
+		return 0
+	}
+	return c.MaxNewAccountsPerRun
+}
+
+// getMaxDeactivationsPerRun returns the deactivation cap for an erp→mm sync, treating negative
+// values as unlimited (0).
+func (c *configuration) getMaxDeactivationsPerRun() int {
+	if c.MaxDeactivationsPerRun < 0 {
+		return 0
+	}
+	return c.MaxDeactivationsPerRun
+}
+
+// getSyncPauseAutoResume returns how long a pause of the scheduled sync lasts, 0 for no limit.
+func (c *configuration) getSyncPauseAutoResume() time.Duration {
+	if c.SyncPauseAutoResumeMinutes <= 0 {
+		return 0
+	}
+	return time.Duration(c.SyncPauseAutoResumeMinutes) * time.Minute
+}
+
+// getRateLimitPacing returns the header-driven pacing of paged ERPNext reads
+func (c *configuration) getRateLimitPacing() erpnext.RateLimitPacing {
+	return erpnext.RateLimitPacing{
+		MinRemaining: c.ERPNextRateLimitMinRemaining,
+		MaxWait:      time.Duration(c.ERPNextRateLimitMaxWaitSeconds) * time.Second,
+	}
+}
+
+// getERPNextResponseShape returns the configured response shape, defaulting to auto-detection
+func (c *configuration) getERPNextResponseShape() string {
+	switch c.ERPNextResponseShape {
+	case erpnext.ResponseShapeData, erpnext.ResponseShapeMessage:
+		return c.ERPNextResponseShape
+	default:
+		return erpnext.ResponseShapeAuto
+	}
+}
+
+// getOnboardedStatusValue returns the value written to OnboardedStatusField, or "" when no
+// field is configured
+func (c *configuration) getOnboardedStatusValue() string {
+	if strings.TrimSpace(c.OnboardedStatusField) == "" {
+		return ""
+	}
+	if value := strings.TrimSpace(c.OnboardedStatusValue); value != "" {
+		return value
+	}
+	return "1"
+}
+
+// getSyncRetryBudget returns the retry wait time allowed per sync, 0 when unlimited
+func (c *configuration) getSyncRetryBudget() time.Duration {
+	if c.SyncRetryBudgetSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(c.SyncRetryBudgetSeconds) * time.Second
+}
+
+// getERPNextChatIDField returns the Employee field storing the Mattermost user ID.
+func (c *configuration) getERPNextChatIDField() string {
+	if field := strings.TrimSpace(c.ERPNextChatIDField); field != "" {
+		return field
+	}
+	return erpnext.DefaultChatIDField
+}
+
+// getCreatedUserAuthService returns the auth service for users created by the erp→mm sync, or ""
+// for regular email/password accounts.
+func (c *configuration) getCreatedUserAuthService() string {
+	return strings.ToLower(strings.TrimSpace(c.CreatedUserAuthService))
+}
+
+// getTransportSettings returns the connection pooling settings for ERPNext clients, using the
+// defaults for anything not configured.
+func (c *configuration) getTransportSettings() erpnext.TransportSettings {
+	settings := erpnext.DefaultTransportSettings
+	if c.HTTPMaxIdleConnsPerHost > 0 {
+		settings.MaxIdleConnsPerHost = c.HTTPMaxIdleConnsPerHost
+	}
+	if c.HTTPMaxConnsPerHost > 0 {
+		settings.MaxConnsPerHost = c.HTTPMaxConnsPerHost
+	}
+	if c.HTTPIdleConnTimeoutSeconds > 0 {
+		settings.IdleConnTimeout = time.Duration(c.HTTPIdleConnTimeoutSeconds) * time.Second
+	}
+	return settings
+}
+
+// getRequestPolicies returns the read and write request policies for ERPNext clients, using the
+// defaults for timeouts that aren't configured and for negative retry counts. 0 retries disables
+// retries.
+func (c *configuration) getRequestPolicies() (read, write erpnext.RequestPolicy) {
+	read = erpnext.DefaultReadPolicy
+	if c.ERPNextReadTimeoutSeconds > 0 {
+		read.Timeout = time.Duration(c.ERPNextReadTimeoutSeconds) * time.Second
+	}
+	if c.ERPNextReadMaxRetries >= 0 {
+		read.MaxRetries = c.ERPNextReadMaxRetries
+	}
+
+	write = erpnext.DefaultWritePolicy
+	if c.ERPNextWriteTimeoutSeconds > 0 {
+		write.Timeout = time.Duration(c.ERPNextWriteTimeoutSeconds) * time.Second
+	}
+	if c.ERPNextWriteMaxRetries >= 0 {
+		write.MaxRetries = c.ERPNextWriteMaxRetries
+	}
+	return read, write
+}
+
+// defaultMaxUserPages allows 100,000 users, far beyond any expected installation.
+const defaultMaxUserPages = 500
+
+// getMaxUserPages returns the page safety bound for fetching Mattermost users.
+func (c *configuration) getMaxUserPages() int {
+	if c.MaxUserPages <= 0 {
+		return defaultMaxUserPages
+	}
+	return c.MaxUserPages
+}
+
+// validEmployeeStatuses are the statuses ERPNext accepts on the Employee doctype.
+var validEmployeeStatuses = []string{"Active", "Inactive", "Suspended", "Left"}
+
+// getNewEmployeeStatus returns the validated status for new employees. An invalid value falls
+// back to Active and is reported in the error.
+func (c *configuration) getNewEmployeeStatus() (string, error) {
+	status := strings.TrimSpace(c.NewEmployeeStatus)
+	if status == "" {
+		return "Active", nil
+	}
+	for _, valid := range validEmployeeStatuses {
+		if strings.EqualFold(status, valid) {
+			return valid, nil
+		}
+	}
+	return "Active", errors.Errorf("NewEmployeeStatus %q is not a valid ERPNext employee status (%s)", status, strings.Join(validEmployeeStatuses, ", "))
+}
+
+// getProgressEventInterval returns the records between progress events, treating negative values
+// as disabled.
+func (c *configuration) getProgressEventInterval() int {
+	if c.ProgressEventInterval < 0 {
+		return 0
+	}
+	return c.ProgressEventInterval
+}
+
+// getChatIDFieldFlags returns the flags used when creating the chat ID custom field.
+func (c *configuration) getChatIDFieldFlags() erpnext.CustomFieldFlags {
+	return erpnext.CustomFieldFlags{
+		InListView:       c.ChatIDFieldInListView,
+		InStandardFilter: c.ChatIDFieldInStandardFilter,
+		InGlobalSearch:   c.ChatIDFieldInGlobalSearch,
+	}
+}
+
+// getDefaultRoleProfile returns the ERPNext role profile for users without a mapped role.
+func (c *configuration) getDefaultRoleProfile() string {
+	if profile := strings.TrimSpace(c.DefaultRoleProfile); profile != "" {
+		return profile
+	}
+	return defaultRoleProfileName
+}
+
+// getRoleProfileMappings parses RoleProfileMapping into ordered role/profile pairs,
+// ignoring blank and malformed entries.
+func (c *configuration) getRoleProfileMappings() []roleProfileMapping {
+	var mappings []roleProfileMapping
+
+	for _, entry := range splitList(c.RoleProfileMapping) {
+		role, profile, found := strings.Cut(entry, "=")
+		role = strings.TrimSpace(role)
+		profile = strings.TrimSpace(profile)
+		if !found || role == "" || profile == "" {
+			continue
+		}
+		mappings = append(mappings, roleProfileMapping{Role: role, Profile: profile})
+	}
+
+	return mappings
+}
+
+// roleProfileForUser selects the ERPNext role profile for a Mattermost user based on the
+// first mapped role the user holds, falling back to the default role profile.
+func (c *configuration) roleProfileForUser(user *model.User) string {
+	userRoles := user.GetRoles()
+	for _, mapping := range c.getRoleProfileMappings() {
+		for _, role := range userRoles {
+			if role == mapping.Role {
+				return mapping.Profile
+			}
+		}
+	}
+	return c.getDefaultRoleProfile()
+}
+
+// getERPNextInstances returns every configured ERPNext connection. The connection built from the
+// single-instance settings, when complete, comes first under the name "default".
+func (c *configuration) getERPNextInstances() ([]erpNextInstance, error) {
+	var instances []erpNextInstance
+
+	if c.ERPNextURL != "" && c.ERPNextAPIKey != "" && c.ERPNextAPISecret != "" {
+		instances = append(instances, erpNextInstance{
+			Name:   defaultERPNextInstanceName,
+			URL:    c.ERPNextURL,
+			Key:    c.ERPNextAPIKey,
+			Secret: c.ERPNextAPISecret,
+		})
+	}
+
+	if strings.TrimSpace(c.ERPNextInstances) == "" {
+		return instances, nil
+	}
+
+	var extra []erpNextInstance
+	if err := json.Unmarshal([]byte(c.ERPNextInstances), &extra); err != nil {
+		return instances, errors.Wrap(err, "failed to parse ERPNext instances")
+	}
+
+	for i, instance := range extra {
+		if instance.Name == "" || instance.URL == "" || instance.Key == "" || instance.Secret == "" {
+			return instances, errors.Errorf("ERPNext instance %d is missing a name, url, key or secret", i+1)
+		}
+		instances = append(instances, instance)
+	}
+
+	return instances, nil
+}
+
+// getDefaultFieldValues parses DefaultFieldValues. Invalid JSON yields no defaults and is
+// reported in the error.
+func (c *configuration) getDefaultFieldValues() (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	if strings.TrimSpace(c.DefaultFieldValues) == "" {
+		return values, nil
+	}
+	if err := json.Unmarshal([]byte(c.DefaultFieldValues), &values); err != nil {
+		return map[string]interface{}{}, errors.Wrap(err, "DefaultFieldValues must be a JSON object")
+	}
+	return values, nil
+}
+
+// getEmployeeChildTables parses EmployeeChildTables, returning nil when it is empty
+func (c *configuration) getEmployeeChildTables() (map[string][]map[string]interface{}, error) {
+	if strings.TrimSpace(c.EmployeeChildTables) == "" {
+		return nil, nil
+	}
+
+	var tables map[string][]map[string]interface{}
+	if err := json.Unmarshal([]byte(c.EmployeeChildTables), &tables); err != nil {
+		return nil, errors.Wrap(err, "EmployeeChildTables must be a JSON object of row lists")
+	}
+	return tables, nil
+}
+
+// getERPNextUserExtraFields parses ERPNextUserExtraFields. Reserved User fields are dropped and
+// reported in the error, along with invalid JSON.
+func (c *configuration) getERPNextUserExtraFields() (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	if strings.TrimSpace(c.ERPNextUserExtraFields) == "" {
+		return values, nil
+	}
+	if err := json.Unmarshal([]byte(c.ERPNextUserExtraFields), &values); err != nil {
+		return map[string]interface{}{}, errors.Wrap(err, "ERPNextUserExtraFields must be a JSON object")
+	}
+	// A JSON null leaves the map nil, callers add to it
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+
+	var reserved []string
+	for _, field := range erpnext.ReservedUserFields {
+		if _, exists := values[field]; exists {
+			reserved = append(reserved, field)
+			delete(values, field)
+		}
+	}
+	if len(reserved) > 0 {
+		return values, errors.Errorf("ERPNextUserExtraFields can't override reserved fields: %s", strings.Join(reserved, ", "))
+	}
+	return values, nil
+}
+
+// Match keys for SyncMatchKey
+const (
+	matchKeyEmail          = "email"
+	matchKeyEmployeeNumber = "employee_number"
+)
+
+// defaultEmployeeNumberAttribute is the user Props key used when EmployeeNumberAttribute is empty.
+const defaultEmployeeNumberAttribute = "employee_number"
+
+// getSyncMatchKey returns the configured match key, defaulting to email.
+func (c *configuration) getSyncMatchKey() string {
+	if c.SyncMatchKey == matchKeyEmployeeNumber {
+		return matchKeyEmployeeNumber
+	}
+	return matchKeyEmail
+}
+
+// employeeNumberForUser reads the employee number from the user's EmployeeNumberAttribute prop.
+func (c *configuration) employeeNumberForUser(user *model.User) string {
+	attribute := strings.TrimSpace(c.EmployeeNumberAttribute)
+	if attribute == "" {
+		attribute = defaultEmployeeNumberAttribute
+	}
+	value, _ := user.GetProp(attribute)
+	return strings.TrimSpace(value)
+}
+
+// personalEmailForUser returns the personal_email for employees created from the user, or ""
+// when SetPersonalEmail is off or the user has no value in PersonalEmailAttribute.
+func (c *configuration) personalEmailForUser(user *model.User) string {
+	if !c.SetPersonalEmail {
+		return ""
+	}
+	attribute := strings.TrimSpace(c.PersonalEmailAttribute)
+	if attribute == "" {
+		return c.rewriteEmailDomain(user.Email)
+	}
+	value, _ := user.GetProp(attribute)
+	return strings.TrimSpace(value)
+}
+
+// rewriteEmailDomain applies the first matching EmailDomainRewrite pair to email, returning it
+// unchanged when no pair matches.
+func (c *configuration) rewriteEmailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+	domain := strings.ToLower(email[at+1:])
+
+	for _, entry := range splitList(c.EmailDomainRewrite) {
+		from, to, found := strings.Cut(entry, "=")
+		from = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(from), "@"))
+		to = strings.TrimPrefix(strings.TrimSpace(to), "@")
+		if !found || from == "" || to == "" {
+			continue
+		}
+		if domain == from {
+			return email[:at+1] + to
+		}
+	}
+	return email
+}
+
+// getRecordTimeout returns the time limit for syncing a single record, 0 for none
+func (c *configuration) getRecordTimeout() time.Duration {
+	if c.RecordTimeoutSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(c.RecordTimeoutSeconds) * time.Second
+}
+
+// defaultCreatedBySourceValue is the CreatedBySourceField value used when CreatedBySourceValue is empty.
+const defaultCreatedBySourceValue = "mattermost-sync"
+
+// getCreatedBySource returns the User field marking ERPNext users created by the sync and the
+// value it is set to. The field is empty when the marking is disabled, or when it names a field
+// the sync always sets itself, which IsValid reports.
+func (c *configuration) getCreatedBySource() (string, string) {
+	value := strings.TrimSpace(c.CreatedBySourceValue)
+	if value == "" {
+		value = defaultCreatedBySourceValue
+	}
+	field := strings.TrimSpace(c.CreatedBySourceField)
+	if erpnext.IsReservedUserField(field) {
+		return "", value
+	}
+	return field, value
+}
+
+// defaultManagerAttribute is the user Props key used when ManagerAttribute is empty.
+const defaultManagerAttribute = "manager"
+
+// getManagerAttribute returns the user Props key holding the username of the user's manager.
+func (c *configuration) getManagerAttribute() string {
+	if attribute := strings.TrimSpace(c.ManagerAttribute); attribute != "" {
+		return attribute
+	}
+	return defaultManagerAttribute
+}
+
+// defaultBranchAttribute is the user Props key used when BranchAttribute is empty.
+const defaultBranchAttribute = "branch"
+
+// getBranchAttribute returns the user Props key holding the employee's branch.
+func (c *configuration) getBranchAttribute() string {
+	if attribute := strings.TrimSpace(c.BranchAttribute); attribute != "" {
+		return attribute
+	}
+	return defaultBranchAttribute
+}
+
+// defaultPhoneNumberAttribute is the user Props key used when PhoneNumberAttribute is empty.
+const defaultPhoneNumberAttribute = "phone_number"
+
+// getPhoneNumberAttribute returns the user Props key holding the phone number.
+func (c *configuration) getPhoneNumberAttribute() string {
+	if attribute := strings.TrimSpace(c.PhoneNumberAttribute); attribute != "" {
+		return attribute
+	}
+	return defaultPhoneNumberAttribute
+}
+
+// phoneNumberForUser returns the user's normalized phone number, or "" when SyncPhoneNumbers is
+// off or the user has no valid number.
+func (c *configuration) phoneNumberForUser(user *model.User) string {
+	if !c.SyncPhoneNumbers {
+		return ""
+	}
+	value, _ := user.GetProp(c.getPhoneNumberAttribute())
+	number, _ := normalizePhoneNumber(value)
+	return number
+}
+
+// phoneNumberSeparators are the characters commonly used to group digits in phone numbers
+var phoneNumberSeparators = strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "", "/", "")
+
+// normalizePhoneNumber strips grouping characters from a phone number and checks that what is
+// left is an optional leading + followed by 6 to 15 digits.
+func normalizePhoneNumber(value string) (string, bool) {
+	number := phoneNumberSeparators.Replace(strings.TrimSpace(value))
+	digits := strings.TrimPrefix(number, "+")
+	if len(digits) < 6 || len(digits) > 15 {
+		return "", false
+	}
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return "", false
+		}
+	}
+	return number, true
+}
+
+// splitList splits a comma or newline separated setting into trimmed, non-empty entries.
+func splitList(value string) []string {
+	var entries []string
+	for _, entry := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == '\n' || r == ','
+	}) {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// isEmailExcluded reports whether an email address matches ExcludedEmails or ExcludedDomains.
+func (c *configuration) isEmailExcluded(email string) bool {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return false
+	}
+
+	for _, excluded := range splitList(c.ExcludedEmails) {
+		if strings.ToLower(excluded) == email {
+			return true
+		}
+	}
+
+	domain := ""
+	if at := strings.LastIndex(email, "@"); at >= 0 {
+		domain = email[at+1:]
+	}
+	if domain == "" {
+		return false
+	}
+
+	for _, pattern := range splitList(c.ExcludedDomains) {
+		pattern = strings.ToLower(strings.TrimPrefix(pattern, "@"))
+		switch {
+		case strings.HasPrefix(pattern, "."):
+			if strings.HasSuffix(domain, pattern) {
+				return true
+			}
+		case strings.ContainsAny(pattern, "*?["):
+			if matched, _ := path.Match(pattern, domain); matched {
+				return true
+			}
+		default:
+			if domain == pattern {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isCredentialEmailAllowed reports whether a credential email may be sent to email, i.e.
+// CredentialEmailAllowlist is empty or lists the address or its domain
+func (c *configuration) isCredentialEmailAllowed(email string) bool {
+	allowlist := splitList(c.CredentialEmailAllowlist)
+	if len(allowlist) == 0 {
+		return true
+	}
+
+	email = strings.ToLower(strings.TrimSpace(email))
+	domain := email[strings.LastIndex(email, "@")+1:]
+	for _, entry := range allowlist {
+		entry = strings.ToLower(entry)
+		if entry == email || strings.TrimPrefix(entry, "@") == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// isUsernameExcluded reports whether a Mattermost username matches ExcludedUsernames.
+func (c *configuration) isUsernameExcluded(username string) bool {
+	username = strings.ToLower(strings.TrimSpace(username))
+	if username == "" {
+		return false
+	}
+
+	for _, pattern := range splitList(c.ExcludedUsernames) {
+		pattern = strings.ToLower(strings.TrimPrefix(pattern, "@"))
+		if strings.ContainsAny(pattern, "*?[") {
+			if matched, _ := path.Match(pattern, username); matched {
+				return true
+			}
+		} else if username == pattern {
+			return true
+		}
+	}
+
+	return false
+}
+
 // getConfiguration retrieves the active configuration under lock, making it safe to use
 // concurrently. The active configuration may change underneath the client of this method, but
 // the struct returned by this API call is considered immutable.
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetERPNextUserExtraFields(t *testing.T) {
+	for _, raw := range []string{"", "null", " null "} {
+		fields, err := (&configuration{ERPNextUserExtraFields: raw}).getERPNextUserExtraFields()
+		require.NoError(t, err, raw)
+		require.NotNil(t, fields, "%q must give a map the sync can add to", raw)
+		fields["custom_created_by"] = "mattermost-sync"
+	}
+
+	fields, err := (&configuration{ERPNextUserExtraFields: `{"user_type": "System User", "email": "x@example.com"}`}).getERPNextUserExtraFields()
+	require.Error(t, err)
+	assert.Equal(t, map[string]interface{}{"user_type": "System User"}, fields)
+}
+
+func TestCreatedBySourceFieldRejectsReservedFields(t *testing.T) {
+	config := &configuration{CreatedBySourceField: " enabled "}
+
+	field, _ := config.getCreatedBySource()
+	assert.Empty(t, field, "a reserved field is never written")
+
+	var validationErr *configValidationError
+	require.True(t, errors.As(config.IsValid(), &validationErr))
+	assert.Contains(t, validationErr.Error(), `CreatedBySourceField "enabled"`)
+
+	field, value := (&configuration{CreatedBySourceField: "custom_created_by"}).getCreatedBySource()
+	assert.Equal(t, "custom_created_by", field)
+	assert.Equal(t, defaultCreatedBySourceValue, value)
+}
+
+func TestGetDefaultFieldValues(t *testing.T) {
+	values, err := (&configuration{DefaultFieldValues: `{"gender": "Prefer not to say"}`}).getDefaultFieldValues()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"gender": "Prefer not to say"}, values)
+
+	config := &configuration{DefaultFieldValues: `{"gender": `}
+	values, err = config.getDefaultFieldValues()
+	require.Error(t, err)
+	assert.Empty(t, values)
+
+	var validationErr *configValidationError
+	require.True(t, errors.As(config.IsValid(), &validationErr))
+	assert.Contains(t, validationErr.Error(), "DefaultFieldValues must be a JSON object")
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/erpnext"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// Modes for the Mattermost accounts of employees that aren't Active, selected by the
+// InactiveEmployeeMode setting
+const (
+	inactiveEmployeeSkip       = "skip"
+	inactiveEmployeeDeactivate = "deactivate"
+)
+
+// preferenceNameDeactivationReason is the erp_sync preference recording why the plugin
+// deactivated a user
+const preferenceNameDeactivationReason = "deactivation_reason"
+
+// getInactiveEmployeeMode returns what the erp→mm sync does with employees that aren't Active,
+// defaulting to skipping them
+func (c *configuration) getInactiveEmployeeMode() string {
+	switch c.InactiveEmployeeMode {
+	case inactiveEmployeeDeactivate:
+		return c.InactiveEmployeeMode
+	default:
+		return inactiveEmployeeSkip
+	}
+}
+
+// deactivateInactiveEmployeeUser deactivates the live Mattermost user mapped to an employee whose
+// status isn't Active. Employees without a live mapped user are skipped as before. System admins
+// and bots are never deactivated, nor anyone once ctx is done or the run reached
+// MaxDeactivationsPerRun.
+func (p *Plugin) deactivateInactiveEmployeeUser(ctx context.Context, employee erpnext.Employee, res recordSyncResult, limits *employeeSyncLimits) recordSyncResult {
+	label := fmt.Sprintf("%s %s (%s)", employee.FirstName, employee.LastName, employee.Name)
+
+	user, appErr := p.API.GetUser(employee.CustomChatID)
+	if appErr != nil && appErr.StatusCode != http.StatusNotFound {
+		return res.failed(appErr, fmt.Sprintf("%s - Deactivation Failed: %s", label, appErr.Error()))
+	}
+	if user == nil || user.DeleteAt != 0 {
+		return res.skipped("Inactive", fmt.Sprintf("%s - Skipped (Inactive)", label))
+	}
+
+	// A wrong chat ID must never lock out an admin or break an integration
+	if user.IsSystemAdmin() || user.IsBot {
+		p.API.LogWarn("Not deactivating system admin or bot mapped to inactive employee",
+			"employee_id", employee.Name,
+			"user_id", user.Id)
+		return res.skipped("Protected User", fmt.Sprintf("%s - Skipped (employee is %s but %s is a system admin or bot, deactivate by hand if intended)", label, employee.Status, user.Username))
+	}
+
+	// Deactivating is a change to an existing account
+	if p.getConfiguration().AdditiveOnly {
+		return res.skipped(skipReasonAdditiveOnly, fmt.Sprintf("%s - Skipped (Additive Only, employee is %s but deactivating %s would change the account)", label, employee.Status, user.Username))
+	}
+
+	if err := ctx.Err(); err != nil {
+		return res.failed(err, fmt.Sprintf("%s - Deactivation Stopped: %s", label, err.Error()))
+	}
+
+	// The run already deactivated as many accounts as MaxDeactivationsPerRun allows
+	if !limits.deactivationSlot() {
+		return res.skipped(skipReasonDeactivationLimit, fmt.Sprintf("%s - Skipped (Deactivation limit reached, %s stays active)", label, user.Username))
+	}
+	if appErr := p.API.UpdateUserActive(user.Id, false); appErr != nil {
+		limits.releaseDeactivationSlot()
+		p.API.LogError("Failed to deactivate Mattermost user of inactive employee",
+			"employee_id", employee.Name,
+			"user_id", user.Id,
+			"error", appErr.Error())
+		return res.failed(appErr, fmt.Sprintf("%s - Deactivation Failed: %s", label, appErr.Error()))
+	}
+
+	p.API.LogInfo("Deactivated Mattermost user of inactive employee",
+		"employee_id", employee.Name,
+		"user_id", user.Id,
+		"status", employee.Status)
+	p.recordDeactivationReason(user.Id, employee)
+	res.Outcome = outcomeDeactivated
+	return res.finished(fmt.Sprintf("%s - Deactivated %s (ERP %s)", label, user.Username, employee.Status))
+}
+
+// recordDeactivationReason records on a deactivated user, via preferences, the ERPNext status that
+// got the account deactivated. Failures are logged but never fail the sync.
+func (p *Plugin) recordDeactivationReason(userID string, employee erpnext.Employee) {
+	preferences := model.Preferences{
+		{
+			UserId:   userID,
+			Category: preferenceCategoryERPSync,
+			Name:     preferenceNameDeactivationReason,
+			Value:    "ERPNext status: " + employee.Status,
+		},
+	}
+
+	if appErr := p.API.UpdatePreferencesForUser(userID, preferences); appErr != nil {
+		p.API.LogWarn("Failed to record why the user was deactivated",
+			"user_id", userID,
+			"employee_id", employee.Name,
+			"error", appErr.Error())
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/erpnext"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/syncresult"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInactiveEmployeeMode(t *testing.T) {
+	employee := erpnext.Employee{Name: "HR-EMP-1", FirstName: "Jane", LastName: "Doe", Status: "Inactive", CompanyEmail: "jane@example.com", CustomChatID: "user-id"}
+
+	t.Run("skips by default", func(t *testing.T) {
+		api := &plugintest.API{}
+		allowLogs(api)
+		p := &Plugin{}
+		p.SetAPI(api)
+		p.setConfiguration(&configuration{})
+
+		res := p.syncEmployeeToMattermost(context.Background(), employee, nil)
+		assert.Equal(t, outcomeSkipped, res.Outcome)
+		api.AssertNotCalled(t, "UpdateUserActive")
+	})
+
+	t.Run("deactivates the mapped user", func(t *testing.T) {
+		api := &plugintest.API{}
+		allowLogs(api)
+		api.On("GetUser", "user-id").Return(&model.User{Id: "user-id", Username: "jane.doe"}, nil)
+		api.On("UpdateUserActive", "user-id", false).Return(nil).Once()
+		api.On("UpdatePreferencesForUser", "user-id", []model.Preference{{
+			UserId:   "user-id",
+			Category: preferenceCategoryERPSync,
+			Name:     preferenceNameDeactivationReason,
+			Value:    "ERPNext status: Inactive",
+		}}).Return(nil).Once()
+		p := &Plugin{}
+		p.SetAPI(api)
+		p.setConfiguration(&configuration{InactiveEmployeeMode: inactiveEmployeeDeactivate})
+
+		res := p.syncEmployeeToMattermost(context.Background(), employee, nil)
+		api.AssertExpectations(t)
+		require.NoError(t, res.Err)
+
+		result := syncresult.New(directionERPToMM)
+		res.recordTo(result)
+		result.Finish()
+		assert.Equal(t, 1, result.DeactivatedCount)
+		assert.Equal(t, 1, result.TotalProcessed)
+		assert.Equal(t, syncresult.StatusDeactivated, result.Entries[0].Status)
+		assert.Equal(t, "Jane Doe (HR-EMP-1) - Deactivated jane.doe (ERP Inactive)", result.Entries[0].Message)
+	})
+
+	t.Run("already deactivated user is skipped", func(t *testing.T) {
+		api := &plugintest.API{}
+		allowLogs(api)
+		api.On("GetUser", "user-id").Return(&model.User{Id: "user-id", DeleteAt: 1}, nil)
+		p := &Plugin{}
+		p.SetAPI(api)
+		p.setConfiguration(&configuration{InactiveEmployeeMode: inactiveEmployeeDeactivate})
+
+		res := p.syncEmployeeToMattermost(context.Background(), employee, nil)
+		assert.Equal(t, outcomeSkipped, res.Outcome)
+		api.AssertNotCalled(t, "UpdateUserActive")
+		api.AssertNotCalled(t, "UpdatePreferencesForUser")
+	})
+
+	t.Run("user mapped to an employee with a malformed email is deactivated", func(t *testing.T) {
+		api := &plugintest.API{}
+		allowLogs(api)
+		api.On("GetUser", "user-id").Return(&model.User{Id: "user-id", Username: "jane.doe"}, nil)
+		api.On("UpdateUserActive", "user-id", false).Return(nil).Once()
+		api.On("UpdatePreferencesForUser", "user-id", mock.Anything).Return(nil)
+		p := &Plugin{}
+		p.SetAPI(api)
+		p.setConfiguration(&configuration{InactiveEmployeeMode: inactiveEmployeeDeactivate})
+
+		malformed := employee
+		malformed.CompanyEmail = "jane at example"
+		res := p.syncEmployeeToMattermost(context.Background(), malformed, nil)
+		require.NoError(t, res.Err)
+		assert.Equal(t, outcomeDeactivated, res.Outcome)
+		api.AssertExpectations(t)
+	})
+
+	for _, protected := range []*model.User{
+		{Id: "user-id", Username: "admin", Roles: model.SystemAdminRoleId + " " + model.SystemUserRoleId},
+		{Id: "user-id", Username: "bot", IsBot: true},
+	} {
+		t.Run(protected.Username+" is never deactivated", func(t *testing.T) {
+			api := &plugintest.API{}
+			allowLogs(api)
+			api.On("GetUser", "user-id").Return(protected, nil)
+			p := &Plugin{}
+			p.SetAPI(api)
+			p.setConfiguration(&configuration{InactiveEmployeeMode: inactiveEmployeeDeactivate})
+
+			res := p.syncEmployeeToMattermost(context.Background(), employee, nil)
+			assert.Equal(t, "Protected User", res.SkipReason)
+			api.AssertNotCalled(t, "UpdateUserActive", mock.Anything, mock.Anything)
+		})
+	}
+
+	t.Run("deactivations stop at the limit", func(t *testing.T) {
+		api := &plugintest.API{}
+		allowLogs(api)
+		api.On("GetUser", mock.Anything).Return(func(id string) *model.User { return &model.User{Id: id, Username: id} }, nil)
+		api.On("UpdateUserActive", mock.Anything, false).Return(nil)
+		api.On("UpdatePreferencesForUser", mock.Anything, mock.Anything).Return(nil)
+		p := &Plugin{}
+		p.SetAPI(api)
+		p.setConfiguration(&configuration{InactiveEmployeeMode: inactiveEmployeeDeactivate})
+		limits := &employeeSyncLimits{deactivations: newRunLimit(1)}
+
+		res := p.syncEmployeeToMattermost(context.Background(), employee, limits)
+		assert.Equal(t, outcomeDeactivated, res.Outcome)
+
+		second := employee
+		second.Name, second.CustomChatID = "HR-EMP-2", "other-user-id"
+		res = p.syncEmployeeToMattermost(context.Background(), second, limits)
+		assert.Equal(t, skipReasonDeactivationLimit, res.SkipReason)
+		api.AssertNumberOfCalls(t, "UpdateUserActive", 1)
+	})
+}
+
+func TestLoadEmployeesForSyncIncludesInactiveMappedEmployees(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/resource/Custom Field":
+			_, _ = w.Write([]byte(`{"data": [{"fieldname": "custom_chat_id", "dt": "Employee"}]}`))
+		case r.URL.Path == "/api/resource/Employee" && strings.Contains(r.URL.Query().Get("filters"), `"!="`):
+			assert.Equal(t, `[["status","!=","Active"],["custom_chat_id","is","set"]]`, r.URL.Query().Get("filters"))
+			_, _ = w.Write([]byte(`{"data": [{"name": "HR-EMP-2", "status": "Left", "custom_chat_id": "user-id"}]}`))
+		case r.URL.Path == "/api/resource/Employee":
+			_, _ = w.Write([]byte(`{"data": [{"name": "HR-EMP-1", "status": "Active"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name     string
+		mode     string
+		expected []string
+	}{
+		{name: "skip mode fetches active employees only", mode: inactiveEmployeeSkip, expected: []string{"HR-EMP-1"}},
+		{name: "deactivate mode also fetches inactive mapped employees", mode: inactiveEmployeeDeactivate, expected: []string{"HR-EMP-1", "HR-EMP-2"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			api := &plugintest.API{}
+			allowLogs(api)
+			p := &Plugin{}
+			p.SetAPI(api)
+			p.setConfiguration(&configuration{InactiveEmployeeMode: tc.mode})
+			p.erpNextClient = erpnext.NewClient(server.URL, "key", "secret")
+
+			employees, err := p.loadEmployeesForSync(context.Background())
+			require.NoError(t, err)
+
+			names := make([]string, 0, len(employees))
+			for _, employee := range employees {
+				names = append(names, employee.Name)
+			}
+			assert.Equal(t, tc.expected, names)
+		})
+	}
+}
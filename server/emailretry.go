@@ -0,0 +1,127 @@
+package main
+
+import (
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+const (
+	// emailRetryBaseDelay is the wait before the first resend of a failed credential email.
+	// Each further attempt doubles the delay.
+	emailRetryBaseDelay = 15 * time.Minute
+
+	// maxEmailRetryAttempts is how often a credential email is resent before giving up
+	maxEmailRetryAttempts = 6
+)
+
+// queueCredentialEmailRetry stores a credential email that failed to send so the scheduled job
+// resends it. Only the user and the address are queued, never the password. Returns false when
+// it couldn't be queued.
+func (p *Plugin) queueCredentialEmailRetry(userID, email string, sendErr error) bool {
+	if p.kvstore == nil {
+		return false
+	}
+
+	now := model.GetMillis()
+	retry := kvstore.EmailRetry{
+		UserID:        userID,
+		Email:         email,
+		LastError:     sendErr.Error(),
+		QueuedAt:      now,
+		NextAttemptAt: now + emailRetryBaseDelay.Milliseconds(),
+	}
+	if err := p.kvstore.AddEmailRetry(retry); err != nil {
+		p.API.LogError("Failed to queue credential email for retry", "email", email, "error", err.Error())
+		return false
+	}
+
+	p.API.LogInfo("Queued credential email for retry", "email", email, "next_attempt_at", retry.NextAttemptAt)
+	return true
+}
+
+// resendQueuedCredentialEmails resends the queued credential emails that are due. The generated
+// password isn't kept and the plugin API can't set a new one, so the resend carries the username
+// and points the user to the password reset page. Emails are dropped once delivered, after
+// maxEmailRetryAttempts, or when they are no longer useful because the user was deleted or has
+// set a password of their own.
+func (p *Plugin) resendQueuedCredentialEmails() {
+	if p.kvstore == nil {
+		return
+	}
+
+	retries, err := p.kvstore.GetEmailRetries()
+	if err != nil {
+		p.API.LogError("Failed to load queued credential emails", "error", err.Error())
+		return
+	}
+	if len(retries) == 0 {
+		return
+	}
+
+	// Outcome of each email handled in this pass, by user. Emails that are not in it were not
+	// due and are left alone, as are emails queued again while this pass was sending.
+	handled := map[string]*kvstore.EmailRetry{}
+	now := model.GetMillis()
+	for _, retry := range retries {
+		if retry.NextAttemptAt > now {
+			continue
+		}
+		handled[retry.UserID] = nil
+
+		user, err := p.client.User.Get(retry.UserID)
+		if err != nil || user.DeleteAt > 0 {
+			p.API.LogInfo("Dropping queued credential email for missing or deactivated user", "email", retry.Email)
+			continue
+		}
+		if user.LastPasswordUpdate > retry.QueuedAt {
+			p.API.LogInfo("Dropping queued credential email, user already changed their password", "email", retry.Email)
+			continue
+		}
+
+		sendErr := p.sendPasswordResetEmail(retry.Email, user.Username)
+		if sendErr == nil {
+			p.API.LogInfo("Resent queued credential email", "email", retry.Email, "attempts", retry.Attempts+1)
+			continue
+		}
+		if errors.Is(sendErr, errEmailSuppressed) {
+			// The allowlist changed since the email was queued, retrying won't help
+			continue
+		}
+
+		retry.Attempts++
+		retry.LastError = sendErr.Error()
+		if retry.Attempts >= maxEmailRetryAttempts {
+			p.API.LogError("Giving up on credential email after repeated failures",
+				"email", retry.Email,
+				"attempts", retry.Attempts,
+				"error", retry.LastError)
+			continue
+		}
+
+		retry.NextAttemptAt = now + (emailRetryBaseDelay << retry.Attempts).Milliseconds()
+		kept := retry
+		handled[retry.UserID] = &kept
+	}
+
+	err = p.kvstore.UpdateEmailRetries(func(current []kvstore.EmailRetry) []kvstore.EmailRetry {
+		updated := make([]kvstore.EmailRetry, 0, len(current))
+		for _, retry := range current {
+			outcome, ok := handled[retry.UserID]
+			if !ok || retry.NextAttemptAt > now {
+				updated = append(updated, retry)
+				continue
+			}
+			if outcome != nil {
+				updated = append(updated, *outcome)
+			}
+		}
+		return updated
+	})
+	if err != nil {
+		p.API.LogError("Failed to save queued credential emails", "error", err.Error())
+	}
+}
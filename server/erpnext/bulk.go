@@ -0,0 +1,137 @@
+package erpnext
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxBulkUpdateDocs bounds the documents sent in a single bulk_update request
+const maxBulkUpdateDocs = 100
+
+// BulkUpdateError lists the employees whose chat ID couldn't be written, even after falling
+// back to updating them one by one
+type BulkUpdateError struct {
+	Failed map[string]error
+}
+
+func (e *BulkUpdateError) Error() string {
+	names := make([]string, 0, len(e.Failed))
+	for name := range e.Failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	details := make([]string, 0, len(names))
+	for _, name := range names {
+		details = append(details, fmt.Sprintf("%s: %s", name, e.Failed[name].Error()))
+	}
+	return fmt.Sprintf("failed to update %d employees: %s", len(e.Failed), strings.Join(details, "; "))
+}
+
+// UpdateEmployeesChatIDs writes many employee name → chat ID pairs through frappe.client.bulk_update,
+// in requests of up to maxBulkUpdateDocs. Employees the bulk request couldn't save, or every
+// employee of a request that failed as a whole, are retried one by one with UpdateEmployee.
+// Returns a *BulkUpdateError naming the employees that still failed.
+func (c *Client) UpdateEmployeesChatIDs(chatIDs map[string]string) error {
+	names := make([]string, 0, len(chatIDs))
+	for name := range chatIDs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	failed := map[string]error{}
+	for start := 0; start < len(names); start += maxBulkUpdateDocs {
+		end := start + maxBulkUpdateDocs
+		if end > len(names) {
+			end = len(names)
+		}
+		chunk := names[start:end]
+
+		retry, err := c.bulkUpdateChatIDs(chunk, chatIDs)
+		if err != nil {
+			fmt.Printf("Bulk employee update failed, falling back to single updates: %s\n", err.Error())
+			retry = chunk
+		}
+
+		for _, name := range retry {
+			if _, err := c.UpdateEmployee(&Employee{Name: name, CustomChatID: chatIDs[name]}); err != nil {
+				failed[name] = err
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		return &BulkUpdateError{Failed: failed}
+	}
+	return nil
+}
+
+// bulkUpdateChatIDs sends one bulk_update request for names and returns the names ERPNext
+// reported as failed
+func (c *Client) bulkUpdateChatIDs(names []string, chatIDs map[string]string) ([]string, error) {
+	docs := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		docs = append(docs, map[string]interface{}{
+			"doctype":           "Employee",
+			"docname":           name,
+			c.ChatIDFieldName(): chatIDs[name],
+		})
+	}
+
+	// bulk_update expects docs as a JSON encoded string
+	docsData, err := json.Marshal(docs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal bulk update docs")
+	}
+	bodyData, err := json.Marshal(map[string]string{"docs": string(docsData)})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal bulk update request")
+	}
+
+	reqURL := fmt.Sprintf("%s/api/method/frappe.client.bulk_update", c.URL)
+	fmt.Printf("Bulk updating %d employees\n", len(names))
+
+	req, err := c.newRequest(http.MethodPost, reqURL, bytes.NewBuffer(bodyData))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create bulk update request")
+	}
+
+	resp, err := c.doWrite(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to execute bulk update request")
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	fmt.Printf("Bulk update response status: %d\n", resp.StatusCode)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ERPNext API returned status code %d for bulk update: %s", resp.StatusCode, string(body))
+	}
+
+	var bulkResp struct {
+		Message struct {
+			FailedDocs []struct {
+				Doc struct {
+					Docname string `json:"docname"`
+				} `json:"doc"`
+			} `json:"failed_docs"`
+		} `json:"message"`
+	}
+	if err := json.Unmarshal(body, &bulkResp); err != nil {
+		return nil, errors.Wrap(err, "failed to decode bulk update response: "+string(body))
+	}
+
+	failed := make([]string, 0, len(bulkResp.Message.FailedDocs))
+	for _, doc := range bulkResp.Message.FailedDocs {
+		failed = append(failed, doc.Doc.Docname)
+	}
+	return failed, nil
+}
@@ -0,0 +1,85 @@
+package erpnext
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// DefaultChatIDField is the Employee custom field that stores the Mattermost user ID
+const DefaultChatIDField = "custom_chat_id"
+
+// ChatIDFieldName returns the Employee field holding the Mattermost user ID on this instance
+func (c *Client) ChatIDFieldName() string {
+	if c.ChatIDField == "" {
+		return DefaultChatIDField
+	}
+	return c.ChatIDField
+}
+
+// employeeFields returns fields with the default chat-id field swapped for the configured one,
+// plus the status field when configured
+func (c *Client) employeeFields(fields []string) []string {
+	mapped := make([]string, 0, len(fields)+1)
+	hasStatusField := false
+	for _, field := range fields {
+		if field == DefaultChatIDField {
+			field = c.ChatIDFieldName()
+		}
+		if field == c.StatusField {
+			hasStatusField = true
+		}
+		mapped = append(mapped, field)
+	}
+	if c.StatusField != "" && !hasStatusField {
+		mapped = append(mapped, c.StatusField)
+	}
+	return mapped
+}
+
+// decodeEmployee decodes a single Employee document, reading CustomChatID from the configured
+// chat-id field and StatusFieldValue from the status field
+func (c *Client) decodeEmployee(raw json.RawMessage) (Employee, error) {
+	var employee Employee
+	if err := json.Unmarshal(raw, &employee); err != nil {
+		return employee, err
+	}
+
+	field := c.ChatIDFieldName()
+	if field == DefaultChatIDField && c.StatusField == "" {
+		return employee, nil
+	}
+
+	var values map[string]interface{}
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return employee, err
+	}
+	if field != DefaultChatIDField {
+		employee.CustomChatID, _ = values[field].(string)
+	}
+	if value, ok := values[c.StatusField]; ok && value != nil && c.StatusField != "" {
+		// Check fields come back as numbers, compare them by their text
+		employee.StatusFieldValue = fmt.Sprint(value)
+	}
+
+	return employee, nil
+}
+
+// decodeEmployeeList decodes the list response shape {"data": [...]}
+func (c *Client) decodeEmployeeList(body []byte) ([]Employee, error) {
+	var listResp struct {
+		Data []json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &listResp); err != nil {
+		return nil, err
+	}
+
+	employees := make([]Employee, 0, len(listResp.Data))
+	for _, raw := range listResp.Data {
+		employee, err := c.decodeEmployee(raw)
+		if err != nil {
+			return nil, err
+		}
+		employees = append(employees, employee)
+	}
+	return employees, nil
+}
@@ -4,12 +4,13 @@ package erpnext
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"time"
+	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -20,6 +21,38 @@ type Client struct {
 	APIKey     string
 	APISecret  string
 	HTTPClient *http.Client
+
+	// ReadPolicy and WritePolicy are the timeout and retry behavior for reads and for
+	// creates/updates respectively
+	ReadPolicy  RequestPolicy
+	WritePolicy RequestPolicy
+
+	// ChatIDField is the Employee field storing the Mattermost user ID, DefaultChatIDField when empty
+	ChatIDField string
+
+	// UseCSRF attaches the X-Frappe-CSRF-Token header to write requests, for sites that
+	// enforce CSRF protection even on token-authenticated requests
+	UseCSRF bool
+	csrf    *csrfCache
+
+	// StatusField is an optional Employee field, e.g. a "chat onboarded" check, that is read
+	// into and written from Employee.StatusFieldValue
+	StatusField string
+
+	// RateLimitPacing slows paged employee reads down when ERPNext's rate limit headers say
+	// the limit is nearly reached
+	RateLimitPacing RateLimitPacing
+
+	// ResponseShape is how single-document responses are wrapped, {"data": ...} or
+	// {"message": ...} depending on the Frappe version. Empty or ResponseShapeAuto accepts both.
+	ResponseShape string
+
+	// RequestLimiter, when set, caps the requests this client has in flight at once. Every
+	// attempt holds a slot until its response body is closed.
+	RequestLimiter *RequestLimiter
+
+	// ctx is the context every request of the client is made with, set by WithContext
+	ctx context.Context
 }
 
 type CustomFieldResponse struct {
@@ -45,15 +78,43 @@ type CustomField struct {
 
 // Employee represents an employee in ERPNext
 type Employee struct {
-	Name          string `json:"name,omitempty"` // This is the employee ID
-	CompanyEmail  string `json:"company_email,omitempty"`
-	FirstName     string `json:"first_name,omitempty"`
-	LastName      string `json:"last_name,omitempty"`
-	Gender        string `json:"gender,omitempty"`
-	DateOfBirth   string `json:"date_of_birth,omitempty"`
-	DateOfJoining string `json:"date_of_joining,omitempty"`
-	Status        string `json:"status,omitempty"`
-	CustomChatID  string `json:"custom_chat_id,omitempty"` // New field for Mattermost ID
+	Name           string `json:"name,omitempty"` // This is the employee ID
+	CompanyEmail   string `json:"company_email,omitempty"`
+	PersonalEmail  string `json:"personal_email,omitempty"`
+	FirstName      string `json:"first_name,omitempty"`
+	LastName       string `json:"last_name,omitempty"`
+	Gender         string `json:"gender,omitempty"`
+	DateOfBirth    string `json:"date_of_birth,omitempty"`
+	DateOfJoining  string `json:"date_of_joining,omitempty"`
+	Status         string `json:"status,omitempty"`
+	CustomChatID   string `json:"custom_chat_id,omitempty"` // New field for Mattermost ID
+	Image          string `json:"image,omitempty"`          // File URL of the employee photo
+	EmployeeNumber string `json:"employee_number,omitempty"`
+	UserID         string `json:"user_id,omitempty"` // ERPNext User linked to the employee
+	CellNumber     string `json:"cell_number,omitempty"`
+	Branch         string `json:"branch,omitempty"`     // Office location in the org structure
+	ReportsTo      string `json:"reports_to,omitempty"` // Employee ID of the manager
+
+	// Modified is the document's last modified timestamp. When set, an update sends it and
+	// ERPNext rejects the update if the document changed since.
+	Modified string `json:"modified,omitempty"`
+
+	// EmployeeName is the full name ERPNext composes from the name parts. It is read only and
+	// may differ from a plain first + last concatenation, e.g. with a middle name or a naming
+	// customization.
+	EmployeeName string `json:"employee_name,omitempty"`
+
+	// StatusFieldValue is the value of the client's StatusField, read as a string and written
+	// when set
+	StatusFieldValue string `json:"-"`
+
+	// ExtraFields are additional values sent when creating the employee, e.g. instance-specific
+	// mandatory fields. They never override the fields above.
+	ExtraFields map[string]interface{} `json:"-"`
+
+	// ChildTables are child table rows sent when creating the employee, keyed by the table
+	// field, e.g. company assignments on multi-company instances
+	ChildTables map[string][]map[string]interface{} `json:"-"`
 }
 
 // EmployeeResponse represents the response from ERPNext API when fetching employees
@@ -81,7 +142,32 @@ type User struct {
 	Username         string `json:"username,omitempty"`
 	Enabled          int    `json:"enabled,omitempty"` // 1 for enabled, 0 for disabled
 	RoleProfileName  string `json:"role_profile_name,omitempty"`
-	SendWelcomeEmail int    `json:"send_welcome_email,omitempty"`
+	SendWelcomeEmail int    `json:"send_welcome_email"` // Always sent: ERPNext defaults a missing value to sending the email
+
+	// NamingSeries is sent as naming_series on creation when set, for instances that name users
+	// by series rather than by email
+	NamingSeries string `json:"naming_series,omitempty"`
+
+	// ExtraFields are additional values sent when creating the user, e.g. fields an instance's
+	// naming series requires. Reserved fields are never overridden.
+	ExtraFields map[string]interface{} `json:"-"`
+
+	// UpdateEnabled makes UpdateUser write Enabled, so that 0 can disable the user rather than
+	// meaning "leave unchanged"
+	UpdateEnabled bool `json:"-"`
+}
+
+// ReservedUserFields are the User fields CreateUser always sets itself
+var ReservedUserFields = []string{
+	"doctype",
+	"name",
+	"email",
+	"first_name",
+	"last_name",
+	"username",
+	"enabled",
+	"role_profile_name",
+	"send_welcome_email",
 }
 
 // UserResponse represents the response from ERPNext API when fetching users
@@ -89,20 +175,123 @@ type UserResponse struct {
 	Data []User `json:"data"`
 }
 
-// NewClient creates a new ERPNext client
+// NewClient creates a new ERPNext client with the default connection pooling
 func NewClient(url, apiKey, apiSecret string) *Client {
+	return NewClientWithTransport(url, apiKey, apiSecret, DefaultTransportSettings)
+}
+
+// NewClientWithTransport creates a new ERPNext client whose connection pool is tuned by settings
+func NewClientWithTransport(url, apiKey, apiSecret string, settings TransportSettings) *Client {
 	return &Client{
 		URL:       url,
 		APIKey:    apiKey,
 		APISecret: apiSecret,
+		// Timeouts are applied per attempt by ReadPolicy and WritePolicy
 		HTTPClient: &http.Client{
-			Timeout: 30 * time.Second, // Increased timeout for large operations
+			Transport: NewTransport(settings),
 		},
+		ReadPolicy:  DefaultReadPolicy,
+		WritePolicy: DefaultWritePolicy,
+		csrf:        &csrfCache{},
 	}
 }
 
-// GetEmployees fetches all employees from ERPNext with enhanced pagination
+// WithContext returns a copy of the client whose requests, and the waits between them, stop
+// once ctx is done, drawing their retries from the RetryBudget ctx carries. The copy shares the
+// connection pool, the request limiter and the CSRF token. A nil client stays nil.
+func (c *Client) WithContext(ctx context.Context) *Client {
+	if c == nil {
+		return nil
+	}
+
+	scoped := *c
+	scoped.ctx = ctx
+	return &scoped
+}
+
+// context returns the context the client's requests are made with
+func (c *Client) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// DefaultEmployeeFields is the set of Employee fields fetched when no explicit field list is given
+var DefaultEmployeeFields = []string{
+	"name",
+	"company_email",
+	"first_name",
+	"last_name",
+	"gender",
+	"date_of_birth",
+	"date_of_joining",
+	"status",
+	"custom_chat_id",
+	"image",
+	"employee_number",
+	"user_id",
+	"cell_number",
+	"employee_name",
+	"branch",
+	"reports_to",
+}
+
+// newRequest builds an HTTP request against the ERPNext API with the token authorization
+// and JSON headers every call needs.
+func (c *Client) newRequest(method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(c.context(), method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set authorization header with token format: "token api_key:api_secret"
+	authToken := fmt.Sprintf("token %s:%s", c.APIKey, c.APISecret)
+	req.Header.Set("Authorization", authToken)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	return req, nil
+}
+
+// GetEmployees fetches all employees from ERPNext using the default field set
 func (c *Client) GetEmployees() ([]Employee, error) {
+	return c.GetEmployeesWithFields(DefaultEmployeeFields)
+}
+
+// GetEmployeesWithFields fetches all employees from ERPNext with enhanced pagination,
+// requesting only the given fields. An empty field list falls back to DefaultEmployeeFields.
+func (c *Client) GetEmployeesWithFields(fields []string) ([]Employee, error) {
+	// Only active employees, to improve performance
+	return c.listEmployees(fields, []interface{}{[]string{"status", "=", "Active"}})
+}
+
+// GetInactiveMappedEmployees fetches the employees that aren't Active but still have a chat ID,
+// i.e. whose Mattermost accounts may have to be deactivated, with the default field set
+func (c *Client) GetInactiveMappedEmployees() ([]Employee, error) {
+	return c.listEmployees(DefaultEmployeeFields, []interface{}{
+		[]string{"status", "!=", "Active"},
+		[]string{c.ChatIDFieldName(), "is", "set"},
+	})
+}
+
+// listEmployees pages through the employees matching the given Frappe filters, requesting only
+// the given fields. An empty field list falls back to DefaultEmployeeFields.
+func (c *Client) listEmployees(fields []string, filters []interface{}) ([]Employee, error) {
+	if len(fields) == 0 {
+		fields = DefaultEmployeeFields
+	}
+
+	filtersParam, err := json.Marshal(filters)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal employee filters")
+	}
+
+	fieldsParam, err := json.Marshal(c.employeeFields(fields))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal field list")
+	}
+
 	allEmployees := []Employee{}
 	pageSize := 200 // Increased page size for better performance
 	startIdx := 0
@@ -122,68 +311,193 @@ func (c *Client) GetEmployees() ([]Employee, error) {
 		query := reqURL.Query()
 		query.Add("limit_start", fmt.Sprintf("%d", startIdx))
 		query.Add("limit_page_length", fmt.Sprintf("%d", pageSize))
-		query.Add("fields", `["name", "company_email", "first_name", "last_name", "gender", "date_of_birth", "date_of_joining", "status", "custom_chat_id"]`)
-
-		// Add filter to get only active employees to improve performance
-		query.Add("filters", `[["status", "=", "Active"]]`)
+		query.Add("fields", string(fieldsParam))
+		query.Add("filters", string(filtersParam))
 
 		reqURL.RawQuery = query.Encode()
 
 		fmt.Printf("Fetching page %d (start: %d, limit: %d)...\n", page+1, startIdx, pageSize)
 
 		// Create the request
-		req, err := http.NewRequest(http.MethodGet, reqURL.String(), nil)
+		req, err := c.newRequest(http.MethodGet, reqURL.String(), nil)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to create request")
 		}
 
-		// Set authorization header with token format: "token api_key:api_secret"
-		authToken := fmt.Sprintf("token %s:%s", c.APIKey, c.APISecret)
-		req.Header.Set("Authorization", authToken)
-		req.Header.Set("Content-Type", "application/json")
-
 		// Execute the request
-		resp, err := c.HTTPClient.Do(req)
+		resp, err := c.doRead(req)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to execute request")
 		}
-		defer resp.Body.Close()
+
+		// Close every page right away, the request limiter slot is held until the body is closed
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read response")
+		}
 
 		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
 			return nil, fmt.Errorf("ERPNext API returned non-OK status code %d: %s", resp.StatusCode, string(body))
 		}
 
 		// Parse the response
-		var employeeResp EmployeeResponse
-		if err := json.NewDecoder(resp.Body).Decode(&employeeResp); err != nil {
+		pageEmployees, err := c.decodeEmployeeList(body)
+		if err != nil {
 			return nil, errors.Wrap(err, "failed to decode response")
 		}
 
 		// Add the fetched employees to our result array
-		allEmployees = append(allEmployees, employeeResp.Data...)
+		allEmployees = append(allEmployees, pageEmployees...)
 
 		fmt.Printf("Page %d: fetched %d employees (total so far: %d)\n",
-			page+1, len(employeeResp.Data), len(allEmployees))
+			page+1, len(pageEmployees), len(allEmployees))
 
 		// If we got fewer records than the page size, we've reached the end
-		if len(employeeResp.Data) < pageSize {
+		if len(pageEmployees) < pageSize {
 			fmt.Printf("Reached end of data at page %d\n", page+1)
 			break
 		}
 
 		// Update start index for the next page
 		startIdx += pageSize
+
+		// Wait for the rate limit window to reset rather than fail the next page with a 429
+		if err := c.paceAfter(resp.Header); err != nil {
+			return nil, errors.Wrap(err, "stopped waiting for the rate limit to reset")
+		}
 	}
 
 	fmt.Printf("Completed fetching employees: %d total employees found\n", len(allEmployees))
 	return allEmployees, nil
 }
 
+// GetEmployee fetches a single employee by its ERPNext ID (name).
+// Returns nil, nil if no employee with that name exists.
+func (c *Client) GetEmployee(name string) (*Employee, error) {
+	reqURL := fmt.Sprintf("%s/api/resource/Employee/%s", c.URL, url.PathEscape(name))
+
+	req, err := c.newRequest(http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create request")
+	}
+
+	resp, err := c.doRead(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to execute request")
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ERPNext API returned non-OK status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	// Single documents are wrapped as {"data": {...}} rather than the list shape {"data": [...]}
+	raw, err := c.singleDocument(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode response: "+string(body))
+	}
+
+	employee, err := c.decodeEmployee(raw)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode employee: "+string(body))
+	}
+
+	return &employee, nil
+}
+
 // GetEmployeeByEmail finds an employee by company email
 func (c *Client) GetEmployeeByEmail(email string) (*Employee, error) {
-	// Create the filter parameter - try a more flexible search
-	filterParam := fmt.Sprintf(`[["company_email","=","%s"]]`, email)
+	return c.getEmployeeByField("company_email", email)
+}
+
+// GetEmployeeByNumber finds an employee by its employee_number
+func (c *Client) GetEmployeeByNumber(employeeNumber string) (*Employee, error) {
+	return c.getEmployeeByField("employee_number", employeeNumber)
+}
+
+// GetEmployeeByChatID finds the employee mapped to a Mattermost user through the chat ID field
+func (c *Client) GetEmployeeByChatID(chatID string) (*Employee, error) {
+	return c.getEmployeeByField(c.ChatIDFieldName(), chatID)
+}
+
+// EmployeeEmailFields are the Employee fields that can hold a person's email address
+var EmployeeEmailFields = []string{"company_email", "personal_email", "user_id"}
+
+// NormalizeEmail trims surrounding whitespace and lowercases email, the form both sides are
+// compared in
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// GetEmployeeByAnyEmail finds an employee whose company email, personal email or linked user
+// matches email, in a single request using or_filters. When nothing matches exactly, stored
+// emails with stray whitespace or different case are looked for with a "like" search.
+func (c *Client) GetEmployeeByAnyEmail(email string) (*Employee, error) {
+	email = NormalizeEmail(email)
+
+	orFilters := make([][]string, 0, len(EmployeeEmailFields))
+	for _, field := range EmployeeEmailFields {
+		orFilters = append(orFilters, []string{field, "=", email})
+	}
+	employee, err := c.findEmployee("or_filters", orFilters, fmt.Sprintf("any email field %s", email))
+	if err != nil || employee != nil {
+		return employee, err
+	}
+
+	likeFilters := make([][]string, 0, len(EmployeeEmailFields))
+	for _, field := range EmployeeEmailFields {
+		likeFilters = append(likeFilters, []string{field, "like", "%" + escapeLikePattern(email) + "%"})
+	}
+	candidates, err := c.findEmployees("or_filters", likeFilters, fmt.Sprintf("any email field like %s", email))
+	if err != nil {
+		return nil, err
+	}
+	for i, candidate := range candidates {
+		for _, candidateEmail := range []string{candidate.CompanyEmail, candidate.PersonalEmail, candidate.UserID} {
+			if candidateEmail != "" && NormalizeEmail(candidateEmail) == email {
+				return &candidates[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// getEmployeeByField finds the first employee whose field equals value
+func (c *Client) getEmployeeByField(field, value string) (*Employee, error) {
+	return c.findEmployee("filters", [][]string{{field, "=", value}}, fmt.Sprintf("%s %s", field, value))
+}
+
+// findEmployee returns the first employee matching filters, passed as the given query parameter
+// ("filters" for AND, "or_filters" for OR). description is only used for debug output.
+func (c *Client) findEmployee(filterParamName string, filters [][]string, description string) (*Employee, error) {
+	employees, err := c.findEmployees(filterParamName, filters, description)
+	if err != nil {
+		return nil, err
+	}
+
+	// If no employee found with that value
+	if len(employees) == 0 {
+		return nil, nil
+	}
+
+	// Return the first matching employee
+	return &employees[0], nil
+}
+
+// findEmployees returns every employee matching filters, see findEmployee
+func (c *Client) findEmployees(filterParamName string, filters [][]string, description string) ([]Employee, error) {
+	// Create the filter parameter
+	filterParam, err := json.Marshal(filters)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal filter")
+	}
 
 	// Build the URL with properly encoded query parameters
 	baseURL := fmt.Sprintf("%s/api/resource/Employee", c.URL)
@@ -194,25 +508,24 @@ func (c *Client) GetEmployeeByEmail(email string) (*Employee, error) {
 
 	// Add query parameters
 	query := reqURL.Query()
-	query.Add("filters", filterParam)
-	query.Add("fields", `["name", "company_email", "first_name", "last_name", "gender", "date_of_birth", "date_of_joining", "status", "custom_chat_id"]`)
+	query.Add(filterParamName, string(filterParam))
+	fieldsParam, err := json.Marshal(c.employeeFields(DefaultEmployeeFields))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal field list")
+	}
+	query.Add("fields", string(fieldsParam))
 	reqURL.RawQuery = query.Encode()
 
 	// Print the request URL for debugging (this would normally go to logs)
 	fmt.Printf("Making employee search request to: %s\n", reqURL.String())
 
 	// Now create the request with the properly encoded URL
-	req, err := http.NewRequest(http.MethodGet, reqURL.String(), nil)
+	req, err := c.newRequest(http.MethodGet, reqURL.String(), nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create request")
 	}
 
-	// Set authorization header
-	authToken := fmt.Sprintf("token %s:%s", c.APIKey, c.APISecret)
-	req.Header.Set("Authorization", authToken)
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := c.HTTPClient.Do(req)
+	resp, err := c.doRead(req)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to execute request")
 	}
@@ -230,21 +543,15 @@ func (c *Client) GetEmployeeByEmail(email string) (*Employee, error) {
 	}
 
 	// Parse the response
-	var employeeResp EmployeeResponse
-	if err := json.Unmarshal(body, &employeeResp); err != nil {
+	employees, err := c.decodeEmployeeList(body)
+	if err != nil {
 		return nil, errors.Wrap(err, "failed to decode response: "+string(body))
 	}
 
 	// Print found employees for debugging
-	fmt.Printf("Found %d employees with email similar to %s\n", len(employeeResp.Data), email)
-
-	// If no employee found with that email
-	if len(employeeResp.Data) == 0 {
-		return nil, nil
-	}
+	fmt.Printf("Found %d employees with %s\n", len(employees), description)
 
-	// Return the first matching employee
-	return &employeeResp.Data[0], nil
+	return employees, nil
 }
 
 // CreateEmployee creates a new employee in ERPNext
@@ -261,7 +568,33 @@ func (c *Client) CreateEmployee(employee *Employee) (*Employee, error) {
 		"date_of_birth":   employee.DateOfBirth,
 		"date_of_joining": employee.DateOfJoining,
 		"status":          employee.Status,
-		"custom_chat_id":  employee.CustomChatID,
+	}
+	requestBody[c.ChatIDFieldName()] = employee.CustomChatID
+	if employee.EmployeeNumber != "" {
+		requestBody["employee_number"] = employee.EmployeeNumber
+	}
+	if employee.PersonalEmail != "" {
+		requestBody["personal_email"] = employee.PersonalEmail
+	}
+	if employee.CellNumber != "" {
+		requestBody["cell_number"] = employee.CellNumber
+	}
+	if c.StatusField != "" && employee.StatusFieldValue != "" {
+		requestBody[c.StatusField] = employee.StatusFieldValue
+	}
+
+	// Add any extra fields without overriding the standard ones
+	for field, value := range employee.ExtraFields {
+		if _, exists := requestBody[field]; !exists {
+			requestBody[field] = value
+		}
+	}
+
+	// Child tables are sent as lists of row objects under their table field
+	for field, rows := range employee.ChildTables {
+		if _, exists := requestBody[field]; !exists && len(rows) > 0 {
+			requestBody[field] = rows
+		}
 	}
 
 	// Convert to JSON
@@ -274,19 +607,13 @@ func (c *Client) CreateEmployee(employee *Employee) (*Employee, error) {
 	fmt.Printf("Create employee request body: %s\n", string(bodyData))
 
 	// Create request
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(bodyData))
+	req, err := c.newRequest(http.MethodPost, url, bytes.NewBuffer(bodyData))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create request")
 	}
 
-	// Set headers
-	authToken := fmt.Sprintf("token %s:%s", c.APIKey, c.APISecret)
-	req.Header.Set("Authorization", authToken)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
-
 	// Execute request
-	resp, err := c.HTTPClient.Do(req)
+	resp, err := c.doWrite(req)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to execute request")
 	}
@@ -301,38 +628,109 @@ func (c *Client) CreateEmployee(employee *Employee) (*Employee, error) {
 
 	// Handle response
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		if mandatoryErr := parseMandatoryFieldError(resp.StatusCode, string(body)); mandatoryErr != nil {
+			return nil, mandatoryErr
+		}
 		return nil, fmt.Errorf("ERPNext API returned status code %d: %s", resp.StatusCode, string(body))
 	}
 
 	// Parse the response to get the created employee
-	var respData struct {
-		Data struct {
-			Name string `json:"name"`
-		} `json:"data"`
-	}
-
-	if err := json.Unmarshal(body, &respData); err != nil {
+	name, err := c.createdDocumentName(body)
+	if err != nil {
 		return nil, errors.Wrap(err, "failed to decode response: "+string(body))
 	}
 
-	// Return a new Employee with just the ID since that's what we need
-	return &Employee{
-		Name: respData.Data.Name,
-	}, nil
+	// Return a new Employee with the ID and the employee_name ERPNext composed on saving, which
+	// the response carries, so callers needn't read the employee back
+	created := &Employee{Name: name}
+	if raw, err := c.singleDocument(body); err == nil {
+		var document struct {
+			EmployeeName string `json:"employee_name"`
+		}
+		if json.Unmarshal(raw, &document) == nil {
+			created.EmployeeName = strings.TrimSpace(document.EmployeeName)
+		}
+	}
+	return created, nil
 }
 
-// UpdateEmployee updates an existing employee in ERPNext
+// UpdateEmployee updates an existing employee in ERPNext. When the document was modified in
+// ERPNext between our read and our write, the employee is re-fetched and the update retried once.
 func (c *Client) UpdateEmployee(employee *Employee) (*Employee, error) {
-	// Create URL for updating specific employee by name (ID)
-	url := fmt.Sprintf("%s/api/resource/Employee/%s", c.URL, employee.Name)
+	updated, err := c.updateEmployee(employee)
+
+	var modifiedErr *DocumentModifiedError
+	if !errors.As(err, &modifiedErr) {
+		return updated, err
+	}
 
+	fmt.Printf("Employee %s was modified concurrently, re-fetching and retrying update\n", employee.Name)
+
+	current, fetchErr := c.GetEmployee(employee.Name)
+	if fetchErr != nil {
+		return nil, errors.Wrap(fetchErr, "failed to re-fetch employee after concurrent modification")
+	}
+	if current == nil {
+		return nil, fmt.Errorf("employee %s no longer exists in ERPNext", employee.Name)
+	}
+
+	// The concurrent change may already have written our values
+	if current.CustomChatID == employee.CustomChatID &&
+		(employee.CellNumber == "" || current.CellNumber == employee.CellNumber) &&
+		(employee.StatusFieldValue == "" || c.StatusField == "" || current.StatusFieldValue == employee.StatusFieldValue) {
+		return current, nil
+	}
+
+	// Rebuild the update on the latest version of the document. Its modified timestamp goes
+	// along, so a further change in between is rejected rather than overwritten.
+	return c.updateEmployee(&Employee{
+		Name:             current.Name,
+		CustomChatID:     employee.CustomChatID,
+		CellNumber:       employee.CellNumber,
+		StatusFieldValue: employee.StatusFieldValue,
+		Modified:         current.Modified,
+	})
+}
+
+// ProtectedEmployeeDateFields are Employee dates HR owns. They are only sent when an employee is
+// created and never in an update, so a sync can't clobber e.g. a corrected date of joining.
+var ProtectedEmployeeDateFields = []string{"date_of_birth", "date_of_joining", "relieving_date"}
+
+// employeeUpdateBody builds the body of an employee update: the chat ID field, and the cell
+// number, status field and modified timestamp when set. Protected date fields are dropped even when the chat ID or
+// status field is configured as one of them.
+func (c *Client) employeeUpdateBody(employee *Employee) map[string]interface{} {
 	// In ERPNext, when updating we only need to include the fields we want to change
 	requestBody := map[string]interface{}{
-		"custom_chat_id": employee.CustomChatID,
+		c.ChatIDFieldName(): employee.CustomChatID,
+	}
+	if employee.CellNumber != "" {
+		requestBody["cell_number"] = employee.CellNumber
+	}
+	if c.StatusField != "" && employee.StatusFieldValue != "" {
+		requestBody[c.StatusField] = employee.StatusFieldValue
+	}
+	if employee.Modified != "" {
+		requestBody["modified"] = employee.Modified
+	}
+
+	for _, field := range ProtectedEmployeeDateFields {
+		if _, exists := requestBody[field]; exists {
+			fmt.Printf("Refusing to update protected date field %s of employee %s\n", field, employee.Name)
+			delete(requestBody, field)
+		}
 	}
+	return requestBody
+}
+
+// updateEmployee sends a single update of the employee's chat ID field, and its cell number and
+// status field when set
+func (c *Client) updateEmployee(employee *Employee) (*Employee, error) {
+	// Create URL for updating specific employee by name (ID)
+	url := fmt.Sprintf("%s/api/resource/Employee/%s", c.URL, employee.Name)
 
 	// Convert to JSON
-	bodyData, err := json.Marshal(requestBody)
+	bodyData, err := json.Marshal(c.employeeUpdateBody(employee))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to marshal employee update data")
 	}
@@ -342,19 +740,13 @@ func (c *Client) UpdateEmployee(employee *Employee) (*Employee, error) {
 	fmt.Printf("Update employee request body: %s\n", string(bodyData))
 
 	// Create PUT request for updating
-	req, err := http.NewRequest(http.MethodPut, url, bytes.NewBuffer(bodyData))
+	req, err := c.newRequest(http.MethodPut, url, bytes.NewBuffer(bodyData))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create update request")
 	}
 
-	// Set headers
-	authToken := fmt.Sprintf("token %s:%s", c.APIKey, c.APISecret)
-	req.Header.Set("Authorization", authToken)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
-
 	// Execute request
-	resp, err := c.HTTPClient.Do(req)
+	resp, err := c.doWrite(req)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to execute update request")
 	}
@@ -369,6 +761,9 @@ func (c *Client) UpdateEmployee(employee *Employee) (*Employee, error) {
 
 	// Handle response
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		if modifiedErr := parseDocumentModifiedError(resp.StatusCode, string(body)); modifiedErr != nil {
+			return nil, modifiedErr
+		}
 		return nil, fmt.Errorf("ERPNext API returned status code %d when updating employee: %s",
 			resp.StatusCode, string(body))
 	}
@@ -379,6 +774,41 @@ func (c *Client) UpdateEmployee(employee *Employee) (*Employee, error) {
 	return employee, nil
 }
 
+// LinkEmployeeToUser sets the employee's user_id so ERPNext associates the employee with the
+// ERPNext user (login) named userName
+func (c *Client) LinkEmployeeToUser(employeeName, userName string) error {
+	reqURL := fmt.Sprintf("%s/api/resource/Employee/%s", c.URL, url.PathEscape(employeeName))
+
+	bodyData, err := json.Marshal(map[string]interface{}{
+		"user_id": userName,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal employee link data")
+	}
+
+	fmt.Printf("Link employee request to: %s\n", reqURL)
+
+	req, err := c.newRequest(http.MethodPut, reqURL, bytes.NewBuffer(bodyData))
+	if err != nil {
+		return errors.Wrap(err, "failed to create link request")
+	}
+
+	resp, err := c.doWrite(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to execute link request")
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	fmt.Printf("Link employee response status: %d\n", resp.StatusCode)
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("ERPNext API returned status code %d when linking employee to user: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
+
 // CheckCustomFieldExists checks if a custom field exists for a specific DocType
 func (c *Client) CheckCustomFieldExists(fieldName, docType string) (bool, error) {
 	// Build URL with filters for the custom field
@@ -397,18 +827,13 @@ func (c *Client) CheckCustomFieldExists(fieldName, docType string) (bool, error)
 	reqURL.RawQuery = query.Encode()
 
 	// Create the request
-	req, err := http.NewRequest(http.MethodGet, reqURL.String(), nil)
+	req, err := c.newRequest(http.MethodGet, reqURL.String(), nil)
 	if err != nil {
 		return false, errors.Wrap(err, "failed to create request")
 	}
 
-	// Set authorization header
-	authToken := fmt.Sprintf("token %s:%s", c.APIKey, c.APISecret)
-	req.Header.Set("Authorization", authToken)
-	req.Header.Set("Content-Type", "application/json")
-
 	// Execute the request
-	resp, err := c.HTTPClient.Do(req)
+	resp, err := c.doRead(req)
 	if err != nil {
 		return false, errors.Wrap(err, "failed to execute request")
 	}
@@ -435,8 +860,30 @@ func (c *Client) CheckCustomFieldExists(fieldName, docType string) (bool, error)
 	return len(customFieldResp.Data) > 0, nil
 }
 
+// CustomFieldFlags control where a custom field shows up in ERPNext
+type CustomFieldFlags struct {
+	InListView       bool
+	InStandardFilter bool
+	InGlobalSearch   bool
+}
+
+// DefaultCustomFieldFlags keeps the field out of the list view but filterable and searchable
+var DefaultCustomFieldFlags = CustomFieldFlags{
+	InListView:       false,
+	InStandardFilter: true,
+	InGlobalSearch:   true,
+}
+
+// boolToInt converts a flag to the 0/1 ERPNext expects
+func boolToInt(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
+
 // CreateCustomField creates a new custom field in ERPNext
-func (c *Client) CreateCustomField(fieldName, label, docType, fieldType string, required bool) error {
+func (c *Client) CreateCustomField(fieldName, label, docType, fieldType string, required bool, flags CustomFieldFlags) error {
 	url := fmt.Sprintf("%s/api/resource/Custom Field", c.URL)
 
 	// Convert boolean to integer (0 or 1)
@@ -448,21 +895,21 @@ func (c *Client) CreateCustomField(fieldName, label, docType, fieldType string,
 	// The ERPNext API expects data in a specific format
 	requestBody := map[string]interface{}{
 		"doctype":              "Custom Field",
-		"dt":                   docType,         // Document Type (e.g., "Employee")
-		"fieldname":            fieldName,       // Field name (e.g., "custom_chat_id")
-		"label":                label,           // Label (e.g., "Workdone User ID")
-		"fieldtype":            fieldType,       // Field type (e.g., "Data")
-		"insert_after":         "employee_name", // Insert after employee name for visibility
-		"reqd":                 reqd,            // Is it required? (0 for not mandatory)
-		"in_list_view":         0,               // Show in list view (1 for yes) - THIS IS THE KEY SETTING
-		"in_standard_filter":   1,               // Include in standard filters
-		"in_global_search":     1,               // Include in global search
-		"allow_in_quick_entry": 1,               // Allow in quick entry
-		"translatable":         0,               // Is it translatable? (0 or 1)
-		"unique":               0,               // Is it unique? (0 or 1)
-		"no_copy":              0,               // Exclude from copying? (0 or 1)
-		"read_only":            0,               // Is it read-only? (0 or 1)
-		"hide_display":         0,               // Hide in grid view? (0 or 1)
+		"dt":                   docType,                           // Document Type (e.g., "Employee")
+		"fieldname":            fieldName,                         // Field name (e.g., "custom_chat_id")
+		"label":                label,                             // Label (e.g., "Workdone User ID")
+		"fieldtype":            fieldType,                         // Field type (e.g., "Data")
+		"insert_after":         "employee_name",                   // Insert after employee name for visibility
+		"reqd":                 reqd,                              // Is it required? (0 for not mandatory)
+		"in_list_view":         boolToInt(flags.InListView),       // Show in list view (1 for yes)
+		"in_standard_filter":   boolToInt(flags.InStandardFilter), // Include in standard filters
+		"in_global_search":     boolToInt(flags.InGlobalSearch),   // Include in global search, adds to the search index
+		"allow_in_quick_entry": 1,                                 // Allow in quick entry
+		"translatable":         0,                                 // Is it translatable? (0 or 1)
+		"unique":               0,                                 // Is it unique? (0 or 1)
+		"no_copy":              0,                                 // Exclude from copying? (0 or 1)
+		"read_only":            0,                                 // Is it read-only? (0 or 1)
+		"hide_display":         0,                                 // Hide in grid view? (0 or 1)
 	}
 
 	// Convert to JSON
@@ -475,19 +922,13 @@ func (c *Client) CreateCustomField(fieldName, label, docType, fieldType string,
 	fmt.Printf("Create custom field request body: %s\n", string(bodyData))
 
 	// Create request
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(bodyData))
+	req, err := c.newRequest(http.MethodPost, url, bytes.NewBuffer(bodyData))
 	if err != nil {
 		return errors.Wrap(err, "failed to create request")
 	}
 
-	// Set headers
-	authToken := fmt.Sprintf("token %s:%s", c.APIKey, c.APISecret)
-	req.Header.Set("Authorization", authToken)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
-
 	// Execute request
-	resp, err := c.HTTPClient.Do(req)
+	resp, err := c.doWrite(req)
 	if err != nil {
 		return errors.Wrap(err, "failed to execute request")
 	}
@@ -524,16 +965,12 @@ func (c *Client) CheckRoleProfileExists(roleProfileName string) (bool, error) {
 	query.Add("filters", filterParam)
 	reqURL.RawQuery = query.Encode()
 
-	req, err := http.NewRequest(http.MethodGet, reqURL.String(), nil)
+	req, err := c.newRequest(http.MethodGet, reqURL.String(), nil)
 	if err != nil {
 		return false, errors.Wrap(err, "failed to create request")
 	}
 
-	authToken := fmt.Sprintf("token %s:%s", c.APIKey, c.APISecret)
-	req.Header.Set("Authorization", authToken)
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := c.HTTPClient.Do(req)
+	resp, err := c.doRead(req)
 	if err != nil {
 		return false, errors.Wrap(err, "failed to execute request")
 	}
@@ -595,17 +1032,12 @@ func (c *Client) CreateRoleProfile(roleProfileName string) error {
 
 	fmt.Printf("Create role profile request body: %s\n", string(bodyData))
 
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(bodyData))
+	req, err := c.newRequest(http.MethodPost, url, bytes.NewBuffer(bodyData))
 	if err != nil {
 		return errors.Wrap(err, "failed to create request")
 	}
 
-	authToken := fmt.Sprintf("token %s:%s", c.APIKey, c.APISecret)
-	req.Header.Set("Authorization", authToken)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
-
-	resp, err := c.HTTPClient.Do(req)
+	resp, err := c.doWrite(req)
 	if err != nil {
 		return errors.Wrap(err, "failed to execute request")
 	}
@@ -630,25 +1062,28 @@ func (c *Client) GetUserByEmail(email string) (*User, error) {
 		return nil, errors.Wrap(err, "failed to parse URL")
 	}
 
-	filterParam := fmt.Sprintf(`[["email","=","%s"]]`, email)
+	email = strings.TrimSpace(email)
+
+	// "=" can be case-sensitive depending on the database collation, so search with an escaped
+	// "like" and pick the exact match case-insensitively below
+	filterParam, err := json.Marshal([][]string{{"email", "like", escapeLikePattern(email)}})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal filters")
+	}
 
 	query := reqURL.Query()
-	query.Add("filters", filterParam)
+	query.Add("filters", string(filterParam))
 	query.Add("fields", `["name", "email", "first_name", "last_name", "username", "enabled", "role_profile_name"]`)
 	reqURL.RawQuery = query.Encode()
 
 	fmt.Printf("Making user search request to: %s\n", reqURL.String())
 
-	req, err := http.NewRequest(http.MethodGet, reqURL.String(), nil)
+	req, err := c.newRequest(http.MethodGet, reqURL.String(), nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create request")
 	}
 
-	authToken := fmt.Sprintf("token %s:%s", c.APIKey, c.APISecret)
-	req.Header.Set("Authorization", authToken)
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := c.HTTPClient.Do(req)
+	resp, err := c.doRead(req)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to execute request")
 	}
@@ -669,46 +1104,83 @@ func (c *Client) GetUserByEmail(email string) (*User, error) {
 
 	fmt.Printf("Found %d users with email %s\n", len(userResp.Data), email)
 
-	if len(userResp.Data) == 0 {
-		return nil, nil
+	for i := range userResp.Data {
+		if strings.EqualFold(strings.TrimSpace(userResp.Data[i].Email), strings.TrimSpace(email)) {
+			return &userResp.Data[i], nil
+		}
 	}
 
-	return &userResp.Data[0], nil
+	return nil, nil
+}
+
+// UsernameExists checks whether an ERPNext user already has the given username
+func (c *Client) UsernameExists(username string) (bool, error) {
+	reqURL, err := url.Parse(fmt.Sprintf("%s/api/resource/User", c.URL))
+	if err != nil {
+		return false, errors.Wrap(err, "failed to parse URL")
+	}
+
+	filterParam, err := json.Marshal([][]string{{"username", "=", username}})
+	if err != nil {
+		return false, errors.Wrap(err, "failed to marshal filters")
+	}
+
+	query := reqURL.Query()
+	query.Add("filters", string(filterParam))
+	query.Add("fields", `["name"]`)
+	reqURL.RawQuery = query.Encode()
+
+	req, err := c.newRequest(http.MethodGet, reqURL.String(), nil)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to create request")
+	}
+
+	resp, err := c.doRead(req)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to execute request")
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	fmt.Printf("Username check response status: %d\n", resp.StatusCode)
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("ERPNext API returned non-OK status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	var userResp UserResponse
+	if err := json.Unmarshal(body, &userResp); err != nil {
+		return false, errors.Wrap(err, "failed to decode response: "+string(body))
+	}
+
+	return len(userResp.Data) > 0, nil
+}
+
+// likePatternEscaper escapes the SQL LIKE wildcards so a value only matches literally
+var likePatternEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLikePattern escapes value for use as a literal "like" filter value
+func escapeLikePattern(value string) string {
+	return likePatternEscaper.Replace(value)
 }
 
 // CreateUser creates a new user in ERPNext
 func (c *Client) CreateUser(user *User) (*User, error) {
 	url := fmt.Sprintf("%s/api/resource/User", c.URL)
 
-	requestBody := map[string]interface{}{
-		"doctype":            "User",
-		"email":              user.Email,
-		"first_name":         user.FirstName,
-		"last_name":          user.LastName,
-		"username":           user.Username,
-		"enabled":            user.Enabled,
-		"role_profile_name":  user.RoleProfileName,
-		"send_welcome_email": user.SendWelcomeEmail,
-	}
-
-	bodyData, err := json.Marshal(requestBody)
+	bodyData, err := json.Marshal(userRequestBody(user))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to marshal user data")
 	}
 
 	fmt.Printf("Create user request body: %s\n", string(bodyData))
 
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(bodyData))
+	req, err := c.newRequest(http.MethodPost, url, bytes.NewBuffer(bodyData))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create request")
 	}
 
-	authToken := fmt.Sprintf("token %s:%s", c.APIKey, c.APISecret)
-	req.Header.Set("Authorization", authToken)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
-
-	resp, err := c.HTTPClient.Do(req)
+	resp, err := c.doWrite(req)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to execute request")
 	}
@@ -719,20 +1191,148 @@ func (c *Client) CreateUser(user *User) (*User, error) {
 	fmt.Printf("Create user response body: %s\n", string(body))
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		if seriesErr := parseNamingSeriesError(resp.StatusCode, string(body)); seriesErr != nil {
+			return nil, seriesErr
+		}
 		return nil, fmt.Errorf("ERPNext API returned status code %d when creating user: %s", resp.StatusCode, string(body))
 	}
 
-	var respData struct {
-		Data struct {
-			Name string `json:"name"`
-		} `json:"data"`
-	}
-
-	if err := json.Unmarshal(body, &respData); err != nil {
+	name, err := c.createdDocumentName(body)
+	if err != nil {
 		return nil, errors.Wrap(err, "failed to decode response: "+string(body))
 	}
 
 	return &User{
-		Name: respData.Data.Name,
+		Name: name,
 	}, nil
 }
+
+// UpdateUser writes the set fields of user (enabled when UpdateEnabled, role_profile_name,
+// first_name, last_name) to the existing ERPNext user named user.Name in a single request
+func (c *Client) UpdateUser(user *User) error {
+	if user.Name == "" {
+		return errors.New("user name is required to update an ERPNext user")
+	}
+
+	requestBody := userUpdateBody(user)
+	if len(requestBody) == 0 {
+		return nil
+	}
+
+	reqURL := fmt.Sprintf("%s/api/resource/User/%s", c.URL, url.PathEscape(user.Name))
+
+	bodyData, err := json.Marshal(requestBody)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal user update data")
+	}
+
+	fmt.Printf("Update user request to: %s\n", reqURL)
+	fmt.Printf("Update user request body: %s\n", string(bodyData))
+
+	req, err := c.newRequest(http.MethodPut, reqURL, bytes.NewBuffer(bodyData))
+	if err != nil {
+		return errors.Wrap(err, "failed to create update request")
+	}
+
+	resp, err := c.doWrite(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to execute update request")
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	fmt.Printf("Update user response status: %d\n", resp.StatusCode)
+	fmt.Printf("Update user response body: %s\n", string(body))
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		if modifiedErr := parseDocumentModifiedError(resp.StatusCode, string(body)); modifiedErr != nil {
+			return modifiedErr
+		}
+		return fmt.Errorf("ERPNext API returned status code %d when updating user: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
+
+// userUpdateBody builds the UpdateUser request body from the fields that are set. Enabled is
+// only included when UpdateEnabled is set, as its zero value is a meaningful change.
+func userUpdateBody(user *User) map[string]interface{} {
+	requestBody := map[string]interface{}{}
+	if user.UpdateEnabled {
+		requestBody["enabled"] = boolToInt(user.Enabled != 0)
+	}
+	if user.RoleProfileName != "" {
+		requestBody["role_profile_name"] = user.RoleProfileName
+	}
+	if user.FirstName != "" {
+		requestBody["first_name"] = user.FirstName
+	}
+	if user.LastName != "" {
+		requestBody["last_name"] = user.LastName
+	}
+	return requestBody
+}
+
+// IsReservedUserField reports whether field is one CreateUser always sets itself
+func IsReservedUserField(field string) bool {
+	for _, reserved := range ReservedUserFields {
+		if field == reserved {
+			return true
+		}
+	}
+	return false
+}
+
+// userRequestBody builds the CreateUser request body. send_welcome_email is always included as
+// an explicit 0/1, since ERPNext applies its own default (usually sending) when it is missing.
+func userRequestBody(user *User) map[string]interface{} {
+	sendWelcomeEmail := 0
+	if user.SendWelcomeEmail != 0 {
+		sendWelcomeEmail = 1
+	}
+
+	requestBody := map[string]interface{}{
+		"doctype":            "User",
+		"email":              user.Email,
+		"first_name":         user.FirstName,
+		"last_name":          user.LastName,
+		"username":           user.Username,
+		"enabled":            user.Enabled,
+		"role_profile_name":  user.RoleProfileName,
+		"send_welcome_email": sendWelcomeEmail,
+	}
+
+	// Add any extra fields without overriding the reserved ones
+	for field, value := range user.ExtraFields {
+		if !IsReservedUserField(field) {
+			requestBody[field] = value
+		}
+	}
+
+	// Omitted by default so instances that name users by email keep working
+	if user.NamingSeries != "" {
+		requestBody["naming_series"] = user.NamingSeries
+	}
+
+	return requestBody
+}
+
+// Ping checks that ERPNext is reachable and accepts the configured credentials
+func (c *Client) Ping() error {
+	req, err := c.newRequest(http.MethodGet, fmt.Sprintf("%s/api/method/frappe.auth.get_logged_user", c.URL), nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create request")
+	}
+
+	resp, err := c.doRead(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to reach ERPNext")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("ERPNext API returned status code %d when checking the connection: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
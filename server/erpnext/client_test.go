@@ -0,0 +1,246 @@
+package erpnext
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserRequestBodySendWelcomeEmail(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		value    int
+		expected float64
+	}{
+		{name: "disabled is sent as 0", value: 0, expected: 0},
+		{name: "enabled is sent as 1", value: 1, expected: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := json.Marshal(userRequestBody(&User{Email: "jane@example.com", SendWelcomeEmail: tc.value}))
+			require.NoError(t, err)
+
+			var body map[string]interface{}
+			require.NoError(t, json.Unmarshal(data, &body))
+
+			value, present := body["send_welcome_email"]
+			require.True(t, present, "send_welcome_email must always be in the body: %s", data)
+			assert.Equal(t, tc.expected, value)
+		})
+	}
+}
+
+func TestUserStructKeepsSendWelcomeEmail(t *testing.T) {
+	data, err := json.Marshal(User{Email: "jane@example.com"})
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"send_welcome_email":0`)
+}
+
+func TestUserRequestBodyKeepsReservedFields(t *testing.T) {
+	body := userRequestBody(&User{
+		Email: "jane@example.com",
+		ExtraFields: map[string]interface{}{
+			"email":              "other@example.com",
+			"send_welcome_email": 1,
+			"user_type":          "System User",
+		},
+	})
+
+	assert.Equal(t, "jane@example.com", body["email"])
+	assert.Equal(t, 0, body["send_welcome_email"])
+	assert.Equal(t, "System User", body["user_type"])
+}
+
+func TestUserUpdateBody(t *testing.T) {
+	assert.Empty(t, userUpdateBody(&User{Name: "jane@example.com"}))
+
+	assert.Equal(t, map[string]interface{}{"enabled": 0}, userUpdateBody(&User{UpdateEnabled: true}))
+
+	assert.Equal(t, map[string]interface{}{
+		"enabled":           1,
+		"role_profile_name": "Employee",
+		"first_name":        "Jane",
+	}, userUpdateBody(&User{Enabled: 1, UpdateEnabled: true, RoleProfileName: "Employee", FirstName: "Jane"}))
+}
+
+func TestGetUserByEmailIsCaseInsensitive(t *testing.T) {
+	var filters string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filters = r.URL.Query().Get("filters")
+		_, _ = w.Write([]byte(`{"data": [
+			{"name": "jane_doe@example.com", "email": "jane_doe@example.com"},
+			{"name": "Jane.Doe@Example.com", "email": "Jane.Doe@Example.com"}
+		]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "key", "secret")
+
+	user, err := client.GetUserByEmail("jane.doe@example.COM")
+	require.NoError(t, err)
+	require.NotNil(t, user)
+	assert.Equal(t, "Jane.Doe@Example.com", user.Name)
+	assert.Equal(t, `[["email","like","jane.doe@example.COM"]]`, filters)
+
+	user, err = client.GetUserByEmail("JANE_DOE@example.com")
+	require.NoError(t, err)
+	require.NotNil(t, user)
+	assert.Equal(t, "jane_doe@example.com", user.Name)
+	assert.Equal(t, `[["email","like","JANE\\_DOE@example.com"]]`, filters, "like wildcards must be escaped")
+
+	user, err = client.GetUserByEmail("someone@example.com")
+	require.NoError(t, err)
+	assert.Nil(t, user, "like results that aren't the same email must not match")
+}
+
+func TestGetEmployeeByAnyEmailMatchesPaddedEmails(t *testing.T) {
+	var orFilters []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filters := r.URL.Query().Get("or_filters")
+		orFilters = append(orFilters, filters)
+		if strings.Contains(filters, `"="`) {
+			_, _ = w.Write([]byte(`{"data": []}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data": [
+			{"name": "HR-EMP-1", "company_email": "jane.doe@example.com.vn"},
+			{"name": "HR-EMP-2", "company_email": " Jane.Doe@Example.com "}
+		]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "key", "secret")
+
+	employee, err := client.GetEmployeeByAnyEmail("  JANE.doe@example.com")
+	require.NoError(t, err)
+	require.NotNil(t, employee)
+	assert.Equal(t, "HR-EMP-2", employee.Name)
+
+	require.Len(t, orFilters, 2)
+	assert.Contains(t, orFilters[0], `["company_email","=","jane.doe@example.com"]`, "the exact lookup uses the normalized email")
+	assert.Contains(t, orFilters[1], `["company_email","like","%jane.doe@example.com%"]`)
+
+	orFilters = nil
+	employee, err = client.GetEmployeeByAnyEmail("doe@example.com")
+	require.NoError(t, err)
+	assert.Nil(t, employee, "like results that aren't the same email must not match")
+}
+
+func TestEmployeeUpdateBodyNeverWritesDates(t *testing.T) {
+	client := NewClient("http://erpnext.example.com", "key", "secret")
+	employee := &Employee{
+		Name:             "HR-EMP-1",
+		CustomChatID:     "user1",
+		CellNumber:       "+4915100000001",
+		DateOfJoining:    "2024-03-01",
+		DateOfBirth:      "1990-01-01",
+		StatusFieldValue: "1",
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"custom_chat_id": "user1",
+		"cell_number":    "+4915100000001",
+	}, client.employeeUpdateBody(employee))
+
+	// A status field pointed at a date by mistake must not overwrite it
+	client.StatusField = "date_of_joining"
+	body := client.employeeUpdateBody(employee)
+	assert.NotContains(t, body, "date_of_joining")
+	assert.NotContains(t, body, "date_of_birth")
+}
+
+func TestUserRequestBodyNamingSeries(t *testing.T) {
+	assert.NotContains(t, userRequestBody(&User{Email: "jane@example.com"}), "naming_series")
+
+	body := userRequestBody(&User{Email: "jane@example.com", NamingSeries: "USR-.#####"})
+	assert.Equal(t, "USR-.#####", body["naming_series"])
+}
+
+func TestParseNamingSeriesError(t *testing.T) {
+	assert.NotNil(t, parseNamingSeriesError(417, `{"exc_type": "ValidationError", "_server_messages": "[\"Naming Series mandatory\"]"}`))
+	assert.NotNil(t, parseNamingSeriesError(417, `{"exception": "frappe.exceptions.MandatoryError: [User, new-user-1]: naming_series"}`))
+	assert.Nil(t, parseNamingSeriesError(417, `{"exception": "frappe.exceptions.MandatoryError: [User, new-user-1]: first_name"}`))
+	assert.Nil(t, parseNamingSeriesError(500, `Internal Server Error`))
+}
+
+func TestParseUserRoleProfiles(t *testing.T) {
+	t.Run("single field", func(t *testing.T) {
+		profiles, err := parseUserRoleProfiles(json.RawMessage(`{"name":"jane@example.com","role_profile_name":"Employee"}`))
+		require.NoError(t, err)
+		assert.False(t, profiles.ChildTable)
+		assert.True(t, profiles.Has("employee"))
+	})
+
+	t.Run("child table", func(t *testing.T) {
+		profiles, err := parseUserRoleProfiles(json.RawMessage(`{"name":"jane@example.com","role_profile_name":null,"role_profiles":[{"role_profile":"Employee"}]}`))
+		require.NoError(t, err)
+		assert.True(t, profiles.ChildTable)
+		assert.Equal(t, []string{"Employee"}, profiles.Profiles)
+	})
+
+	t.Run("created with no roles", func(t *testing.T) {
+		profiles, err := parseUserRoleProfiles(json.RawMessage(`{"name":"jane@example.com","role_profiles":[]}`))
+		require.NoError(t, err)
+		assert.True(t, profiles.ChildTable)
+		assert.False(t, profiles.Has("Employee"))
+	})
+}
+
+func TestRoleProfileUpdateBody(t *testing.T) {
+	assert.Equal(t, map[string]interface{}{"role_profile_name": "Employee"}, roleProfileUpdateBody("Employee", false))
+	assert.Equal(t, map[string]interface{}{
+		"role_profiles": []map[string]interface{}{{"role_profile": "Employee"}},
+	}, roleProfileUpdateBody("Employee", true))
+}
+
+func TestUpdateEmployeeRetriesOnTheLatestDocument(t *testing.T) {
+	var puts []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			_, _ = w.Write([]byte(`{"data": {"name": "HR-EMP-1", "custom_chat_id": "", "cell_number": "+100", "modified": "2024-05-17 08:30:00.000001"}}`))
+		case http.MethodPut:
+			var body map[string]interface{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			puts = append(puts, body)
+			if len(puts) == 1 {
+				w.WriteHeader(http.StatusExpectationFailed)
+				_, _ = w.Write([]byte(`{"exc_type": "TimestampMismatchError", "exception": "frappe.exceptions.TimestampMismatchError: Document has been modified after you have opened it"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"data": {}}`))
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "key", "secret")
+	_, err := client.UpdateEmployee(&Employee{Name: "HR-EMP-1", CustomChatID: "user-id"})
+	require.NoError(t, err)
+
+	require.Len(t, puts, 2)
+	assert.NotContains(t, puts[0], "modified")
+	assert.Equal(t, map[string]interface{}{"custom_chat_id": "user-id", "modified": "2024-05-17 08:30:00.000001"}, puts[1])
+}
+
+func TestDuplicateEntryIsNotAConcurrentModification(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"exc_type": "DuplicateEntryError"}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "key", "secret")
+	_, err := client.UpdateEmployee(&Employee{Name: "HR-EMP-1", CustomChatID: "user-id"})
+	require.Error(t, err)
+
+	var modifiedErr *DocumentModifiedError
+	assert.False(t, errors.As(err, &modifiedErr))
+	assert.Equal(t, 1, requests, "nothing is re-fetched or retried")
+}
@@ -0,0 +1,47 @@
+package erpnext
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// CommentAuthor is the name shown as the author of comments the plugin adds
+const CommentAuthor = "Mattermost ERP Sync"
+
+// AddComment adds a comment to the timeline of a document, e.g. to leave an audit trail of
+// changes the plugin made
+func (c *Client) AddComment(doctype, name, text string) error {
+	bodyData, err := json.Marshal(map[string]interface{}{
+		"reference_doctype": doctype,
+		"reference_name":    name,
+		"content":           text,
+		"comment_email":     "",
+		"comment_by":        CommentAuthor,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal comment data")
+	}
+
+	reqURL := fmt.Sprintf("%s/api/method/frappe.desk.form.utils.add_comment", c.URL)
+	req, err := c.newRequest(http.MethodPost, reqURL, bytes.NewBuffer(bodyData))
+	if err != nil {
+		return errors.Wrap(err, "failed to create comment request")
+	}
+
+	resp, err := c.doWrite(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to execute comment request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("ERPNext API returned status code %d when adding comment to %s %s: %s", resp.StatusCode, doctype, name, string(body))
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package erpnext
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// RequestLimiter caps the number of requests a client has in flight at once, so no caller can
+// open more connections to ERPNext than it was configured to allow. A nil limiter never limits.
+type RequestLimiter struct {
+	slots chan struct{}
+}
+
+// NewRequestLimiter returns a limiter allowing max requests in flight, or nil, meaning no
+// limit, when max isn't positive
+func NewRequestLimiter(max int) *RequestLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &RequestLimiter{slots: make(chan struct{}, max)}
+}
+
+// acquire waits for a free slot. Returns the context's error, holding no slot, when ctx is
+// done first.
+func (l *RequestLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees a slot taken by acquire
+func (l *RequestLimiter) release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
+
+// InFlight returns the number of requests currently holding a slot
+func (l *RequestLimiter) InFlight() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.slots)
+}
+
+// releaseOnClose frees a request's limiter slot once its response body is closed, since the
+// connection stays in use until the body has been read
+type releaseOnClose struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releaseOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
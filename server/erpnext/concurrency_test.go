@@ -0,0 +1,82 @@
+package erpnext
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestLimiterCapsInFlightRequests(t *testing.T) {
+	var inFlight, maxInFlight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		current := atomic.AddInt32(&inFlight, 1)
+		for {
+			seen := atomic.LoadInt32(&maxInFlight)
+			if current <= seen || atomic.CompareAndSwapInt32(&maxInFlight, seen, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+		_, _ = w.Write([]byte(`{"message":"ok"}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "key", "secret")
+	client.RequestLimiter = NewRequestLimiter(2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, client.Ping())
+		}()
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, atomic.LoadInt32(&maxInFlight), int32(2))
+	assert.Equal(t, 0, client.RequestLimiter.InFlight(), "every slot is released once the bodies are closed")
+}
+
+func TestRequestLimiterReleasesEveryEmployeePage(t *testing.T) {
+	var pages int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		// Three full pages, then a short last one
+		page := int(atomic.AddInt32(&pages, 1))
+		count := 200
+		if page > 3 {
+			count = 5
+		}
+		_, _ = w.Write([]byte(fullEmployeePage((page-1)*200, count)))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "key", "secret")
+	client.RequestLimiter = NewRequestLimiter(2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	employees, err := client.WithContext(ctx).GetEmployees()
+	require.NoError(t, err, "fetching more pages than the limiter has slots must not stall")
+	assert.Len(t, employees, 605)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&pages))
+	assert.Equal(t, 0, client.RequestLimiter.InFlight())
+}
+
+func TestRequestLimiterDisabled(t *testing.T) {
+	require.Nil(t, NewRequestLimiter(0))
+
+	var limiter *RequestLimiter
+	require.NoError(t, limiter.acquire(context.Background()))
+	limiter.release()
+	assert.Equal(t, 0, limiter.InFlight())
+}
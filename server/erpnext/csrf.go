@@ -0,0 +1,113 @@
+package erpnext
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// CSRFHeader is the header Frappe checks for the CSRF token on write requests
+const CSRFHeader = "X-Frappe-CSRF-Token"
+
+// csrfTokenPattern matches the token Frappe embeds in the desk page, e.g. frappe.csrf_token = "abc123";
+var csrfTokenPattern = regexp.MustCompile(`csrf_token\s*[=:]\s*["']([^"']+)["']`)
+
+// csrfCache holds the CSRF token fetched for a client, shared by concurrent writes
+type csrfCache struct {
+	mu    sync.Mutex
+	token string
+}
+
+// getCSRFToken returns the cached CSRF token, fetching it from ERPNext when there is none yet or
+// when refresh is set because ERPNext rejected the cached one
+func (c *Client) getCSRFToken(refresh bool) (string, error) {
+	c.csrf.mu.Lock()
+	defer c.csrf.mu.Unlock()
+
+	if c.csrf.token != "" && !refresh {
+		return c.csrf.token, nil
+	}
+
+	token, err := c.fetchCSRFToken()
+	if err != nil {
+		return "", err
+	}
+	c.csrf.token = token
+	return token, nil
+}
+
+// fetchCSRFToken loads the desk page, which carries the CSRF token for the authenticated user
+// either in a response header or embedded in the page
+func (c *Client) fetchCSRFToken() (string, error) {
+	req, err := c.newRequest(http.MethodGet, fmt.Sprintf("%s/app", c.URL), nil)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create CSRF token request")
+	}
+	req.Header.Set("Accept", "text/html")
+
+	resp, err := c.doRead(req)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to fetch CSRF token")
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("ERPNext API returned status code %d when fetching CSRF token", resp.StatusCode)
+	}
+
+	if token := resp.Header.Get(CSRFHeader); token != "" {
+		return token, nil
+	}
+	if match := csrfTokenPattern.FindSubmatch(body); match != nil {
+		return string(match[1]), nil
+	}
+
+	return "", errors.New("CSRF token not found in ERPNext response")
+}
+
+// doWriteWithCSRF executes a write with the CSRF token attached. A 403 usually means the cached
+// token expired, so the token is refreshed and the write retried once.
+func (c *Client) doWriteWithCSRF(req *http.Request) (*http.Response, error) {
+	token, err := c.getCSRFToken(false)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(CSRFHeader, token)
+
+	resp, err := c.do(req, c.WritePolicy, isRetryableWrite)
+	if err != nil || resp.StatusCode != http.StatusForbidden {
+		return resp, err
+	}
+
+	// Without a replayable body the rejection is returned as is
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	fmt.Printf("ERPNext rejected write with status 403, refreshing CSRF token and retrying\n")
+
+	token, err = c.getCSRFToken(true)
+	if err != nil {
+		return nil, err
+	}
+
+	retryReq := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to replay request body")
+		}
+		retryReq.Body = body
+	}
+	retryReq.Header.Set(CSRFHeader, token)
+
+	return c.do(retryReq, c.WritePolicy, isRetryableWrite)
+}
@@ -0,0 +1,131 @@
+package erpnext
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// MandatoryFieldError is returned when ERPNext rejects a document because required fields are missing
+type MandatoryFieldError struct {
+	StatusCode int
+	Fields     []string
+	Body       string
+}
+
+func (e *MandatoryFieldError) Error() string {
+	return fmt.Sprintf("ERPNext API returned status code %d: missing mandatory fields %s: %s",
+		e.StatusCode, strings.Join(e.Fields, ", "), e.Body)
+}
+
+// mandatoryErrorPattern matches Frappe's "MandatoryError: [Employee, new-employee-1]: company, date_of_joining"
+var mandatoryErrorPattern = regexp.MustCompile(`MandatoryError:\s*\[[^\]]*\]:\s*([A-Za-z0-9_,\s]+)`)
+
+// parseMandatoryFieldError returns a MandatoryFieldError when the response body reports missing
+// mandatory fields, or nil when the failure is something else
+func parseMandatoryFieldError(statusCode int, body string) *MandatoryFieldError {
+	match := mandatoryErrorPattern.FindStringSubmatch(body)
+	if match == nil {
+		return nil
+	}
+
+	var fields []string
+	for _, field := range strings.Split(match[1], ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	return &MandatoryFieldError{
+		StatusCode: statusCode,
+		Fields:     fields,
+		Body:       body,
+	}
+}
+
+// NamingSeriesError is returned when ERPNext refuses to create a document because its doctype is
+// named by a series and no naming_series was sent
+type NamingSeriesError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *NamingSeriesError) Error() string {
+	return fmt.Sprintf("ERPNext API returned status code %d: a naming series is required (naming_series): %s", e.StatusCode, e.Body)
+}
+
+// parseNamingSeriesError returns a NamingSeriesError when the response reports a missing naming
+// series, either as Frappe's "Naming Series mandatory" or as a missing naming_series field, or nil
+// when the failure is something else
+func parseNamingSeriesError(statusCode int, body string) *NamingSeriesError {
+	missing := strings.Contains(strings.ToLower(body), "naming series mandatory")
+	if mandatoryErr := parseMandatoryFieldError(statusCode, body); mandatoryErr != nil {
+		for _, field := range mandatoryErr.Fields {
+			if field == "naming_series" {
+				missing = true
+			}
+		}
+	}
+	if !missing {
+		return nil
+	}
+	return &NamingSeriesError{
+		StatusCode: statusCode,
+		Body:       body,
+	}
+}
+
+// DocumentModifiedError is returned when ERPNext rejects a write because the document was changed
+// by someone else after we read it (Frappe's TimestampMismatchError)
+type DocumentModifiedError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *DocumentModifiedError) Error() string {
+	return fmt.Sprintf("ERPNext API returned status code %d: document has been modified: %s", e.StatusCode, e.Body)
+}
+
+// parseDocumentModifiedError returns a DocumentModifiedError when the response reports a
+// concurrent modification, or nil when the failure is something else. Frappe sends these as 417
+// like every other validation error, and uses 409 for duplicate entries, so only the exception
+// in the body tells them apart.
+func parseDocumentModifiedError(statusCode int, body string) *DocumentModifiedError {
+	if !strings.Contains(body, "TimestampMismatchError") && !strings.Contains(body, "Document has been modified") {
+		return nil
+	}
+	return &DocumentModifiedError{
+		StatusCode: statusCode,
+		Body:       body,
+	}
+}
+
+// unavailableStatusPattern matches the status codes in client errors that mean ERPNext can't be
+// used at all right now: bad credentials, or the server or its proxy being down
+var unavailableStatusPattern = regexp.MustCompile(`status code (401|403|502|503|504)\b`)
+
+// IsUnavailableError reports whether err means ERPNext itself is unreachable or rejecting our
+// credentials, as opposed to a problem with a single record
+func IsUnavailableError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return unavailableStatusPattern.MatchString(err.Error())
+}
@@ -0,0 +1,170 @@
+package erpnext
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxFileDownloadSize caps downloaded files so a misconfigured attachment can't exhaust memory
+const maxFileDownloadSize = 10 * 1024 * 1024
+
+// FileURL resolves a file URL stored on a document (usually relative, e.g. /files/photo.jpg)
+// against the ERPNext base URL. Absolute URLs are only accepted on ERPNext's own scheme and host,
+// so a stored URL can't send the API token, or the plugin's requests, anywhere else.
+func (c *Client) FileURL(fileURL string) (string, error) {
+	base, err := url.Parse(c.URL)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to parse ERPNext URL")
+	}
+
+	resolved := fileURL
+	if !strings.HasPrefix(fileURL, "http://") && !strings.HasPrefix(fileURL, "https://") {
+		if !strings.HasPrefix(fileURL, "/") {
+			fileURL = "/" + fileURL
+		}
+		resolved = strings.TrimRight(c.URL, "/") + fileURL
+	}
+
+	parsed, err := url.Parse(resolved)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to parse file URL %s", fileURL)
+	}
+	if !strings.EqualFold(parsed.Scheme, base.Scheme) || !strings.EqualFold(parsed.Host, base.Host) {
+		return "", errors.Errorf("file URL %s is not on the ERPNext site %s", fileURL, base.Host)
+	}
+	return parsed.String(), nil
+}
+
+// DownloadFile downloads a file attached in ERPNext, e.g. an employee image. Private files
+// need the API token, so the request is authorized like every other call, which is why only
+// files on the ERPNext site itself are downloaded.
+func (c *Client) DownloadFile(fileURL string) ([]byte, string, error) {
+	resolved, err := c.FileURL(fileURL)
+	if err != nil {
+		return nil, "", err
+	}
+
+	req, err := c.newRequest(http.MethodGet, resolved, nil)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to create file download request")
+	}
+	req.Header.Del("Accept")
+
+	resp, err := c.doRead(req)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to download file")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", errors.Errorf("failed to download file %s: status code %d", fileURL, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFileDownloadSize+1))
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to read downloaded file")
+	}
+	if len(data) > maxFileDownloadSize {
+		return nil, "", errors.Errorf("file %s is larger than %d bytes", fileURL, maxFileDownloadSize)
+	}
+
+	return data, resp.Header.Get("Content-Type"), nil
+}
+
+// UploadEmployeeImage uploads an image through the ERPNext file upload API, attaches it to the
+// employee and sets it as the employee's image field
+func (c *Client) UploadEmployeeImage(employeeName string, data []byte, filename string) error {
+	uploadURL := fmt.Sprintf("%s/api/method/upload_file", c.URL)
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	formFields := map[string]string{
+		"doctype":    "Employee",
+		"docname":    employeeName,
+		"fieldname":  "image",
+		"is_private": "0",
+	}
+	for key, value := range formFields {
+		if err := writer.WriteField(key, value); err != nil {
+			return errors.Wrap(err, "failed to write upload form field")
+		}
+	}
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		return errors.Wrap(err, "failed to create upload form file")
+	}
+	if _, err := part.Write(data); err != nil {
+		return errors.Wrap(err, "failed to write upload file data")
+	}
+	if err := writer.Close(); err != nil {
+		return errors.Wrap(err, "failed to finish upload form")
+	}
+
+	req, err := c.newRequest(http.MethodPost, uploadURL, &body)
+	if err != nil {
+		return errors.Wrap(err, "failed to create upload request")
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	fmt.Printf("Uploading image for employee %s to: %s\n", employeeName, uploadURL)
+
+	resp, err := c.doWrite(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to execute upload request")
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	fmt.Printf("Upload file response status: %d\n", resp.StatusCode)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ERPNext API returned status code %d when uploading file: %s", resp.StatusCode, string(respBody))
+	}
+
+	var uploadResp struct {
+		Message struct {
+			FileURL string `json:"file_url"`
+		} `json:"message"`
+	}
+	if err := json.Unmarshal(respBody, &uploadResp); err != nil {
+		return errors.Wrap(err, "failed to decode upload response: "+string(respBody))
+	}
+	if uploadResp.Message.FileURL == "" {
+		return fmt.Errorf("ERPNext upload response has no file_url: %s", string(respBody))
+	}
+
+	// Attaching a file doesn't always set the field, so set the image explicitly
+	updateBody, err := json.Marshal(map[string]interface{}{
+		"image": uploadResp.Message.FileURL,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal employee image update")
+	}
+
+	updateURL := fmt.Sprintf("%s/api/resource/Employee/%s", c.URL, url.PathEscape(employeeName))
+	req, err = c.newRequest(http.MethodPut, updateURL, bytes.NewBuffer(updateBody))
+	if err != nil {
+		return errors.Wrap(err, "failed to create employee image update request")
+	}
+
+	resp, err = c.doWrite(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to execute employee image update request")
+	}
+	defer resp.Body.Close()
+
+	respBody, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("ERPNext API returned status code %d when setting employee image: %s", resp.StatusCode, string(respBody))
+	}
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package erpnext
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadFileStaysOnERPNextSite(t *testing.T) {
+	erpnext := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "token key:secret", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("png"))
+	}))
+	defer erpnext.Close()
+
+	otherHit := false
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		otherHit = true
+	}))
+	defer other.Close()
+
+	client := NewClient(erpnext.URL, "key", "secret")
+
+	for _, fileURL := range []string{"/files/photo.png", "files/photo.png", erpnext.URL + "/files/photo.png", "//" + other.Listener.Addr().String() + "/x"} {
+		data, contentType, err := client.DownloadFile(fileURL)
+		require.NoError(t, err, fileURL)
+		assert.Equal(t, []byte("png"), data)
+		assert.Equal(t, "image/png", contentType)
+	}
+
+	_, _, err := client.DownloadFile(other.URL + "/files/photo.png")
+	require.Error(t, err)
+	assert.False(t, otherHit, "a file on another host must not be requested with the API token")
+}
@@ -0,0 +1,169 @@
+package erpnext
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RequestPolicy is the timeout and retry behavior for one class of requests
+type RequestPolicy struct {
+	// Timeout bounds a single attempt, including reading the response body
+	Timeout time.Duration
+
+	// MaxRetries is the number of extra attempts after a retryable failure
+	MaxRetries int
+}
+
+// Default policies: reads are idempotent and retried freely, writes get a longer timeout and are
+// not retried unless configured, because a timed-out write may already have been applied
+var (
+	DefaultReadPolicy = RequestPolicy{
+		Timeout:    30 * time.Second,
+		MaxRetries: 2,
+	}
+	DefaultWritePolicy = RequestPolicy{
+		Timeout:    60 * time.Second,
+		MaxRetries: 0,
+	}
+)
+
+// requestRetryBaseDelay is the initial backoff between attempts; it doubles on every retry
+const requestRetryBaseDelay = 500 * time.Millisecond
+
+// doRead executes an idempotent request (GET) with the read policy
+func (c *Client) doRead(req *http.Request) (*http.Response, error) {
+	return c.do(req, c.ReadPolicy, isRetryableRead)
+}
+
+// doWrite executes a create or update with the write policy. Writes are only retried when the
+// server can't have applied them: the connection was never made, or ERPNext refused the request
+// outright with 429 or 503.
+func (c *Client) doWrite(req *http.Request) (*http.Response, error) {
+	if c.UseCSRF {
+		return c.doWriteWithCSRF(req)
+	}
+	return c.do(req, c.WritePolicy, isRetryableWrite)
+}
+
+// do executes req under policy, retrying with exponential backoff while retryable says so
+func (c *Client) do(req *http.Request, policy RequestPolicy, retryable func(*http.Response, error) bool) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		attemptReq := req
+		if attempt > 0 {
+			// The body was consumed by the previous attempt
+			if req.Body != nil && req.GetBody == nil {
+				return nil, errors.New("request body can't be replayed for a retry")
+			}
+			attemptReq = req.Clone(req.Context())
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to replay request body")
+				}
+				attemptReq.Body = body
+			}
+		}
+
+		resp, err := c.doAttempt(attemptReq, policy.Timeout)
+		if attempt >= policy.MaxRetries || !retryable(resp, err) {
+			return resp, err
+		}
+
+		// Fail fast once the sync has spent its retry budget
+		delay := requestRetryBaseDelay * time.Duration(1<<attempt)
+		if !RetryBudgetFromContext(req.Context()).Take(delay) {
+			return resp, err
+		}
+
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if err := sleepContext(req.Context(), delay); err != nil {
+			return nil, errors.Wrap(err, "stopped waiting to retry")
+		}
+	}
+}
+
+// sleepContext waits for delay, returning ctx's error when it is done first
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// doAttempt executes a single attempt bounded by timeout, once the request limiter has a free
+// slot. The timeout and the slot stay in force until the response body is closed.
+func (c *Client) doAttempt(req *http.Request, timeout time.Duration) (*http.Response, error) {
+	if err := c.RequestLimiter.acquire(req.Context()); err != nil {
+		return nil, errors.Wrap(err, "gave up waiting for a free ERPNext request slot")
+	}
+
+	resp, err := c.doTimedAttempt(req, timeout)
+	if err != nil {
+		c.RequestLimiter.release()
+		return nil, err
+	}
+	resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: c.RequestLimiter.release}
+	return resp, nil
+}
+
+// doTimedAttempt executes a single attempt bounded by timeout
+func (c *Client) doTimedAttempt(req *http.Request, timeout time.Duration) (*http.Response, error) {
+	if timeout <= 0 {
+		return c.HTTPClient.Do(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	resp, err := c.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases a request's timeout context once its response body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// isRetryableRead retries network errors, throttling and gateway errors
+func isRetryableRead(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// isRetryableWrite retries only failures where the write certainly wasn't applied
+func isRetryableWrite(resp *http.Response, err error) bool {
+	if err != nil {
+		var opErr *net.OpError
+		return errors.As(err, &opErr) && opErr.Op == "dial"
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable
+}
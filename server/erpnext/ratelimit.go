@@ -0,0 +1,81 @@
+package erpnext
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rate limit headers ERPNext may send with responses
+const (
+	RateLimitRemainingHeader = "X-RateLimit-Remaining"
+	RateLimitResetHeader     = "X-RateLimit-Reset"
+)
+
+// DefaultRateLimitMaxWait bounds a single pause when RateLimitPacing.MaxWait is zero
+const DefaultRateLimitMaxWait = 5 * time.Minute
+
+// RateLimitPacing pauses paged reads when ERPNext reports that few requests are left in the
+// current rate limit window, instead of running into a 429 halfway through
+type RateLimitPacing struct {
+	// MinRemaining is the remaining request count at or below which the next page waits for the
+	// window to reset. 0 disables pacing.
+	MinRemaining int
+
+	// MaxWait bounds a single pause, DefaultRateLimitMaxWait when zero
+	MaxWait time.Duration
+}
+
+// rateLimitDelay returns how long to wait before the next request based on the rate limit
+// headers of the last response, 0 when there is no need to wait or the headers are missing.
+// The reset header may be the seconds until the window resets or a Unix timestamp.
+func (p RateLimitPacing) rateLimitDelay(header http.Header, now time.Time) time.Duration {
+	if p.MinRemaining <= 0 {
+		return 0
+	}
+
+	remaining, err := strconv.Atoi(strings.TrimSpace(header.Get(RateLimitRemainingHeader)))
+	if err != nil || remaining > p.MinRemaining {
+		return 0
+	}
+
+	reset, err := strconv.ParseFloat(strings.TrimSpace(header.Get(RateLimitResetHeader)), 64)
+	if err != nil || reset <= 0 {
+		return 0
+	}
+
+	var delay time.Duration
+	if reset > 1e9 {
+		// Large values are an absolute Unix timestamp
+		delay = time.Unix(int64(reset), 0).Sub(now)
+	} else {
+		delay = time.Duration(reset * float64(time.Second))
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	maxWait := p.MaxWait
+	if maxWait <= 0 {
+		maxWait = DefaultRateLimitMaxWait
+	}
+	if delay > maxWait {
+		delay = maxWait
+	}
+	return delay
+}
+
+// paceAfter waits when the response headers say the rate limit is nearly used up. Returns the
+// client context's error when it is done before the wait is over.
+func (c *Client) paceAfter(header http.Header) error {
+	delay := c.RateLimitPacing.rateLimitDelay(header, time.Now())
+	if delay <= 0 {
+		return nil
+	}
+
+	fmt.Printf("ERPNext rate limit nearly reached (%s remaining), waiting %s before the next page\n",
+		header.Get(RateLimitRemainingHeader), delay)
+	return sleepContext(c.context(), delay)
+}
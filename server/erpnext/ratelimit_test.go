@@ -0,0 +1,81 @@
+package erpnext
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitDelay(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	pacing := RateLimitPacing{MinRemaining: 5, MaxWait: time.Minute}
+
+	headers := func(remaining, reset string) http.Header {
+		header := http.Header{}
+		if remaining != "" {
+			header.Set(RateLimitRemainingHeader, remaining)
+		}
+		if reset != "" {
+			header.Set(RateLimitResetHeader, reset)
+		}
+		return header
+	}
+
+	for _, tc := range []struct {
+		name     string
+		pacing   RateLimitPacing
+		header   http.Header
+		expected time.Duration
+	}{
+		{name: "disabled", pacing: RateLimitPacing{}, header: headers("0", "10"), expected: 0},
+		{name: "no headers", pacing: pacing, header: headers("", ""), expected: 0},
+		{name: "plenty remaining", pacing: pacing, header: headers("50", "10"), expected: 0},
+		{name: "low remaining waits for reset seconds", pacing: pacing, header: headers("5", "10"), expected: 10 * time.Second},
+		{name: "reset as unix timestamp", pacing: pacing, header: headers("1", "1700000020"), expected: 20 * time.Second},
+		{name: "reset in the past", pacing: pacing, header: headers("1", "1699999990"), expected: 0},
+		{name: "wait is capped", pacing: pacing, header: headers("0", "3600"), expected: time.Minute},
+		{name: "missing reset", pacing: pacing, header: headers("0", ""), expected: 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.pacing.rateLimitDelay(tc.header, now))
+		})
+	}
+}
+
+// fullEmployeePage returns a JSON page of count employees, enough to make GetEmployees ask for
+// the next page
+func fullEmployeePage(start, count int) string {
+	rows := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		rows = append(rows, fmt.Sprintf(`{"name": "HR-EMP-%d"}`, start+i))
+	}
+	return `{"data": [` + strings.Join(rows, ",") + `]}`
+}
+
+func TestRateLimitPacingStopsWithContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set(RateLimitRemainingHeader, "0")
+		w.Header().Set(RateLimitResetHeader, "60")
+		_, _ = w.Write([]byte(fullEmployeePage(0, 200)))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "key", "secret")
+	client.RateLimitPacing = RateLimitPacing{MinRemaining: 1}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	_, err := client.WithContext(ctx).GetEmployees()
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(started), 5*time.Second, "the wait for the rate limit reset is cut short")
+}
@@ -0,0 +1,78 @@
+package erpnext
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Response shapes of single documents. Frappe wraps the document returned by the REST API as
+// {"data": {...}}, while some versions and whitelisted methods answer {"message": {...}}.
+const (
+	// ResponseShapeAuto accepts either shape, preferring "data"
+	ResponseShapeAuto    = "auto"
+	ResponseShapeData    = "data"
+	ResponseShapeMessage = "message"
+)
+
+// singleDocument returns the document wrapped in a single-document response, according to the
+// client's ResponseShape
+func (c *Client) singleDocument(body []byte) (json.RawMessage, error) {
+	var wrapped struct {
+		Data    json.RawMessage `json:"data"`
+		Message json.RawMessage `json:"message"`
+	}
+	if err := json.Unmarshal(body, &wrapped); err != nil {
+		return nil, err
+	}
+
+	switch c.ResponseShape {
+	case ResponseShapeData:
+		if isDocument(wrapped.Data) {
+			return wrapped.Data, nil
+		}
+	case ResponseShapeMessage:
+		if isDocument(wrapped.Message) {
+			return wrapped.Message, nil
+		}
+	default:
+		if isDocument(wrapped.Data) {
+			return wrapped.Data, nil
+		}
+		if isDocument(wrapped.Message) {
+			return wrapped.Message, nil
+		}
+	}
+
+	shape := c.ResponseShape
+	if shape == "" {
+		shape = ResponseShapeAuto
+	}
+	return nil, fmt.Errorf("no document in response (expected shape %s)", shape)
+}
+
+// createdDocumentName returns the name of the document a create request returned
+func (c *Client) createdDocumentName(body []byte) (string, error) {
+	raw, err := c.singleDocument(body)
+	if err != nil {
+		return "", err
+	}
+
+	var document struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(raw, &document); err != nil {
+		return "", err
+	}
+	if document.Name == "" {
+		return "", errors.New("created document has no name")
+	}
+	return document.Name, nil
+}
+
+// isDocument reports whether raw holds a JSON object
+func isDocument(raw json.RawMessage) bool {
+	var document map[string]interface{}
+	return len(raw) > 0 && json.Unmarshal(raw, &document) == nil && document != nil
+}
@@ -0,0 +1,90 @@
+package erpnext
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// respondWith starts a server answering every request with body
+func respondWith(t *testing.T, body string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCreateAcceptsBothResponseShapes(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		shape string
+		body  string
+	}{
+		{name: "data shape", body: `{"data": {"name": "DOC-1", "doctype": "Employee"}}`},
+		{name: "message shape", body: `{"message": {"name": "DOC-1", "doctype": "Employee"}}`},
+		{name: "data preferred over message", body: `{"data": {"name": "DOC-1"}, "message": {"name": "OTHER"}}`},
+		{name: "message with a plain data string", body: `{"data": "ok", "message": {"name": "DOC-1"}}`},
+		{name: "forced data shape", shape: ResponseShapeData, body: `{"data": {"name": "DOC-1"}}`},
+		{name: "forced message shape", shape: ResponseShapeMessage, body: `{"data": {"name": "OTHER"}, "message": {"name": "DOC-1"}}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := NewClient(respondWith(t, tc.body).URL, "key", "secret")
+			client.ResponseShape = tc.shape
+
+			employee, err := client.CreateEmployee(&Employee{CompanyEmail: "jane@example.com", FirstName: "Jane"})
+			require.NoError(t, err)
+			assert.Equal(t, "DOC-1", employee.Name)
+
+			user, err := client.CreateUser(&User{Email: "jane@example.com", FirstName: "Jane"})
+			require.NoError(t, err)
+			assert.Equal(t, "DOC-1", user.Name)
+		})
+	}
+}
+
+func TestCreateEmployeeReturnsComposedName(t *testing.T) {
+	client := NewClient(respondWith(t, `{"data": {"name": "HR-EMP-1", "employee_name": "Jane Maria Doe"}}`).URL, "key", "secret")
+
+	employee, err := client.CreateEmployee(&Employee{CompanyEmail: "jane@example.com", FirstName: "Jane"})
+	require.NoError(t, err)
+	assert.Equal(t, "HR-EMP-1", employee.Name)
+	assert.Equal(t, "Jane Maria Doe", employee.EmployeeName)
+}
+
+func TestCreateRejectsResponsesWithoutDocument(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		shape string
+		body  string
+	}{
+		{name: "no document", body: `{"message": "ok"}`},
+		{name: "document without name", body: `{"data": {"doctype": "Employee"}}`},
+		{name: "forced shape missing", shape: ResponseShapeData, body: `{"message": {"name": "DOC-1"}}`},
+		{name: "not JSON", body: `<html>Internal Server Error</html>`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := NewClient(respondWith(t, tc.body).URL, "key", "secret")
+			client.ResponseShape = tc.shape
+
+			_, err := client.CreateEmployee(&Employee{CompanyEmail: "jane@example.com", FirstName: "Jane"})
+			assert.Error(t, err)
+
+			_, err = client.CreateUser(&User{Email: "jane@example.com", FirstName: "Jane"})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestGetEmployeeAcceptsMessageShape(t *testing.T) {
+	client := NewClient(respondWith(t, `{"message": {"name": "HR-EMP-1", "company_email": "jane@example.com"}}`).URL, "key", "secret")
+
+	employee, err := client.GetEmployee("HR-EMP-1")
+	require.NoError(t, err)
+	require.NotNil(t, employee)
+	assert.Equal(t, "jane@example.com", employee.CompanyEmail)
+}
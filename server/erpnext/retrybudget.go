@@ -0,0 +1,76 @@
+package erpnext
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RetryBudget bounds the total time a sync may spend waiting between retries, across every
+// client call it makes. Once spent, failing calls are returned right away instead of retried, so
+// a degraded ERPNext can't use up a whole run on a few stuck records. A nil budget never limits.
+//
+// Every run gets its own budget, carried by the context its requests are made with, so runs
+// overlapping each other neither reset nor draw from each other's budget.
+type RetryBudget struct {
+	mu    sync.Mutex
+	limit time.Duration
+	used  time.Duration
+}
+
+// NewRetryBudget returns a budget of limit retry wait time. A zero limit only tracks usage.
+func NewRetryBudget(limit time.Duration) *RetryBudget {
+	return &RetryBudget{limit: limit}
+}
+
+// Take reserves delay of retry wait time. Returns false, reserving nothing, when the budget
+// can't cover it.
+func (b *RetryBudget) Take(delay time.Duration) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.limit > 0 && b.used+delay > b.limit {
+		return false
+	}
+	b.used += delay
+	return true
+}
+
+// Used returns the retry wait time taken from the budget
+func (b *RetryBudget) Used() time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// Limit returns the budget's limit, 0 when unlimited
+func (b *RetryBudget) Limit() time.Duration {
+	if b == nil {
+		return 0
+	}
+	return b.limit
+}
+
+// retryBudgetKey is the context key of the retry budget
+type retryBudgetKey struct{}
+
+// ContextWithRetryBudget returns a copy of ctx carrying budget, which every retry of the requests
+// made with it is drawn from
+func ContextWithRetryBudget(ctx context.Context, budget *RetryBudget) context.Context {
+	return context.WithValue(ctx, retryBudgetKey{}, budget)
+}
+
+// RetryBudgetFromContext returns the retry budget carried by ctx, nil, which never limits, when
+// there is none
+func RetryBudgetFromContext(ctx context.Context) *RetryBudget {
+	budget, _ := ctx.Value(retryBudgetKey{}).(*RetryBudget)
+	return budget
+}
@@ -0,0 +1,36 @@
+package erpnext
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryBudgetIsPerContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "key", "secret")
+
+	// Two runs at the same time, each with its own budget
+	first := NewRetryBudget(time.Second)
+	second := NewRetryBudget(time.Second)
+	firstCtx := ContextWithRetryBudget(context.Background(), first)
+	secondCtx := ContextWithRetryBudget(context.Background(), second)
+
+	assert.Error(t, client.WithContext(firstCtx).Ping())
+	assert.Equal(t, requestRetryBaseDelay, first.Used(), "the second retry doesn't fit in the budget")
+	assert.Zero(t, second.Used(), "the other run's budget is untouched")
+
+	assert.Error(t, client.WithContext(secondCtx).Ping())
+	assert.Equal(t, requestRetryBaseDelay, second.Used())
+	assert.Equal(t, requestRetryBaseDelay, first.Used())
+
+	assert.Nil(t, RetryBudgetFromContext(context.Background()))
+}
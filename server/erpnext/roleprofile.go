@@ -0,0 +1,147 @@
+package erpnext
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// UserRoleProfiles is the role profile state of an ERPNext user. Older versions hold a single
+// role_profile_name, while newer ones (v15 and later) use the role_profiles child table.
+type UserRoleProfiles struct {
+	Profiles []string
+
+	// ChildTable is true when the user document has the role_profiles child table
+	ChildTable bool
+}
+
+// Has reports whether profile is among the user's role profiles
+func (r *UserRoleProfiles) Has(profile string) bool {
+	for _, existing := range r.Profiles {
+		if strings.EqualFold(strings.TrimSpace(existing), strings.TrimSpace(profile)) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseUserRoleProfiles reads the role profiles out of a User document
+func parseUserRoleProfiles(raw json.RawMessage) (*UserRoleProfiles, error) {
+	var document struct {
+		RoleProfileName string             `json:"role_profile_name"`
+		RoleProfiles    *[]json.RawMessage `json:"role_profiles"`
+	}
+	if err := json.Unmarshal(raw, &document); err != nil {
+		return nil, err
+	}
+
+	profiles := &UserRoleProfiles{}
+	if name := strings.TrimSpace(document.RoleProfileName); name != "" {
+		profiles.Profiles = append(profiles.Profiles, name)
+	}
+	if document.RoleProfiles != nil {
+		profiles.ChildTable = true
+		for _, rowData := range *document.RoleProfiles {
+			var row struct {
+				RoleProfile string `json:"role_profile"`
+			}
+			if err := json.Unmarshal(rowData, &row); err != nil {
+				return nil, err
+			}
+			if name := strings.TrimSpace(row.RoleProfile); name != "" && !profiles.Has(name) {
+				profiles.Profiles = append(profiles.Profiles, name)
+			}
+		}
+	}
+
+	return profiles, nil
+}
+
+// GetUserRoleProfiles fetches the role profiles of the ERPNext user named name
+func (c *Client) GetUserRoleProfiles(name string) (*UserRoleProfiles, error) {
+	reqURL := fmt.Sprintf("%s/api/resource/User/%s", c.URL, url.PathEscape(name))
+
+	req, err := c.newRequest(http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create request")
+	}
+
+	resp, err := c.doRead(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to execute request")
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ERPNext API returned non-OK status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	raw, err := c.singleDocument(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode response: "+string(body))
+	}
+
+	profiles, err := parseUserRoleProfiles(raw)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode user: "+string(body))
+	}
+
+	return profiles, nil
+}
+
+// roleProfileUpdateBody builds the request body that sets profile on a user, through the
+// role_profiles child table when childTable is set, otherwise through role_profile_name
+func roleProfileUpdateBody(profile string, childTable bool) map[string]interface{} {
+	if childTable {
+		return map[string]interface{}{
+			"role_profiles": []map[string]interface{}{
+				{"role_profile": profile},
+			},
+		}
+	}
+	return map[string]interface{}{
+		"role_profile_name": profile,
+	}
+}
+
+// SetUserRoleProfile sets profile as the role profile of the ERPNext user named name, using the
+// role_profiles child table when childTable is set
+func (c *Client) SetUserRoleProfile(name, profile string, childTable bool) error {
+	reqURL := fmt.Sprintf("%s/api/resource/User/%s", c.URL, url.PathEscape(name))
+
+	bodyData, err := json.Marshal(roleProfileUpdateBody(profile, childTable))
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal role profile data")
+	}
+
+	fmt.Printf("Set user role profile request to: %s\n", reqURL)
+	fmt.Printf("Set user role profile request body: %s\n", string(bodyData))
+
+	req, err := c.newRequest(http.MethodPut, reqURL, bytes.NewBuffer(bodyData))
+	if err != nil {
+		return errors.Wrap(err, "failed to create update request")
+	}
+
+	resp, err := c.doWrite(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to execute update request")
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	fmt.Printf("Set user role profile response status: %d\n", resp.StatusCode)
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("ERPNext API returned status code %d when setting role profile: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
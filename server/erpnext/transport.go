@@ -0,0 +1,56 @@
+package erpnext
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// TransportSettings tunes connection pooling for the HTTP client talking to ERPNext
+type TransportSettings struct {
+	// MaxIdleConnsPerHost is the number of keep-alive connections kept open to ERPNext
+	MaxIdleConnsPerHost int
+
+	// MaxConnsPerHost caps the total connections to ERPNext, 0 means no limit
+	MaxConnsPerHost int
+
+	// IdleConnTimeout is how long an unused keep-alive connection is kept open
+	IdleConnTimeout time.Duration
+}
+
+// DefaultTransportSettings is used when no settings are configured. Go's default of 2 idle
+// connections per host makes parallel requests keep reopening connections.
+var DefaultTransportSettings = TransportSettings{
+	MaxIdleConnsPerHost: 10,
+	MaxConnsPerHost:     20,
+	IdleConnTimeout:     90 * time.Second,
+}
+
+// NewTransport builds an HTTP transport pooled according to settings, falling back to
+// DefaultTransportSettings for any value that isn't positive
+func NewTransport(settings TransportSettings) *http.Transport {
+	if settings.MaxIdleConnsPerHost <= 0 {
+		settings.MaxIdleConnsPerHost = DefaultTransportSettings.MaxIdleConnsPerHost
+	}
+	if settings.MaxConnsPerHost < 0 {
+		settings.MaxConnsPerHost = DefaultTransportSettings.MaxConnsPerHost
+	}
+	if settings.IdleConnTimeout <= 0 {
+		settings.IdleConnTimeout = DefaultTransportSettings.IdleConnTimeout
+	}
+
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          settings.MaxIdleConnsPerHost * 2,
+		MaxIdleConnsPerHost:   settings.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       settings.MaxConnsPerHost,
+		IdleConnTimeout:       settings.IdleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
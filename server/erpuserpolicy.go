@@ -0,0 +1,20 @@
+package main
+
+// Policies for ERPNext logins of employees that aren't Active, selected by the
+// NonActiveEmployeeUserPolicy setting
+const (
+	nonActiveUserCreate   = "create"
+	nonActiveUserSkip     = "skip"
+	nonActiveUserDisabled = "disabled"
+)
+
+// getNonActiveEmployeeUserPolicy returns the configured policy for ERPNext logins of non-active
+// employees, defaulting to creating them like any other login
+func (c *configuration) getNonActiveEmployeeUserPolicy() string {
+	switch c.NonActiveEmployeeUserPolicy {
+	case nonActiveUserSkip, nonActiveUserDisabled:
+		return c.NonActiveEmployeeUserPolicy
+	default:
+		return nonActiveUserCreate
+	}
+}
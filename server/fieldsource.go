@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// Fields that both syncs can write on records that already exist, and so could be written by one
+// direction and reverted by the other. Names and emails are only ever written when a record is
+// created, and chat IDs always by the plugin itself, so they need no source of truth.
+const (
+	fieldPhone = "phone"
+	fieldImage = "image"
+)
+
+// Sources of truth for a field, selected per field by the FieldSourceOfTruth setting
+const (
+	fieldSourceBoth       = "both"
+	fieldSourceMattermost = "mattermost"
+	fieldSourceERPNext    = "erpnext"
+)
+
+// guardedFields are the fields FieldSourceOfTruth accepts
+var guardedFields = []string{fieldPhone, fieldImage}
+
+// getFieldSources parses FieldSourceOfTruth into a source per guarded field. Fields not listed
+// are written by both directions, as before the setting existed.
+func (c *configuration) getFieldSources() (map[string]string, error) {
+	sources := map[string]string{}
+	for _, field := range guardedFields {
+		sources[field] = fieldSourceBoth
+	}
+	if strings.TrimSpace(c.FieldSourceOfTruth) == "" {
+		return sources, nil
+	}
+
+	var configured map[string]string
+	if err := json.Unmarshal([]byte(c.FieldSourceOfTruth), &configured); err != nil {
+		return sources, errors.Wrap(err, "FieldSourceOfTruth must be a JSON object of field to source")
+	}
+
+	var problems []string
+	for field, source := range configured {
+		if _, known := sources[field]; !known {
+			problems = append(problems, "unknown field "+field+" (must be one of "+strings.Join(guardedFields, ", ")+")")
+			continue
+		}
+		switch source = strings.ToLower(strings.TrimSpace(source)); source {
+		case fieldSourceBoth, fieldSourceMattermost, fieldSourceERPNext:
+			sources[field] = source
+		default:
+			problems = append(problems, "field "+field+" has invalid source "+source+" (must be mattermost, erpnext or both)")
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return sources, errors.Errorf("FieldSourceOfTruth: %s", strings.Join(problems, "; "))
+	}
+	return sources, nil
+}
+
+// writesField reports whether the given sync direction may overwrite field on existing records.
+// The mm→erp sync writes ERPNext and so needs Mattermost to be authoritative, the erp→mm sync
+// the other way round. With "both" the last sync to run wins, which is why a field should only
+// have one source once both directions are scheduled.
+func (c *configuration) writesField(field, direction string) bool {
+	// Invalid entries were already reported when the configuration was loaded
+	sources, _ := c.getFieldSources()
+	switch sources[field] {
+	case fieldSourceMattermost:
+		return direction == directionMMToERP
+	case fieldSourceERPNext:
+		return direction == directionERPToMM
+	default:
+		return true
+	}
+}
+
+// cellNumberForEmployeeUpdate returns the phone number the mm→erp sync writes to an existing
+// employee, or "" when SyncPhoneNumbers is off or ERPNext owns the phone number
+func (c *configuration) cellNumberForEmployeeUpdate(user *model.User) string {
+	if !c.writesField(fieldPhone, directionMMToERP) {
+		return ""
+	}
+	return c.phoneNumberForUser(user)
+}
+
+// fieldSourceNote describes in the sync schema when a direction leaves a guarded field alone
+func (c *configuration) fieldSourceNote(field, direction string) string {
+	if c.writesField(field, direction) {
+		return ""
+	}
+	return "on creation only, the other system is the source of truth"
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/erpnext"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetFieldSources(t *testing.T) {
+	t.Run("defaults to both", func(t *testing.T) {
+		sources, err := (&configuration{}).getFieldSources()
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{fieldPhone: fieldSourceBoth, fieldImage: fieldSourceBoth}, sources)
+	})
+
+	t.Run("configured sources", func(t *testing.T) {
+		c := &configuration{FieldSourceOfTruth: `{"phone": "ERPNext", "image": "mattermost"}`}
+		sources, err := c.getFieldSources()
+		require.NoError(t, err)
+		assert.Equal(t, fieldSourceERPNext, sources[fieldPhone])
+		assert.Equal(t, fieldSourceMattermost, sources[fieldImage])
+	})
+
+	t.Run("unknown field and source are reported", func(t *testing.T) {
+		c := &configuration{FieldSourceOfTruth: `{"name": "erpnext", "phone": "hr"}`}
+		sources, err := c.getFieldSources()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unknown field name")
+		assert.Contains(t, err.Error(), "invalid source hr")
+		assert.Equal(t, fieldSourceBoth, sources[fieldPhone])
+	})
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		_, err := (&configuration{FieldSourceOfTruth: `phone=erpnext`}).getFieldSources()
+		assert.Error(t, err)
+	})
+}
+
+func TestWritesFieldGivesEachFieldOneWriter(t *testing.T) {
+	for _, tc := range []struct {
+		source string
+		toERP  bool
+		toMM   bool
+	}{
+		{source: fieldSourceMattermost, toERP: true, toMM: false},
+		{source: fieldSourceERPNext, toERP: false, toMM: true},
+		{source: fieldSourceBoth, toERP: true, toMM: true},
+	} {
+		for _, field := range guardedFields {
+			t.Run(field+" "+tc.source, func(t *testing.T) {
+				c := &configuration{FieldSourceOfTruth: `{"` + field + `": "` + tc.source + `"}`}
+				assert.Equal(t, tc.toERP, c.writesField(field, directionMMToERP))
+				assert.Equal(t, tc.toMM, c.writesField(field, directionERPToMM))
+			})
+		}
+	}
+}
+
+// newPhoneERPNextStub serves employee as the only employee of an ERPNext instance linked to an
+// existing ERPNext user, applying the cell number of every employee update to it
+func newPhoneERPNextStub(t *testing.T, p *Plugin, employee *erpnext.Employee) {
+	newERPNextStub(t, p, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/resource/User":
+			_, _ = w.Write([]byte(`{"data": [{"name": "` + employee.UserID + `", "email": "` + employee.UserID + `"}]}`))
+			return
+		case r.Method == http.MethodPut:
+			var update map[string]interface{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&update))
+			if cellNumber, ok := update["cell_number"].(string); ok {
+				employee.CellNumber = cellNumber
+			}
+		}
+
+		body, err := json.Marshal(employee)
+		require.NoError(t, err)
+		if r.Method == http.MethodPut {
+			_, _ = w.Write([]byte(`{"data": ` + string(body) + `}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data": [` + string(body) + `]}`))
+	})
+}
+
+// phoneSyncRound runs the mm→erp and erp→mm sync once for a mapped user and employee, like
+// back-to-back scheduled syncs would
+func phoneSyncRound(t *testing.T, p *Plugin, user *model.User, employee *erpnext.Employee) {
+	t.Helper()
+
+	res := p.syncUserToERPNext(context.Background(), user, false, false)
+	require.NoError(t, res.Err)
+
+	res = p.syncEmployeeToMattermost(context.Background(), *employee, nil)
+	require.NoError(t, res.Err)
+}
+
+func TestPhoneSyncDirectionsDontFight(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{name: "ERPNext owns the phone number", source: fieldSourceERPNext, expected: "+4915100000002"},
+		{name: "Mattermost owns the phone number", source: fieldSourceMattermost, expected: "+4915100000001"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			api := &plugintest.API{}
+			allowLogs(api)
+			api.On("UpdateUser", mock.AnythingOfType("*model.User")).Return(func(user *model.User) *model.User { return user }, nil).Maybe()
+
+			p := &Plugin{}
+			p.SetAPI(api)
+			p.setConfiguration(&configuration{
+				SyncPhoneNumbers:   true,
+				FieldSourceOfTruth: `{"phone": "` + tc.source + `"}`,
+			})
+
+			user := &model.User{Id: "user1", Username: "jane.doe", Email: "jane@example.com", FirstName: "Jane"}
+			user.SetProp(defaultPhoneNumberAttribute, "+4915100000001")
+			api.On("GetUser", "user1").Return(user, nil)
+			employee := &erpnext.Employee{Name: "HR-EMP-0001", Status: "Active", CompanyEmail: "jane@example.com", UserID: "jane@example.com", CustomChatID: "user1", CellNumber: "+4915100000002"}
+			newPhoneERPNextStub(t, p, employee)
+
+			// The source's value wins and stays put however often both directions run
+			for i := 0; i < 3; i++ {
+				phoneSyncRound(t, p, user, employee)
+				mmPhone, _ := user.GetProp(defaultPhoneNumberAttribute)
+				assert.Equal(t, tc.expected, mmPhone, "round %d", i)
+				assert.Equal(t, tc.expected, employee.CellNumber, "round %d", i)
+			}
+
+			// Only the authoritative direction ever wrote, and only once
+			expectedUpdates := 0
+			if tc.source == fieldSourceERPNext {
+				expectedUpdates = 1
+			}
+			api.AssertNumberOfCalls(t, "UpdateUser", expectedUpdates)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/erpnext"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// syncProfileImageFromERPNext downloads the employee photo from ERPNext and sets it as the
+// Mattermost user's profile image. Returns a note for the sync result, or "" when there was
+// nothing to do. A missing or invalid image never fails the user.
+func (p *Plugin) syncProfileImageFromERPNext(client *erpnext.Client, userID string, employee erpnext.Employee) string {
+	if employee.Image == "" {
+		return ""
+	}
+
+	data, contentType, err := client.DownloadFile(employee.Image)
+	if err != nil {
+		p.API.LogWarn("Failed to download employee image from ERPNext",
+			"employee_id", employee.Name,
+			"image", employee.Image,
+			"error", err.Error())
+		return "profile image skipped: download failed"
+	}
+
+	// ERPNext serves whatever was attached, so check the bytes rather than trusting the header
+	if !strings.HasPrefix(http.DetectContentType(data), "image/") {
+		p.API.LogWarn("Employee image from ERPNext is not an image",
+			"employee_id", employee.Name,
+			"image", employee.Image,
+			"content_type", contentType)
+		return "profile image skipped: not an image"
+	}
+
+	if err := p.client.User.SetProfileImage(userID, bytes.NewReader(data)); err != nil {
+		p.API.LogWarn("Failed to set Mattermost profile image",
+			"user_id", userID,
+			"employee_id", employee.Name,
+			"error", err.Error())
+		return fmt.Sprintf("profile image skipped: %s", err.Error())
+	}
+
+	return "profile image set"
+}
+
+// pushProfileImageToERPNext uploads the Mattermost user's profile image to ERPNext as the
+// employee image, when the employee has no image yet or a different one. Returns a note for the
+// sync result, or "" when there was nothing to do. Failures never fail the user.
+func (p *Plugin) pushProfileImageToERPNext(client *erpnext.Client, user *model.User, employeeName, currentImage string) string {
+	// Users who never uploaded a picture only have the generated default, which isn't worth pushing
+	if user.LastPictureUpdate == 0 {
+		return ""
+	}
+
+	data, appErr := p.API.GetProfileImage(user.Id)
+	if appErr != nil {
+		p.API.LogWarn("Failed to get Mattermost profile image",
+			"user_id", user.Id,
+			"error", appErr.Error())
+		return "profile image not pushed: could not read it"
+	}
+
+	// Only replace an existing ERPNext image when it actually differs
+	if currentImage != "" {
+		existing, _, err := client.DownloadFile(currentImage)
+		if err == nil && bytes.Equal(existing, data) {
+			return ""
+		}
+	}
+
+	filename := fmt.Sprintf("%s%s", user.Id, imageExtension(data))
+	if err := client.UploadEmployeeImage(employeeName, data, filename); err != nil {
+		p.API.LogWarn("Failed to push profile image to ERPNext",
+			"user_id", user.Id,
+			"employee_id", employeeName,
+			"error", err.Error())
+		return "profile image not pushed: upload failed"
+	}
+
+	return "profile image pushed"
+}
+
+// imageExtension returns a file extension matching the image bytes
+func imageExtension(data []byte) string {
+	switch http.DetectContentType(data) {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	default:
+		return ".png"
+	}
+}
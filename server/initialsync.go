@@ -0,0 +1,80 @@
+package main
+
+import (
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/syncresult"
+)
+
+// initialSyncClaimTTL is how long the sync on activation stays claimed. Activations within it,
+// on this node or another, don't start another one.
+const initialSyncClaimTTL = time.Hour
+
+// startInitialSync runs a full sync in the background after activation when
+// InitialSyncOnActivate is on, so admins don't wait for the next scheduled run
+func (p *Plugin) startInitialSync() {
+	if !p.getConfiguration().InitialSyncOnActivate {
+		return
+	}
+
+	go p.runInitialSync()
+}
+
+// runInitialSync checks that ERPNext is reachable, claims the run so only one node performs it,
+// then syncs Mattermost users to ERPNext followed by ERPNext employees to Mattermost. Both runs
+// are recorded in the sync history.
+func (p *Plugin) runInitialSync() {
+	if p.erpNextClient == nil || p.kvstore == nil {
+		p.API.LogInfo("Skipping initial sync, ERPNext is not configured")
+		return
+	}
+
+	// Admins pause automatic syncs during ERPNext maintenance windows
+	if pause, err := p.scheduledSyncPause(); err == nil && pause != nil {
+		p.API.LogInfo("Skipping initial sync, scheduled syncs are paused")
+		return
+	}
+
+	if err := p.erpNextClient.Ping(); err != nil {
+		p.API.LogWarn("Skipping initial sync, ERPNext is not reachable", "error", err.Error())
+		return
+	}
+
+	claimed, err := p.kvstore.ClaimInitialSync(initialSyncClaimTTL)
+	if err != nil {
+		p.API.LogError("Failed to claim initial sync", "error", err.Error())
+		return
+	}
+	if !claimed {
+		p.API.LogInfo("Initial sync already ran or is running elsewhere, skipping")
+		return
+	}
+
+	p.API.LogInfo("Starting initial sync after activation")
+
+	startTime := time.Now()
+	userCtx, cancelUsers := p.syncContext(startTime, userSyncMaxDuration)
+	defer cancelUsers()
+	users, truncated, err := p.loadUsersForSync()
+	if err != nil {
+		p.API.LogError("Initial sync failed to load Mattermost users", "error", err.Error())
+		return
+	}
+	userResult := syncresult.New(directionMMToERP)
+	if truncated {
+		userResult.MarkTruncated()
+	}
+	p.runUserSync(userCtx, "", startTime, users, userResult, false)
+
+	startTime = time.Now()
+	ctx, cancel := p.syncContext(startTime, employeeSyncMaxDuration)
+	defer cancel()
+	employees, err := p.loadEmployeesForSync(ctx)
+	if err != nil {
+		p.API.LogError("Initial sync failed to load ERPNext employees", "error", err.Error())
+		return
+	}
+	p.runEmployeeSync(ctx, "", startTime, employees, syncresult.New(directionERPToMM))
+
+	p.API.LogInfo("Initial sync finished")
+}
@@ -1,6 +1,49 @@
 package main
 
+import (
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
 func (p *Plugin) runJob() {
 	// Include job logic here
 	p.API.LogInfo("Job is currently running")
+
+	// Admins pause the job during ERPNext maintenance windows
+	pause, err := p.scheduledSyncPause()
+	if err != nil {
+		p.API.LogError("Failed to check whether scheduled syncs are paused", "error", err.Error())
+	} else if pause != nil {
+		p.API.LogInfo("Scheduled sync is paused, skipping this run", "paused_by", pause.PausedBy, "resume_at", pause.ResumeAt)
+		return
+	}
+
+	// Admins choose at runtime what the job syncs, with /erpsync syncdirection
+	direction, err := p.scheduledSyncDirection()
+	if err != nil {
+		p.API.LogError("Failed to read the scheduled sync direction, not syncing", "error", err.Error())
+	}
+	p.runScheduledSync(direction)
+
+	p.resendQueuedCredentialEmails()
+	p.cleanupKVStore()
+}
+
+// cleanupKVStore prunes stored sync data older than the configured retention period
+func (p *Plugin) cleanupKVStore() {
+	retentionDays := p.getConfiguration().KVRetentionDays
+	if retentionDays <= 0 || p.kvstore == nil {
+		return
+	}
+
+	cutoff := model.GetMillis() - (time.Duration(retentionDays) * 24 * time.Hour).Milliseconds()
+	removed, err := p.kvstore.Cleanup(cutoff)
+	if err != nil {
+		p.API.LogError("Failed to clean up stale KV data", "error", err.Error())
+		return
+	}
+	if removed > 0 {
+		p.API.LogInfo("Cleaned up stale KV data", "removed", removed, "retention_days", retentionDays)
+	}
 }
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// Sources of the date of joining of created employees, selected by the DateOfJoiningSource setting
+const (
+	dateOfJoiningFixed          = "fixed"
+	dateOfJoiningAccountCreated = "account_created"
+)
+
+// fixedDateOfJoining is the date of joining used when nothing better is known
+const fixedDateOfJoining = "2000-01-01"
+
+// getDateOfJoiningSource returns the configured date of joining source, defaulting to the fixed date
+func (c *configuration) getDateOfJoiningSource() string {
+	if c.DateOfJoiningSource == dateOfJoiningAccountCreated {
+		return dateOfJoiningAccountCreated
+	}
+	return dateOfJoiningFixed
+}
+
+// dateOfJoiningForUser returns the date_of_joining for an employee created from the user, in
+// ERPNext's YYYY-MM-DD form
+func (c *configuration) dateOfJoiningForUser(user *model.User) string {
+	if c.getDateOfJoiningSource() == dateOfJoiningAccountCreated && user.CreateAt > 0 {
+		return time.UnixMilli(user.CreateAt).UTC().Format("2006-01-02")
+	}
+	return fixedDateOfJoining
+}
+
+// dateOfJoiningSchemaSource describes the date of joining source in the sync schema
+func (c *configuration) dateOfJoiningSchemaSource() string {
+	if c.getDateOfJoiningSource() == dateOfJoiningAccountCreated {
+		return "create_at"
+	}
+	return "(fixed) " + fixedDateOfJoining
+}
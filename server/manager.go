@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/erpnext"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/syncresult"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// resolveEmployeeUser returns the active Mattermost user an employee is mapped to: the user its
+// chat ID points at, else the user with its company email, which covers employees mapped or
+// created earlier in the same run. Returns nil when the employee has no Mattermost user yet.
+func (p *Plugin) resolveEmployeeUser(employee erpnext.Employee) *model.User {
+	if employee.CustomChatID != "" {
+		if user, appErr := p.API.GetUser(employee.CustomChatID); appErr == nil && user != nil && user.DeleteAt == 0 {
+			return user
+		}
+	}
+
+	email, _ := normalizeEmployeeEmail(employee.CompanyEmail)
+	if email == "" {
+		return nil
+	}
+	if user, appErr := p.API.GetUserByEmail(email); appErr == nil && user != nil && user.DeleteAt == 0 {
+		return user
+	}
+	return nil
+}
+
+// syncManagerAttributes sets the username of each active employee's manager, from reports_to,
+// as the ManagerAttribute prop of the employee's Mattermost user. It runs once every employee
+// was processed so managers mapped later in the same run are found. Users whose manager has no
+// Mattermost account yet keep their current value until a later run can resolve it.
+func (p *Plugin) syncManagerAttributes(employees []erpnext.Employee, result *syncresult.Result) {
+	config := p.getConfiguration()
+	attribute := config.getManagerAttribute()
+
+	byName := make(map[string]erpnext.Employee, len(employees))
+	for _, employee := range employees {
+		byName[employee.Name] = employee
+	}
+
+	// Managers usually have several reports, so look each one up once
+	resolved := map[string]*model.User{}
+	resolve := func(employee erpnext.Employee) *model.User {
+		if user, seen := resolved[employee.Name]; seen {
+			return user
+		}
+		user := p.resolveEmployeeUser(employee)
+		resolved[employee.Name] = user
+		return user
+	}
+
+	updated, deferred, failed := 0, 0, 0
+	for _, employee := range employees {
+		managerID := strings.TrimSpace(employee.ReportsTo)
+		if managerID == "" || employee.Status != "Active" {
+			continue
+		}
+
+		user := resolve(employee)
+		if user == nil {
+			continue
+		}
+
+		manager, known := byName[managerID]
+		var managerUser *model.User
+		if known {
+			managerUser = resolve(manager)
+		}
+		if managerUser == nil {
+			p.API.LogDebug("Manager has no Mattermost user yet, leaving manager attribute for a later run",
+				"employee_id", employee.Name,
+				"reports_to", managerID)
+			deferred++
+			continue
+		}
+
+		current, _ := user.GetProp(attribute)
+		if current == managerUser.Username {
+			continue
+		}
+
+		// A manager may be added but never replaced in additive only mode
+		if config.AdditiveOnly && current != "" {
+			continue
+		}
+
+		user.SetProp(attribute, managerUser.Username)
+		if _, appErr := p.API.UpdateUser(user); appErr != nil {
+			p.API.LogError("Failed to update Mattermost user manager",
+				"user_id", user.Id,
+				"manager", managerUser.Username,
+				"error", appErr.Error())
+			failed++
+			continue
+		}
+		updated++
+	}
+
+	if updated > 0 || deferred > 0 || failed > 0 {
+		result.RecordNote(fmt.Sprintf("MANAGERS: Set the manager of %d users, %d deferred until their manager is synced, %d failed", updated, deferred, failed))
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/erpnext"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/syncresult"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSyncManagerAttributes(t *testing.T) {
+	api := &plugintest.API{}
+	allowLogs(api)
+
+	manager := &model.User{Id: "manager-id", Username: "mary.manager"}
+	report := &model.User{Id: "report-id", Username: "rick.report"}
+	orphan := &model.User{Id: "orphan-id", Username: "otto.orphan"}
+	api.On("GetUser", "manager-id").Return(manager, nil)
+	api.On("GetUser", "report-id").Return(report, nil)
+	api.On("GetUser", "orphan-id").Return(orphan, nil)
+	api.On("GetUserByEmail", "new.boss@example.com").Return(nil, model.NewAppError("GetUserByEmail", "not_found", nil, "", 404))
+	api.On("UpdateUser", mock.MatchedBy(func(user *model.User) bool {
+		return user.Id == "report-id" && user.Props["manager"] == "mary.manager"
+	})).Return(report, nil).Once()
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{SyncManager: true})
+
+	employees := []erpnext.Employee{
+		{Name: "HR-EMP-1", Status: "Active", CustomChatID: "manager-id"},
+		{Name: "HR-EMP-2", Status: "Active", CustomChatID: "report-id", ReportsTo: "HR-EMP-1"},
+		{Name: "HR-EMP-3", Status: "Active", CustomChatID: "orphan-id", ReportsTo: "HR-EMP-4"},
+		{Name: "HR-EMP-4", Status: "Active", CompanyEmail: "new.boss@example.com"},
+	}
+	result := syncresult.New(directionERPToMM)
+	p.syncManagerAttributes(employees, result)
+
+	api.AssertExpectations(t)
+	if assert.Len(t, result.Entries, 1) {
+		assert.Equal(t, "MANAGERS: Set the manager of 1 users, 1 deferred until their manager is synced, 0 failed", result.Entries[0].Message)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/erpnext"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// skipReasonAmbiguousName is the skip reason for employees whose name matches several
+// Mattermost users, left for an admin to map by hand
+const skipReasonAmbiguousName = "Ambiguous Name Match"
+
+// normalizedFullName lowercases a first and last name, strips accents and collapses whitespace so
+// "Nguyễn  Văn An" and "nguyen van an" compare equal
+func (p *Plugin) normalizedFullName(firstName, lastName string) string {
+	fullName := strings.ToLower(firstName + " " + lastName)
+	return strings.Join(strings.Fields(p.removeAccents(fullName)), " ")
+}
+
+// findUsersByName returns the active Mattermost users whose accent-normalized full name exactly
+// matches the employee's. Only a single result is a confident match.
+func (p *Plugin) findUsersByName(firstName, lastName string) ([]*model.User, error) {
+	target := p.normalizedFullName(firstName, lastName)
+	if target == "" {
+		return nil, nil
+	}
+
+	// Search with the name as stored in ERPNext and without accents, since either side may
+	// have been entered without them
+	terms := []string{strings.TrimSpace(firstName + " " + lastName)}
+	if target != strings.ToLower(terms[0]) {
+		terms = append(terms, target)
+	}
+
+	seen := map[string]bool{}
+	var matches []*model.User
+	for _, term := range terms {
+		users, appErr := p.API.SearchUsers(&model.UserSearch{
+			Term:          term,
+			AllowInactive: false,
+			Limit:         100,
+		})
+		if appErr != nil {
+			return nil, appErr
+		}
+
+		for _, user := range users {
+			if seen[user.Id] || user.IsBot || user.DeleteAt != 0 {
+				continue
+			}
+			seen[user.Id] = true
+			if p.normalizedFullName(user.FirstName, user.LastName) == target {
+				matches = append(matches, user)
+			}
+		}
+	}
+
+	return matches, nil
+}
+
+// excludeUsersMappedElsewhere drops the name matches already mapped to an employee other than
+// employeeName, so two employees sharing a name never end up on the same account
+func (p *Plugin) excludeUsersMappedElsewhere(client *erpnext.Client, employeeName string, candidates []*model.User) ([]*model.User, error) {
+	var unmapped []*model.User
+	for _, candidate := range candidates {
+		mapped, err := client.GetEmployeeByChatID(candidate.Id)
+		if err != nil {
+			return nil, err
+		}
+		if mapped != nil && mapped.Name != employeeName {
+			p.API.LogDebug("Name match is already mapped to another employee",
+				"employee_id", employeeName,
+				"user_id", candidate.Id,
+				"mapped_employee_id", mapped.Name)
+			continue
+		}
+		unmapped = append(unmapped, candidate)
+	}
+	return unmapped, nil
+}
+
+// localFullName joins name parts the way we'd display them, for comparing with ERPNext's
+// composed employee_name
+func localFullName(firstName, lastName string) string {
+	return strings.TrimSpace(strings.TrimSpace(firstName) + " " + strings.TrimSpace(lastName))
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/erpnext"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// Sync order strategies, selected by the SyncOrder setting
+const (
+	syncOrderNone        = "none"
+	syncOrderEmail       = "email"
+	syncOrderAdminsFirst = "admins_first"
+)
+
+// getSyncOrder returns the configured sync order strategy, defaulting to no reordering
+func (c *configuration) getSyncOrder() string {
+	switch c.SyncOrder {
+	case syncOrderEmail, syncOrderAdminsFirst:
+		return c.SyncOrder
+	default:
+		return syncOrderNone
+	}
+}
+
+// orderUsersForSync sorts Mattermost users in place according to the configured strategy, so a
+// timeout doesn't strand important accounts at the end of the list
+func (p *Plugin) orderUsersForSync(users []*model.User) {
+	strategy := p.getConfiguration().getSyncOrder()
+	if strategy == syncOrderNone {
+		return
+	}
+
+	sort.SliceStable(users, func(i, j int) bool {
+		if strategy == syncOrderAdminsFirst {
+			if iAdmin, jAdmin := users[i].IsSystemAdmin(), users[j].IsSystemAdmin(); iAdmin != jAdmin {
+				return iAdmin
+			}
+		}
+		return strings.ToLower(users[i].Email) < strings.ToLower(users[j].Email)
+	})
+}
+
+// orderEmployeesForSync sorts ERPNext employees in place according to the configured strategy.
+// Admins are the employees whose company email belongs to a Mattermost system admin.
+func (p *Plugin) orderEmployeesForSync(employees []erpnext.Employee) {
+	strategy := p.getConfiguration().getSyncOrder()
+	if strategy == syncOrderNone {
+		return
+	}
+
+	adminEmails := map[string]bool{}
+	if strategy == syncOrderAdminsFirst {
+		const perPage = 200
+		for page := 0; ; page++ {
+			admins, appErr := p.API.GetUsers(&model.UserGetOptions{
+				Role:    model.SystemAdminRoleId,
+				Page:    page,
+				PerPage: perPage,
+			})
+			if appErr != nil {
+				// Still sort by email, the remaining admins just aren't moved to the front
+				p.API.LogWarn("Failed to fetch system admins for sync ordering", "error", appErr.Error())
+				break
+			}
+			for _, admin := range admins {
+				adminEmails[strings.ToLower(admin.Email)] = true
+			}
+			if len(admins) < perPage {
+				break
+			}
+		}
+	}
+
+	sort.SliceStable(employees, func(i, j int) bool {
+		iEmail, jEmail := strings.ToLower(employees[i].CompanyEmail), strings.ToLower(employees[j].CompanyEmail)
+		if iAdmin, jAdmin := adminEmails[iEmail], adminEmails[jEmail]; iAdmin != jAdmin {
+			return iAdmin
+		}
+		return iEmail < jEmail
+	})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/syncresult"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// pauseScheduledSyncs pauses the scheduled sync job. A positive duration overrides the
+// configured auto-resume window; with neither, the pause lasts until resumeScheduledSyncs.
+func (p *Plugin) pauseScheduledSyncs(userID string, duration time.Duration) (*kvstore.SyncPause, error) {
+	if p.kvstore == nil {
+		return nil, errors.New("KV store is not available")
+	}
+
+	if duration <= 0 {
+		duration = p.getConfiguration().getSyncPauseAutoResume()
+	}
+
+	now := time.Now()
+	pause := &kvstore.SyncPause{
+		PausedBy: userID,
+		PausedAt: now.UnixMilli(),
+	}
+	if duration > 0 {
+		pause.ResumeAt = now.Add(duration).UnixMilli()
+	}
+
+	if err := p.kvstore.SetSyncPause(pause); err != nil {
+		return nil, err
+	}
+	p.API.LogInfo("Scheduled sync paused", "user_id", userID, "resume_at", pause.ResumeAt)
+	return pause, nil
+}
+
+// resumeScheduledSyncs lifts any pause of the scheduled sync job
+func (p *Plugin) resumeScheduledSyncs(userID string) error {
+	if p.kvstore == nil {
+		return errors.New("KV store is not available")
+	}
+	if err := p.kvstore.SetSyncPause(nil); err != nil {
+		return err
+	}
+	p.API.LogInfo("Scheduled sync resumed", "user_id", userID)
+	return nil
+}
+
+// scheduledSyncPause returns the active pause, or nil when the scheduled sync may run. A pause
+// whose auto-resume time has passed is cleared.
+func (p *Plugin) scheduledSyncPause() (*kvstore.SyncPause, error) {
+	if p.kvstore == nil {
+		return nil, nil
+	}
+
+	pause, err := p.kvstore.GetSyncPause()
+	if err != nil || pause == nil {
+		return nil, err
+	}
+
+	if pause.ResumeAt > 0 && model.GetMillis() >= pause.ResumeAt {
+		if err := p.kvstore.SetSyncPause(nil); err != nil {
+			return nil, err
+		}
+		p.API.LogInfo("Scheduled sync pause expired, resuming")
+		return nil, nil
+	}
+	return pause, nil
+}
+
+// describeSyncPause renders the pause state for admins
+func describeSyncPause(pause *kvstore.SyncPause) string {
+	if pause == nil {
+		return "Scheduled syncs are running."
+	}
+	if pause.ResumeAt == 0 {
+		return "Scheduled syncs are paused until an admin resumes them."
+	}
+	return fmt.Sprintf("Scheduled syncs are paused until %s.", time.UnixMilli(pause.ResumeAt).UTC().Format("2006-01-02 15:04 MST"))
+}
+
+// PauseSyncs pauses the scheduled sync job. An optional "minutes" query parameter sets how long
+// the pause lasts instead of the configured auto-resume window.
+func (p *Plugin) PauseSyncs(w http.ResponseWriter, r *http.Request) {
+	var duration time.Duration
+	if value := r.URL.Query().Get("minutes"); value != "" {
+		minutes, err := strconv.Atoi(value)
+		if err != nil || minutes <= 0 {
+			http.Error(w, "minutes must be a positive number", http.StatusBadRequest)
+			return
+		}
+		duration = time.Duration(minutes) * time.Minute
+	}
+
+	pause, err := p.pauseScheduledSyncs(r.Header.Get("Mattermost-User-ID"), duration)
+	if err != nil {
+		p.API.LogError("Failed to pause scheduled syncs", "error", err.Error())
+		http.Error(w, fmt.Sprintf("Failed to pause scheduled syncs: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	p.writeSyncPause(w, pause)
+}
+
+// ResumeSyncs lifts a pause of the scheduled sync job
+func (p *Plugin) ResumeSyncs(w http.ResponseWriter, r *http.Request) {
+	if err := p.resumeScheduledSyncs(r.Header.Get("Mattermost-User-ID")); err != nil {
+		p.API.LogError("Failed to resume scheduled syncs", "error", err.Error())
+		http.Error(w, fmt.Sprintf("Failed to resume scheduled syncs: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	p.writeSyncPause(w, nil)
+}
+
+// writeSyncPause writes the pause state as JSON
+func (p *Plugin) writeSyncPause(w http.ResponseWriter, pause *kvstore.SyncPause) {
+	response := struct {
+		SchemaVersion int                `json:"schema_version"`
+		Paused        bool               `json:"paused"`
+		Pause         *kvstore.SyncPause `json:"pause,omitempty"`
+	}{
+		SchemaVersion: syncresult.SchemaVersion,
+		Paused:        pause != nil,
+		Pause:         pause,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogError("Failed to encode response", "error", err)
+	}
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"fmt"
 	"math/rand"
+	"net/http"
+	"path"
 	"regexp"
 	"strings"
 	"sync"
@@ -10,6 +14,7 @@ import (
 
 	"github.com/mattermost/mattermost-plugin-starter-template/server/erpnext"
 	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
 	"github.com/mattermost/mattermost/server/public/pluginapi"
 	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
@@ -27,10 +32,23 @@ type Plugin struct {
 	client *pluginapi.Client
 
 	// erpNextClient is the client used to interact with ERPNext API.
+	// With several instances configured it is the first one.
 	erpNextClient *erpnext.Client
 
+	// erpNextClients holds a client for every configured ERPNext instance, keyed by instance name.
+	erpNextClients map[string]*erpnext.Client
+
+	// erpNextInstances is the parsed instance list, in routing order.
+	erpNextInstances []erpNextInstance
+
 	backgroundJob *cluster.Job
 
+	// syncs tracks the running syncs, which stop and are waited for on deactivation
+	syncs *syncTracker
+
+	// botUserID is the user ID of the plugin's bot account. Automated posts go through postAsBot.
+	botUserID string
+
 	// configurationLock synchronizes access to the configuration.
 	configurationLock sync.RWMutex
 
@@ -44,21 +62,28 @@ func (p *Plugin) OnActivate() error {
 	// Initialize the Mattermost API client
 	p.client = pluginapi.NewClient(p.API, p.Driver)
 
+	// Syncs started from here on are stopped and waited for on deactivation
+	p.syncs = newSyncTracker()
+
 	// Initialize the KV store client
 	p.kvstore = kvstore.NewKVStore(p.client)
 
-	// Initialize the ERPNext client based on configuration
-	config := p.getConfiguration()
-	if config.ERPNextURL != "" && config.ERPNextAPIKey != "" && config.ERPNextAPISecret != "" {
-		p.erpNextClient = erpnext.NewClient(
-			config.ERPNextURL,
-			config.ERPNextAPIKey,
-			config.ERPNextAPISecret,
-		)
-	} else {
+	// Initialize the ERPNext clients based on configuration
+	p.initERPNextClients(p.getConfiguration())
+	if p.erpNextClient == nil {
 		p.API.LogInfo("ERPNext client not initialized: configuration missing. This is expected on first startup.")
 	}
 
+	// Ensure the bot account all automated posts are made as
+	if err := p.ensureBot(); err != nil {
+		return err
+	}
+
+	// Register the /erpsync slash command
+	if err := p.registerCommands(); err != nil {
+		return err
+	}
+
 	// Schedule the background job
 	job, err := cluster.Schedule(
 		p.API,
@@ -72,6 +97,9 @@ func (p *Plugin) OnActivate() error {
 
 	p.backgroundJob = job
 
+	// Reconcile right away instead of waiting for the first scheduled run
+	p.startInitialSync()
+
 	return nil
 }
 
@@ -86,28 +114,113 @@ func (p *Plugin) OnConfigurationChange() error {
 
 	p.setConfiguration(configuration)
 
-	// Update the ERPNext client when configuration changes
-	if configuration.ERPNextURL != "" && configuration.ERPNextAPIKey != "" && configuration.ERPNextAPISecret != "" {
-		p.erpNextClient = erpnext.NewClient(
-			configuration.ERPNextURL,
-			configuration.ERPNextAPIKey,
-			configuration.ERPNextAPISecret,
-		)
-	} else {
-		p.API.LogInfo("ERPNext client not initialized: configuration missing")
-		p.erpNextClient = nil
+	// Update the ERPNext clients when configuration changes
+	p.initERPNextClients(configuration)
+	if p.erpNextClient == nil {
+		p.API.LogInfo("ERPNext client not initialized: configuration missing or invalid")
+	}
+
+	// Surface every invalid setting now instead of on the first sync that uses it
+	var validationErr *configValidationError
+	if errors.As(configuration.IsValid(), &validationErr) {
+		for _, problem := range validationErr.Problems {
+			if problem.Critical {
+				p.API.LogError("Invalid plugin configuration, ERPNext clients were not created", "problem", problem.Message)
+			} else {
+				p.API.LogWarn("Invalid plugin configuration", "problem", problem.Message)
+			}
+		}
 	}
 
 	return nil
 }
 
-// OnDeactivate is invoked when the plugin is deactivated.
+// initERPNextClients builds a client for every configured ERPNext instance. The first instance
+// becomes the default client used wherever no routing by email applies. No clients are built
+// when the configuration has critical problems.
+func (p *Plugin) initERPNextClients(config *configuration) {
+	// Never connect with a half-configured or malformed default instance
+	var validationErr *configValidationError
+	if errors.As(config.IsValid(), &validationErr) && validationErr.Critical() {
+		p.erpNextInstances = nil
+		p.erpNextClients = nil
+		p.erpNextClient = nil
+		return
+	}
+
+	instances, err := config.getERPNextInstances()
+	if err != nil {
+		// Keep whatever instances parsed correctly so a typo doesn't take down the default setup
+		p.API.LogError("Invalid ERPNext instances configuration", "error", err.Error())
+	}
+
+	transport := config.getTransportSettings()
+	readPolicy, writePolicy := config.getRequestPolicies()
+	clients := make(map[string]*erpnext.Client, len(instances))
+	var defaultClient *erpnext.Client
+	for _, instance := range instances {
+		client := erpnext.NewClientWithTransport(instance.URL, instance.Key, instance.Secret, transport)
+		client.ChatIDField = config.getERPNextChatIDField()
+		client.ReadPolicy = readPolicy
+		client.WritePolicy = writePolicy
+		client.UseCSRF = config.ERPNextUseCSRF
+		client.RateLimitPacing = config.getRateLimitPacing()
+		client.StatusField = strings.TrimSpace(config.OnboardedStatusField)
+		client.ResponseShape = config.getERPNextResponseShape()
+		client.RequestLimiter = erpnext.NewRequestLimiter(config.ERPNextMaxConcurrentRequests)
+		clients[instance.Name] = client
+		if defaultClient == nil {
+			defaultClient = client
+		}
+	}
+
+	p.erpNextInstances = instances
+	p.erpNextClients = clients
+	p.erpNextClient = defaultClient
+}
+
+// erpNextClientForEmail selects the ERPNext client for an email address: the first instance whose
+// domain pattern matches the email's domain, otherwise the first instance without a pattern.
+// Returns nil when no instance accepts the email.
+func (p *Plugin) erpNextClientForEmail(email string) *erpnext.Client {
+	if len(p.erpNextInstances) <= 1 {
+		return p.erpNextClient
+	}
+
+	domain := ""
+	if at := strings.LastIndex(email, "@"); at >= 0 {
+		domain = strings.ToLower(email[at+1:])
+	}
+
+	var fallback *erpnext.Client
+	for _, instance := range p.erpNextInstances {
+		pattern := strings.ToLower(strings.TrimSpace(instance.DomainPattern))
+		if pattern == "" {
+			if fallback == nil {
+				fallback = p.erpNextClients[instance.Name]
+			}
+			continue
+		}
+		if matched, _ := path.Match(pattern, domain); matched {
+			return p.erpNextClients[instance.Name]
+		}
+	}
+
+	return fallback
+}
+
+// OnDeactivate is invoked when the plugin is deactivated. Running syncs are asked to stop and
+// given a short time to do so, so they don't keep writing while the plugin is torn down.
 func (p *Plugin) OnDeactivate() error {
 	if p.backgroundJob != nil {
 		if err := p.backgroundJob.Close(); err != nil {
 			p.API.LogError("Failed to close background job", "err", err)
 		}
 	}
+
+	if !p.syncs.shutdown(syncDrainTimeout) {
+		p.API.LogWarn("Syncs still running after waiting for them to stop, deactivating anyway", "timeout", syncDrainTimeout.String())
+	}
 	return nil
 }
 
@@ -115,6 +228,12 @@ func (p *Plugin) OnDeactivate() error {
 // It removes special characters and spaces, converts to lowercase,
 // and transforms Vietnamese and other accented characters to ASCII equivalents
 func (p *Plugin) GenerateUsername(firstName, lastName string) string {
+	return p.generateUsername(firstName, lastName, "")
+}
+
+// generateUsername is GenerateUsername with the random fallbacks derived from seed instead, so
+// the same seed always yields the same username. An empty seed keeps the fallbacks random.
+func (p *Plugin) generateUsername(firstName, lastName, seed string) string {
 	// Combine first and last name
 	fullName := firstName
 	if lastName != "" {
@@ -140,12 +259,12 @@ func (p *Plugin) GenerateUsername(firstName, lastName string) string {
 
 	// If username is empty, generate a random one
 	if username == "" {
-		username = "user_" + p.randomString(6)
+		username = "user_" + p.seededString(seed, "empty", 6)
 	}
 
 	// Ensure username is at least 3 characters
 	for len(username) < 3 {
-		username += "_" + p.randomString(3)
+		username += "_" + p.seededString(seed, username, 3)
 	}
 
 	// Limit username length to 22 characters (Mattermost limit is 64, but keeping it shorter)
@@ -208,6 +327,24 @@ func (p *Plugin) randomString(length int) string {
 	return string(b)
 }
 
+// seededString returns a string of length characters from the randomString charset derived
+// from seed and salt, or a random one when seed is empty. length must not exceed 32.
+func (p *Plugin) seededString(seed, salt string, length int) string {
+	if seed == "" {
+		return p.randomString(length)
+	}
+
+	const charset = "abcdefghijklmnopqrstuvwxyz0123456789"
+	sum := sha256.Sum256([]byte(seed + "|" + salt))
+
+	b := make([]byte, length)
+	for i := range b {
+		b[i] = charset[int(sum[i])%len(charset)]
+	}
+
+	return string(b)
+}
+
 // GenerateRandomPassword creates a random password with the specified length
 // including uppercase, lowercase, numbers, and special characters
 func (p *Plugin) GenerateRandomPassword(length int) string {
@@ -244,20 +381,16 @@ func (p *Plugin) GenerateRandomPassword(length int) string {
 	return string(password)
 }
 
-// SendCredentialEmail attempts to send an email to the user with their login credentials
-// Returns true if the email was successfully sent, false otherwise
-func (p *Plugin) SendCredentialEmail(email, username, password string) bool {
-	// Get site URL from config
-	config := p.API.GetConfig()
-	if config.ServiceSettings.SiteURL == nil || *config.ServiceSettings.SiteURL == "" {
-		p.API.LogError("Failed to get site URL from config")
-		return false
-	}
-	siteURL := *config.ServiceSettings.SiteURL
+// errEmailSuppressed is returned by SendCredentialEmail for addresses CredentialEmailAllowlist
+// doesn't allow. Nothing was sent, and retrying won't change that.
+var errEmailSuppressed = errors.New("email suppressed by allowlist")
 
-	// Format email body
-	subject := "Your Mattermost Account"
-	bodyTemplate := `
+// SendCredentialEmail attempts to send an email to the user with their login credentials.
+// Returns the error when the email couldn't be sent, e.g. because the mail provider throttled
+// it, or errEmailSuppressed when the allowlist doesn't include the address.
+func (p *Plugin) SendCredentialEmail(email, username, password string) error {
+	return p.sendAccountEmail(email, func(siteURL string) string {
+		return fmt.Sprintf(`
 Hello,
 
 An account has been created for you on Mattermost. Here are your login details:
@@ -269,17 +402,169 @@ Password: %s
 Please log in and change your password at your earliest convenience.
 
 This is an automated message.
-`
-	body := fmt.Sprintf(bodyTemplate, siteURL, username, password)
+`, siteURL, username, password)
+	})
+}
+
+// sendPasswordResetEmail resends the account email of a created user whose credential email
+// failed. The generated password isn't kept, so the user sets their own through the password
+// reset page. Errors are those of SendCredentialEmail.
+func (p *Plugin) sendPasswordResetEmail(email, username string) error {
+	return p.sendAccountEmail(email, func(siteURL string) string {
+		return fmt.Sprintf(`
+Hello,
+
+An account has been created for you on Mattermost:
+
+Site: %s
+Username: %s
+
+To sign in for the first time, set your password at %s/reset_password using this email address.
+
+This is an automated message.
+`, siteURL, username, strings.TrimSuffix(siteURL, "/"))
+	})
+}
+
+// sendAccountEmail sends an account email whose body is built from the site URL, unless the
+// allowlist suppresses the address
+func (p *Plugin) sendAccountEmail(email string, body func(siteURL string) string) error {
+	// Staging instances only email the addresses they are allowed to
+	if !p.getConfiguration().isCredentialEmailAllowed(email) {
+		p.API.LogInfo("Credential email suppressed by allowlist", "email", email)
+		return errEmailSuppressed
+	}
+
+	// Get site URL from config
+	config := p.API.GetConfig()
+	if config.ServiceSettings.SiteURL == nil || *config.ServiceSettings.SiteURL == "" {
+		p.API.LogError("Failed to get site URL from config")
+		return errors.New("site URL is not configured")
+	}
+
+	subject := "Your Mattermost Account"
 
 	// Send email
-	err := p.API.SendMail(email, subject, body)
+	if appErr := p.API.SendMail(email, subject, body(*config.ServiceSettings.SiteURL)); appErr != nil {
+		p.API.LogError("Failed to send credential email", "email", email, "error", appErr.Error())
+		return appErr
+	}
 
-	if err != nil {
-		p.API.LogError("Failed to send credential email", "email", email, "error", err.Error())
+	p.API.LogInfo("Credential email sent successfully", "email", email)
+	return nil
+}
+
+// SendWelcomeMessage sends the configured welcome message to a newly created user as a DM from
+// the plugin bot. {{username}} and {{first_name}} in the message are replaced with the user's values.
+// Returns true if the message was posted, false otherwise
+func (p *Plugin) SendWelcomeMessage(user *model.User) bool {
+	message := strings.NewReplacer(
+		"{{username}}", user.Username,
+		"{{first_name}}", user.FirstName,
+	).Replace(p.getConfiguration().WelcomeMessage)
+	if strings.TrimSpace(message) == "" {
 		return false
 	}
 
-	p.API.LogInfo("Credential email sent successfully", "email", email)
+	if err := p.dmAsBot(user.Id, message); err != nil {
+		p.API.LogError("Failed to send welcome message", "user_id", user.Id, "error", err.Error())
+		return false
+	}
+
+	p.API.LogInfo("Welcome message sent successfully", "user_id", user.Id)
 	return true
 }
+
+// createUserRetryBaseDelay is the initial backoff between transient CreateUser retries.
+// Each subsequent retry doubles the delay.
+const createUserRetryBaseDelay = 500 * time.Millisecond
+
+// isTransientAppError reports whether a Mattermost AppError looks like a temporary
+// server-side problem (DB contention, timeouts, throttling) worth retrying, as opposed
+// to a permanent validation error in the submitted data.
+func isTransientAppError(appErr *model.AppError) bool {
+	if appErr == nil {
+		return false
+	}
+
+	switch appErr.StatusCode {
+	case 0, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+
+	return appErr.StatusCode >= http.StatusInternalServerError
+}
+
+// createUserWithRetry creates a Mattermost user, retrying with exponential backoff when
+// the server returns a transient error. Validation errors (4xx) are returned immediately.
+// Retries draw from the retry budget of the run's ctx, and no attempt is made once ctx is done,
+// e.g. because the record timed out.
+// It returns the created user, the number of retries that were needed and the final error.
+func (p *Plugin) createUserWithRetry(ctx context.Context, user *model.User) (*model.User, int, *model.AppError) {
+	maxRetries := p.getConfiguration().getCreateUserMaxRetries()
+	delay := createUserRetryBaseDelay
+
+	retries := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, retries, model.NewAppError("createUserWithRetry", "plugin.erp_sync.create_user.stopped", nil, "sync stopped before the user was created: "+err.Error(), http.StatusServiceUnavailable)
+		}
+
+		createdUser, appErr := p.API.CreateUser(user)
+		if appErr == nil {
+			return createdUser, retries, nil
+		}
+
+		if !isTransientAppError(appErr) || retries >= maxRetries || !erpnext.RetryBudgetFromContext(ctx).Take(delay) {
+			return nil, retries, appErr
+		}
+
+		retries++
+		p.API.LogWarn("Transient error creating Mattermost user, retrying",
+			"email", user.Email,
+			"username", user.Username,
+			"status_code", appErr.StatusCode,
+			"attempt", retries,
+			"max_retries", maxRetries,
+			"error", appErr.Error())
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+		delay *= 2
+	}
+}
+
+// Preference category and names used to mark Mattermost users provisioned by the plugin
+const (
+	preferenceCategoryERPSync      = "erp_sync"
+	preferenceNameCreated          = "created"
+	preferenceNameSourceEmployeeID = "source_employee_id"
+)
+
+// tagCreatedUser records on a Mattermost user, via preferences, that the plugin created the
+// account and from which ERPNext employee. Failures are logged but never fail the sync.
+func (p *Plugin) tagCreatedUser(userID, employeeID string) {
+	preferences := model.Preferences{
+		{
+			UserId:   userID,
+			Category: preferenceCategoryERPSync,
+			Name:     preferenceNameCreated,
+			Value:    "true",
+		},
+		{
+			UserId:   userID,
+			Category: preferenceCategoryERPSync,
+			Name:     preferenceNameSourceEmployeeID,
+			Value:    employeeID,
+		},
+	}
+
+	if appErr := p.API.UpdatePreferencesForUser(userID, preferences); appErr != nil {
+		p.API.LogWarn("Failed to tag user as created by ERPNext sync",
+			"user_id", userID,
+			"employee_id", employeeID,
+			"error", appErr.Error())
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/syncresult"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// websocketEventSyncProgress is published to the admin running a sync; clients receive it as
+// custom_<plugin id>_sync_progress
+const websocketEventSyncProgress = "sync_progress"
+
+// progressReporter publishes throttled sync progress over WebSocket to the admin who started the
+// sync. It is safe for concurrent use.
+type progressReporter struct {
+	p         *Plugin
+	userID    string
+	direction string
+	total     int
+	interval  int
+
+	mu        sync.Mutex
+	processed int
+}
+
+// newProgressReporter returns a reporter for a sync of total records. Nothing is published when
+// userID is empty (e.g. token-authenticated automation) or the interval is 0.
+func (p *Plugin) newProgressReporter(userID, direction string, total int) *progressReporter {
+	return &progressReporter{
+		p:         p,
+		userID:    userID,
+		direction: direction,
+		total:     total,
+		interval:  p.getConfiguration().getProgressEventInterval(),
+	}
+}
+
+// enabled reports whether the reporter publishes anything
+func (r *progressReporter) enabled() bool {
+	return r.userID != "" && r.interval > 0
+}
+
+// Start publishes the initial event so clients can show the total straight away
+func (r *progressReporter) Start(result *syncresult.Result) {
+	if !r.enabled() {
+		return
+	}
+	r.publish(0, result, false)
+}
+
+// Processed counts one handled record and publishes every interval records
+func (r *progressReporter) Processed(result *syncresult.Result) {
+	if !r.enabled() {
+		return
+	}
+
+	r.mu.Lock()
+	r.processed++
+	processed := r.processed
+	r.mu.Unlock()
+
+	if processed%r.interval == 0 {
+		r.publish(processed, result, false)
+	}
+}
+
+// Done publishes the final event for the run
+func (r *progressReporter) Done(result *syncresult.Result) {
+	if !r.enabled() {
+		return
+	}
+
+	r.mu.Lock()
+	processed := r.processed
+	r.mu.Unlock()
+
+	r.publish(processed, result, true)
+}
+
+func (r *progressReporter) publish(processed int, result *syncresult.Result, done bool) {
+	r.p.API.PublishWebSocketEvent(websocketEventSyncProgress, map[string]interface{}{
+		"direction": r.direction,
+		"processed": processed,
+		"total":     r.total,
+		"matched":   result.MatchedCount,
+		"updated":   result.UpdatedCount,
+		"created":   result.CreatedCount,
+		"skipped":   result.SkippedCount,
+		"failed":    result.FailedCount,
+		"done":      done,
+	}, &model.WebsocketBroadcast{UserId: r.userID})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// errRecordTimedOut is the failure of a record that took longer than RecordTimeoutSeconds
+var errRecordTimedOut = errors.New("record timed out")
+
+// syncRecordWithTimeout runs sync for a single record, giving up on it after the configured
+// RecordTimeoutSeconds so one hung ERPNext call can't use up the whole run. sync gets a context
+// that is cancelled once the record times out: its ERPNext requests, limiter waits and retries
+// stop, and it creates no Mattermost user afterwards, so a record reported as failed doesn't go
+// on writing in the background. A Mattermost call already in progress can't be interrupted and
+// finishes unreported; deactivation waits for it like any running sync. label identifies the
+// record in the failure message.
+func (p *Plugin) syncRecordWithTimeout(ctx context.Context, label string, sync func(context.Context) recordSyncResult) recordSyncResult {
+	timeout := p.getConfiguration().getRecordTimeout()
+	if timeout <= 0 {
+		return sync(ctx)
+	}
+
+	recordCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan recordSyncResult, 1)
+	release := p.syncs.begin()
+	go func() {
+		defer release()
+		done <- sync(recordCtx)
+	}()
+
+	select {
+	case res := <-done:
+		return res
+	case <-recordCtx.Done():
+		p.API.LogWarn("Record took too long, moving on to the next one", "record", label, "timeout", timeout.String())
+		var res recordSyncResult
+		return res.failed(errors.Wrapf(errRecordTimedOut, "after %s", timeout), fmt.Sprintf("%s - Timed Out after %s, moved on to the next record", label, timeout))
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSyncRecordWithTimeout(t *testing.T) {
+	api := &plugintest.API{}
+	allowLogs(api)
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{RecordTimeoutSeconds: 1})
+
+	t.Run("fast record is returned as is", func(t *testing.T) {
+		res := p.syncRecordWithTimeout(context.Background(), "jane", func(context.Context) recordSyncResult {
+			return recordSyncResult{Outcome: outcomeMatched, Message: "jane - Already Mapped"}
+		})
+		assert.Equal(t, outcomeMatched, res.Outcome)
+		assert.NoError(t, res.Err)
+	})
+
+	t.Run("hung record fails and the sync moves on", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+
+		started := time.Now()
+		res := p.syncRecordWithTimeout(context.Background(), "john (john@example.com)", func(context.Context) recordSyncResult {
+			<-release
+			return recordSyncResult{Outcome: outcomeUpdated}
+		})
+		assert.Less(t, time.Since(started), 3*time.Second)
+		assert.True(t, errors.Is(res.Err, errRecordTimedOut))
+		assert.Equal(t, "john (john@example.com) - Timed Out after 1s, moved on to the next record", res.Message)
+	})
+
+	t.Run("timed out record is cancelled", func(t *testing.T) {
+		stopped := make(chan error, 1)
+		res := p.syncRecordWithTimeout(context.Background(), "jim", func(ctx context.Context) recordSyncResult {
+			<-ctx.Done()
+			stopped <- ctx.Err()
+			return recordSyncResult{Outcome: outcomeCreated}
+		})
+		assert.True(t, errors.Is(res.Err, errRecordTimedOut))
+
+		select {
+		case err := <-stopped:
+			assert.Equal(t, context.DeadlineExceeded, err)
+		case <-time.After(time.Second):
+			t.Fatal("the record kept running after timing out")
+		}
+	})
+
+	t.Run("no user is created once the record timed out", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		created, _, appErr := p.createUserWithRetry(ctx, &model.User{Email: "jim@example.com", Username: "jim"})
+		assert.Nil(t, created)
+		assert.NotNil(t, appErr)
+		api.AssertNotCalled(t, "CreateUser", mock.Anything)
+	})
+}
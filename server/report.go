@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/syncresult"
+)
+
+// wantsFailuresOnly reports whether the client asked for only the failed records, with
+// ?failures_only=true
+func wantsFailuresOnly(r *http.Request) bool {
+	return r.URL.Query().Get("failures_only") == "true"
+}
+
+// wantsCSV reports whether the client asked for the results as CSV, with ?format=csv
+func wantsCSV(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "csv"
+}
+
+// failureReport is the JSON response of a sync run with ?failures_only=true
+type failureReport struct {
+	SchemaVersion int                `json:"schema_version"`
+	Direction     string             `json:"direction"`
+	FailedCount   int                `json:"failed_count"`
+	Summary       string             `json:"summary"`
+	Failures      []syncresult.Entry `json:"failures"`
+}
+
+// writeSyncResult writes the result of a sync run as JSON, or as CSV with ?format=csv. With
+// ?failures_only=true only the failed records are included, for triage before retrying them.
+func (p *Plugin) writeSyncResult(w http.ResponseWriter, r *http.Request, result *syncresult.Result) {
+	failuresOnly := wantsFailuresOnly(r)
+
+	if wantsCSV(r) {
+		entries, kind := result.Entries, "results"
+		if failuresOnly {
+			entries, kind = result.Failures(), "failures"
+		}
+		filename := fmt.Sprintf("erpsync-%s-%s-%s.csv", result.Direction, kind, time.Now().UTC().Format("20060102-150405"))
+
+		data, err := syncresult.EntriesCSV(entries)
+		if err != nil {
+			p.API.LogError("Failed to render sync results as CSV", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		if _, err := w.Write(data); err != nil {
+			p.API.LogError("Failed to write CSV response", "error", err)
+		}
+		return
+	}
+
+	var response interface{} = result
+	if failuresOnly {
+		response = failureReport{
+			SchemaVersion: syncresult.SchemaVersion,
+			Direction:     result.Direction,
+			FailedCount:   result.FailedCount,
+			Summary:       result.Summary(),
+			Failures:      result.Failures(),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogError("Failed to encode response", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import "sync/atomic"
+
+// runLimit caps how often a sync run does something drastic, e.g. creating accounts. Slots are
+// taken before the action and only given back when it didn't happen, so actions of records that
+// failed afterwards or were abandoned after a timeout still count. A nil limit is unlimited.
+type runLimit struct {
+	max  int64
+	used atomic.Int64
+}
+
+// newRunLimit returns a limit of max actions per run, nil (unlimited) when max is 0 or less
+func newRunLimit(max int) *runLimit {
+	if max <= 0 {
+		return nil
+	}
+	return &runLimit{max: int64(max)}
+}
+
+// take claims a slot, returning false once the limit is reached
+func (l *runLimit) take() bool {
+	if l == nil {
+		return true
+	}
+	for {
+		used := l.used.Load()
+		if used >= l.max {
+			return false
+		}
+		if l.used.CompareAndSwap(used, used+1) {
+			return true
+		}
+	}
+}
+
+// giveBack returns a slot whose action didn't happen
+func (l *runLimit) giveBack() {
+	if l != nil {
+		l.used.Add(-1)
+	}
+}
+
+// employeeSyncLimits are the per-run limits of an erp→mm sync. A nil value is unlimited.
+type employeeSyncLimits struct {
+	// newAccounts caps the Mattermost accounts created, MaxNewAccountsPerRun
+	newAccounts *runLimit
+
+	// deactivations caps the Mattermost accounts deactivated, MaxDeactivationsPerRun
+	deactivations *runLimit
+}
+
+// newAccountSlot claims a slot for a new Mattermost account
+func (l *employeeSyncLimits) newAccountSlot() bool {
+	return l == nil || l.newAccounts.take()
+}
+
+// releaseNewAccountSlot gives back the slot of an account that wasn't created
+func (l *employeeSyncLimits) releaseNewAccountSlot() {
+	if l != nil {
+		l.newAccounts.giveBack()
+	}
+}
+
+// deactivationSlot claims a slot for deactivating a Mattermost account
+func (l *employeeSyncLimits) deactivationSlot() bool {
+	return l == nil || l.deactivations.take()
+}
+
+// releaseDeactivationSlot gives back the slot of an account that wasn't deactivated
+func (l *employeeSyncLimits) releaseDeactivationSlot() {
+	if l != nil {
+		l.deactivations.giveBack()
+	}
+}
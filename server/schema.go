@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/syncresult"
+)
+
+// fieldMapping is a single field written by a sync, with where its value comes from
+type fieldMapping struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Note   string `json:"note,omitempty"`
+}
+
+// syncSchema describes the fields the plugin reads and writes on each side, resolved from the
+// current configuration
+type syncSchema struct {
+	SchemaVersion      int                    `json:"schema_version"`
+	ChatIDField        string                 `json:"chat_id_field"`
+	MatchKey           string                 `json:"match_key"`
+	DefaultRoleProfile string                 `json:"default_role_profile"`
+	RoleProfileMapping map[string]string      `json:"role_profile_mapping,omitempty"`
+	DefaultFieldValues map[string]interface{} `json:"default_field_values,omitempty"`
+	UserExtraFields    map[string]interface{} `json:"erpnext_user_extra_fields,omitempty"`
+
+	EmployeeChildTables map[string][]map[string]interface{} `json:"employee_child_tables,omitempty"`
+
+	// MattermostToEmployee and MattermostToERPUser are written by the mm→erp sync,
+	// ERPNextToMattermost by the erp→mm sync
+	MattermostToEmployee []fieldMapping `json:"mattermost_to_erpnext_employee"`
+	MattermostToERPUser  []fieldMapping `json:"mattermost_to_erpnext_user"`
+	ERPNextToMattermost  []fieldMapping `json:"erpnext_to_mattermost"`
+}
+
+// buildSyncSchema resolves the synced fields from the configuration
+func (c *configuration) buildSyncSchema() syncSchema {
+	chatIDField := c.getERPNextChatIDField()
+
+	schema := syncSchema{
+		SchemaVersion:      syncresult.SchemaVersion,
+		ChatIDField:        chatIDField,
+		MatchKey:           c.getSyncMatchKey(),
+		DefaultRoleProfile: c.getDefaultRoleProfile(),
+	}
+	schema.DefaultFieldValues, _ = c.getDefaultFieldValues()
+	schema.UserExtraFields, _ = c.getERPNextUserExtraFields()
+	schema.EmployeeChildTables, _ = c.getEmployeeChildTables()
+
+	for _, mapping := range c.getRoleProfileMappings() {
+		if schema.RoleProfileMapping == nil {
+			schema.RoleProfileMapping = map[string]string{}
+		}
+		if _, exists := schema.RoleProfileMapping[mapping.Role]; !exists {
+			schema.RoleProfileMapping[mapping.Role] = mapping.Profile
+		}
+	}
+
+	emailNote := ""
+	if strings.TrimSpace(c.EmailDomainRewrite) != "" {
+		emailNote = "domain rewritten by EmailDomainRewrite"
+	}
+	firstNameNote := "users without one only map onto existing employees"
+	if c.DeriveFirstNameFromEmail {
+		firstNameNote = "derived from the email when empty"
+	}
+
+	newEmployeeStatus, _ := c.getNewEmployeeStatus()
+	schema.MattermostToEmployee = []fieldMapping{
+		{Source: "email", Target: "company_email", Note: emailNote},
+		{Source: "first_name", Target: "first_name", Note: firstNameNote},
+		{Source: "last_name", Target: "last_name"},
+		{Source: "id", Target: chatIDField},
+		{Source: "(fixed) Male", Target: "gender", Note: "on creation"},
+		{Source: "(fixed) 2000-01-01", Target: "date_of_birth", Note: "on creation"},
+		{Source: c.dateOfJoiningSchemaSource(), Target: "date_of_joining", Note: "on creation, never updated"},
+		{Source: "(fixed) " + newEmployeeStatus, Target: "status", Note: "on creation"},
+		{Source: "(ERPNext user)", Target: "user_id"},
+	}
+	if c.getSyncMatchKey() == matchKeyEmployeeNumber {
+		attribute := strings.TrimSpace(c.EmployeeNumberAttribute)
+		if attribute == "" {
+			attribute = defaultEmployeeNumberAttribute
+		}
+		schema.MattermostToEmployee = append(schema.MattermostToEmployee,
+			fieldMapping{Source: "props." + attribute, Target: "employee_number"})
+	}
+	if c.SetPersonalEmail {
+		source := "email"
+		if attribute := strings.TrimSpace(c.PersonalEmailAttribute); attribute != "" {
+			source = "props." + attribute
+		}
+		schema.MattermostToEmployee = append(schema.MattermostToEmployee,
+			fieldMapping{Source: source, Target: "personal_email", Note: "on creation"})
+	}
+	if c.SyncPhoneNumbers {
+		schema.MattermostToEmployee = append(schema.MattermostToEmployee,
+			fieldMapping{Source: "props." + c.getPhoneNumberAttribute(), Target: "cell_number", Note: c.fieldSourceNote(fieldPhone, directionMMToERP)})
+	}
+	if value := c.getOnboardedStatusValue(); value != "" {
+		schema.MattermostToEmployee = append(schema.MattermostToEmployee,
+			fieldMapping{Source: "(fixed) " + value, Target: strings.TrimSpace(c.OnboardedStatusField), Note: "once the Mattermost user is confirmed live"})
+	}
+	if c.PushProfileImages {
+		schema.MattermostToEmployee = append(schema.MattermostToEmployee,
+			fieldMapping{Source: "profile image", Target: "image", Note: c.fieldSourceNote(fieldImage, directionMMToERP)})
+	}
+
+	schema.MattermostToERPUser = []fieldMapping{
+		{Source: "email", Target: "email", Note: emailNote},
+		{Source: "first_name", Target: "first_name", Note: firstNameNote},
+		{Source: "last_name", Target: "last_name"},
+		{Source: "email local part", Target: "username"},
+		{Source: "roles", Target: "role_profile_name", Note: "via RoleProfileMapping, else the default role profile"},
+		{Source: "(fixed) 1", Target: "enabled"},
+	}
+	if series := strings.TrimSpace(c.ERPNextUserNamingSeries); series != "" {
+		schema.MattermostToERPUser = append(schema.MattermostToERPUser,
+			fieldMapping{Source: "(fixed) " + series, Target: "naming_series"})
+	}
+	if sourceField, sourceValue := c.getCreatedBySource(); sourceField != "" {
+		schema.MattermostToERPUser = append(schema.MattermostToERPUser,
+			fieldMapping{Source: "(fixed) " + sourceValue, Target: sourceField, Note: "on creation"})
+	}
+
+	lastNameNote := ""
+	if c.DefaultLastName != "" {
+		lastNameNote = "DefaultLastName when empty"
+	}
+	schema.ERPNextToMattermost = []fieldMapping{
+		{Source: "company_email", Target: "email"},
+		{Source: "first_name", Target: "first_name"},
+		{Source: "last_name", Target: "last_name", Note: lastNameNote},
+		{Source: "first_name + last_name", Target: "username", Note: "generated on creation"},
+	}
+	if c.getCreatedUserAuthService() != "" {
+		schema.ERPNextToMattermost = append(schema.ERPNextToMattermost,
+			fieldMapping{Source: "company_email", Target: "auth_data", Note: "auth service " + c.getCreatedUserAuthService()})
+	}
+	if c.SyncProfileImages {
+		schema.ERPNextToMattermost = append(schema.ERPNextToMattermost,
+			fieldMapping{Source: "image", Target: "profile image", Note: "on creation"})
+	}
+	if c.SyncPhoneNumbers {
+		schema.ERPNextToMattermost = append(schema.ERPNextToMattermost,
+			fieldMapping{Source: "cell_number", Target: "props." + c.getPhoneNumberAttribute(), Note: c.fieldSourceNote(fieldPhone, directionERPToMM)})
+	}
+	if c.SyncBranch {
+		schema.ERPNextToMattermost = append(schema.ERPNextToMattermost,
+			fieldMapping{Source: "branch", Target: "props." + c.getBranchAttribute(), Note: "on creation"})
+	}
+	if c.SyncManager {
+		schema.ERPNextToMattermost = append(schema.ERPNextToMattermost,
+			fieldMapping{Source: "reports_to", Target: "props." + c.getManagerAttribute(), Note: "the manager's username, once the manager has a Mattermost user"})
+	}
+	if c.TagCreatedUsers {
+		schema.ERPNextToMattermost = append(schema.ERPNextToMattermost,
+			fieldMapping{Source: "name", Target: "erp_sync preferences", Note: "on creation"})
+	}
+
+	return schema
+}
+
+// GetSyncSchema returns the fields each sync reads and writes with the current configuration
+func (p *Plugin) GetSyncSchema(w http.ResponseWriter, r *http.Request) {
+	schema := p.getConfiguration().buildSyncSchema()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(schema); err != nil {
+		p.API.LogError("Failed to encode response", "error", err)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/erpnext"
+)
+
+// syncDrainTimeout is how long OnDeactivate waits for running syncs to stop at their next record
+const syncDrainTimeout = 10 * time.Second
+
+// syncTracker tracks the syncs running in this plugin process so OnDeactivate can ask them to
+// stop and wait for them, instead of leaving them writing while the plugin is torn down. A nil
+// tracker, as in a plugin that was never activated, never stops anything.
+type syncTracker struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	closed  bool
+	running sync.WaitGroup
+}
+
+// newSyncTracker returns a tracker whose context is cancelled on shutdown
+func newSyncTracker() *syncTracker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &syncTracker{ctx: ctx, cancel: cancel}
+}
+
+// begin registers a running sync. The returned function must be called once the sync is done.
+// Syncs starting after shutdown aren't waited for, and see stopping() right away.
+func (t *syncTracker) begin() func() {
+	if t == nil {
+		return func() {}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return func() {}
+	}
+
+	t.running.Add(1)
+	return t.running.Done
+}
+
+// context returns the context that is cancelled on shutdown
+func (t *syncTracker) context() context.Context {
+	if t == nil {
+		return context.Background()
+	}
+	return t.ctx
+}
+
+// stopping reports whether the plugin is shutting down, in which case syncs stop before their
+// next record
+func (t *syncTracker) stopping() bool {
+	return t != nil && t.ctx.Err() != nil
+}
+
+// shutdown cancels the shutdown context and waits up to timeout for running syncs to finish.
+// Returns false when some were still running once the timeout passed.
+func (t *syncTracker) shutdown(timeout time.Duration) bool {
+	if t == nil {
+		return true
+	}
+
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+	t.cancel()
+
+	drained := make(chan struct{})
+	go func() {
+		t.running.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// syncContext returns the context of a sync run started at startedAt. It is done once the plugin
+// shuts down or maxDuration has passed, stopping the run's ERPNext requests and waits, and
+// carries the run's own retry budget.
+func (p *Plugin) syncContext(startedAt time.Time, maxDuration time.Duration) (context.Context, context.CancelFunc) {
+	budget := erpnext.NewRetryBudget(p.getConfiguration().getSyncRetryBudget())
+	ctx := erpnext.ContextWithRetryBudget(p.syncs.context(), budget)
+	return context.WithDeadline(ctx, startedAt.Add(maxDuration))
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/erpnext"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncTrackerShutdown(t *testing.T) {
+	t.Run("waits for running syncs", func(t *testing.T) {
+		tracker := newSyncTracker()
+		done := tracker.begin()
+
+		stopped := make(chan struct{})
+		go func() {
+			// A sync stops at its next record once it sees the shutdown
+			for !tracker.stopping() {
+				time.Sleep(time.Millisecond)
+			}
+			done()
+			close(stopped)
+		}()
+
+		assert.True(t, tracker.shutdown(time.Second))
+		<-stopped
+	})
+
+	t.Run("gives up after the timeout", func(t *testing.T) {
+		tracker := newSyncTracker()
+		done := tracker.begin()
+		defer done()
+
+		assert.False(t, tracker.shutdown(10*time.Millisecond))
+	})
+
+	t.Run("syncs starting after shutdown stop right away", func(t *testing.T) {
+		tracker := newSyncTracker()
+		assert.True(t, tracker.shutdown(time.Second))
+
+		tracker.begin()()
+		assert.True(t, tracker.stopping())
+	})
+
+	t.Run("nil tracker never stops", func(t *testing.T) {
+		var tracker *syncTracker
+		tracker.begin()()
+		assert.False(t, tracker.stopping())
+		assert.True(t, tracker.shutdown(time.Second))
+	})
+}
+
+func TestSyncContextHasItsOwnRetryBudget(t *testing.T) {
+	p := &Plugin{}
+	p.setConfiguration(&configuration{SyncRetryBudgetSeconds: 30})
+
+	firstCtx, cancelFirst := p.syncContext(time.Now(), time.Minute)
+	defer cancelFirst()
+	secondCtx, cancelSecond := p.syncContext(time.Now(), time.Minute)
+	defer cancelSecond()
+
+	first := erpnext.RetryBudgetFromContext(firstCtx)
+	second := erpnext.RetryBudgetFromContext(secondCtx)
+	require.NotNil(t, first)
+	require.NotNil(t, second)
+	assert.Equal(t, 30*time.Second, first.Limit())
+
+	// Overlapping runs don't draw from each other's budget
+	assert.True(t, first.Take(10*time.Second))
+	assert.Equal(t, 10*time.Second, first.Used())
+	assert.Zero(t, second.Used())
+
+	deadline, ok := firstCtx.Deadline()
+	require.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second)
+}
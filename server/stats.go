@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// defaultSyncStatsRuns is the number of recent runs /erpsync syncstats summarizes by default
+const defaultSyncStatsRuns = 10
+
+// executeSyncStatsCommand summarizes the last limit sync runs per direction
+func (p *Plugin) executeSyncStatsCommand(limit int) *model.CommandResponse {
+	if p.kvstore == nil {
+		return ephemeralResponse("Sync history is not available.")
+	}
+
+	runs, err := p.kvstore.GetSyncRuns()
+	if err != nil {
+		p.API.LogError("Failed to load sync run history", "error", err.Error())
+		return ephemeralResponse(fmt.Sprintf("Failed to load sync history: %s", err.Error()))
+	}
+	if len(runs) == 0 {
+		return ephemeralResponse("No sync runs have been recorded yet.")
+	}
+	if len(runs) > limit {
+		runs = runs[len(runs)-limit:]
+	}
+
+	lastFullSync := map[string]time.Time{}
+	for _, direction := range []string{directionMMToERP, directionERPToMM} {
+		last, err := p.kvstore.GetLastSync(direction)
+		if err != nil {
+			p.API.LogError("Failed to load last sync time", "direction", direction, "error", err.Error())
+			continue
+		}
+		lastFullSync[direction] = last
+	}
+
+	return ephemeralResponse(syncStatsMarkdown(runs, lastFullSync))
+}
+
+// syncStatsMarkdown renders one table row per direction: run count, average duration, records
+// created and updated, the share of processed records that failed and the last full sync
+func syncStatsMarkdown(runs []kvstore.SyncRun, lastFullSync map[string]time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#### Sync statistics for the last %d runs\n\n", len(runs))
+	b.WriteString("| Direction | Runs | Incomplete | Avg duration | Created | Updated | Failure rate | Last full sync |\n")
+	b.WriteString("|---|---|---|---|---|---|---|---|\n")
+
+	for _, direction := range []string{directionMMToERP, directionERPToMM} {
+		var count, incomplete, created, updated, failed, processed int
+		var duration int64
+		for _, run := range runs {
+			if run.Direction != direction {
+				continue
+			}
+			count++
+			if !run.Complete {
+				incomplete++
+			}
+			duration += run.DurationMs
+			created += run.Created
+			updated += run.Updated
+			failed += run.Failed
+			processed += run.Matched + run.Updated + run.Created + run.Skipped + run.Failed
+		}
+		if count == 0 {
+			continue
+		}
+
+		average := (time.Duration(duration/int64(count)) * time.Millisecond).Round(time.Second)
+		failureRate := 0.0
+		if processed > 0 {
+			failureRate = float64(failed) / float64(processed) * 100
+		}
+		last := "never"
+		if t := lastFullSync[direction]; !t.IsZero() {
+			last = t.UTC().Format("2006-01-02 15:04 MST")
+		}
+
+		fmt.Fprintf(&b, "| %s | %d | %d | %s | %d | %d | %.1f%% | %s |\n",
+			direction, count, incomplete, average, created, updated, failureRate, last)
+	}
+
+	return b.String()
+}
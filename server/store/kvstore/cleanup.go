@@ -0,0 +1,65 @@
+package kvstore
+
+// Cleanup prunes stored sync data recorded before cutoff (milliseconds since epoch) and returns
+// the number of records removed. Every kind of timestamped sync data kept in the KV store is
+// pruned here.
+func (kv Client) Cleanup(cutoff int64) (int, error) {
+	removed := 0
+
+	// The counts are taken inside the updates, which are re-run when a list changed meanwhile
+	var removedEntries int
+	err := kv.UpdateFailedEntries(func(entries []FailedEntry) []FailedEntry {
+		removedEntries = 0
+		kept := entries[:0]
+		for _, entry := range entries {
+			if entry.FailedAt < cutoff {
+				removedEntries++
+				continue
+			}
+			kept = append(kept, entry)
+		}
+		return kept
+	})
+	if err != nil {
+		return 0, err
+	}
+	removed += removedEntries
+
+	var removedRuns int
+	err = kv.updateSyncRuns(func(runs []SyncRun) []SyncRun {
+		removedRuns = 0
+		kept := runs[:0]
+		for _, run := range runs {
+			if run.StartedAt < cutoff {
+				removedRuns++
+				continue
+			}
+			kept = append(kept, run)
+		}
+		return kept
+	})
+	if err != nil {
+		return removed, err
+	}
+	removed += removedRuns
+
+	var removedRetries int
+	err = kv.UpdateEmailRetries(func(retries []EmailRetry) []EmailRetry {
+		removedRetries = 0
+		kept := retries[:0]
+		for _, retry := range retries {
+			if retry.QueuedAt < cutoff {
+				removedRetries++
+				continue
+			}
+			kept = append(kept, retry)
+		}
+		return kept
+	})
+	if err != nil {
+		return removed, err
+	}
+	removed += removedRetries
+
+	return removed, nil
+}
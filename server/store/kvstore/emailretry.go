@@ -0,0 +1,54 @@
+package kvstore
+
+import (
+	"github.com/pkg/errors"
+)
+
+// emailRetriesKey is the KV key holding the credential emails waiting to be resent.
+const emailRetriesKey = "credential_email_retries"
+
+// EmailRetry is a credential email that couldn't be delivered, e.g. because the mail provider
+// throttled, and is resent by the scheduled job. No credentials are stored: the resend is built
+// from the user at the time it is sent. Entries queued by older versions lose their stored
+// password the next time the queue is saved.
+type EmailRetry struct {
+	UserID    string `json:"user_id"`
+	Email     string `json:"email"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error"`
+	QueuedAt  int64  `json:"queued_at"`
+
+	// NextAttemptAt is the earliest time the email is resent (milliseconds since epoch)
+	NextAttemptAt int64 `json:"next_attempt_at"`
+}
+
+// GetEmailRetries returns all queued credential emails.
+func (kv Client) GetEmailRetries() ([]EmailRetry, error) {
+	var retries []EmailRetry
+	if err := kv.client.KV.Get(emailRetriesKey, &retries); err != nil {
+		return nil, errors.Wrap(err, "failed to get credential email retries")
+	}
+	return retries, nil
+}
+
+// AddEmailRetry queues a credential email, replacing any queued email for the same user.
+func (kv Client) AddEmailRetry(retry EmailRetry) error {
+	return kv.UpdateEmailRetries(func(retries []EmailRetry) []EmailRetry {
+		for i, existing := range retries {
+			if existing.UserID == retry.UserID {
+				retries[i] = retry
+				return retries
+			}
+		}
+		return append(retries, retry)
+	})
+}
+
+// UpdateEmailRetries atomically replaces the queued credential emails with update's result, which
+// is re-run if the queue changed first. An empty result deletes the key.
+func (kv Client) UpdateEmailRetries(update func(retries []EmailRetry) []EmailRetry) error {
+	if err := updateList(kv, emailRetriesKey, update); err != nil {
+		return errors.Wrap(err, "failed to save credential email retries")
+	}
+	return nil
+}
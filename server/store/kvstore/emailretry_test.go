@@ -0,0 +1,23 @@
+package kvstore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmailRetriesDropLegacyPasswords(t *testing.T) {
+	kv, data := newMemoryKVStore(t)
+	data[emailRetriesKey] = []byte(`[{"user_id":"user-id","email":"jane@example.com","username":"jane.doe","password":"s3cret!","attempts":1}]`)
+
+	retries, err := kv.GetEmailRetries()
+	require.NoError(t, err)
+	require.Len(t, retries, 1)
+	assert.Equal(t, "user-id", retries[0].UserID)
+	assert.Equal(t, 1, retries[0].Attempts)
+
+	require.NoError(t, kv.UpdateEmailRetries(func(retries []EmailRetry) []EmailRetry { return retries }))
+	assert.NotContains(t, string(data[emailRetriesKey]), "s3cret!")
+	assert.NotContains(t, string(data[emailRetriesKey]), "password")
+}
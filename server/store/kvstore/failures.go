@@ -0,0 +1,47 @@
+package kvstore
+
+import (
+	"github.com/pkg/errors"
+)
+
+// failedEntriesKey is the KV key holding the dead-letter list of records that failed to sync.
+const failedEntriesKey = "sync_failed_entries"
+
+// FailedEntry is a single record that failed during a sync and can be retried later.
+type FailedEntry struct {
+	Email     string `json:"email"`
+	Error     string `json:"error"`
+	Direction string `json:"direction"`
+	FailedAt  int64  `json:"failed_at"`
+}
+
+// GetFailedEntries returns all stored failed entries.
+func (kv Client) GetFailedEntries() ([]FailedEntry, error) {
+	var entries []FailedEntry
+	if err := kv.client.KV.Get(failedEntriesKey, &entries); err != nil {
+		return nil, errors.Wrap(err, "failed to get failed sync entries")
+	}
+	return entries, nil
+}
+
+// AddFailedEntry stores a failed entry, replacing any earlier entry for the same email and direction.
+func (kv Client) AddFailedEntry(entry FailedEntry) error {
+	return kv.UpdateFailedEntries(func(entries []FailedEntry) []FailedEntry {
+		for i, existing := range entries {
+			if existing.Direction == entry.Direction && existing.Email == entry.Email {
+				entries[i] = entry
+				return entries
+			}
+		}
+		return append(entries, entry)
+	})
+}
+
+// UpdateFailedEntries atomically replaces the stored failed entries with update's result, which
+// is re-run if a concurrent sync changed the list first. An empty result deletes the key.
+func (kv Client) UpdateFailedEntries(update func(entries []FailedEntry) []FailedEntry) error {
+	if err := updateList(kv, failedEntriesKey, update); err != nil {
+		return errors.Wrap(err, "failed to save failed sync entries")
+	}
+	return nil
+}
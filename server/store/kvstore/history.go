@@ -0,0 +1,61 @@
+package kvstore
+
+import (
+	"github.com/pkg/errors"
+)
+
+// syncHistoryKey is the KV key holding the summaries of recent sync runs.
+const syncHistoryKey = "sync_run_history"
+
+// MaxSyncRuns is the number of most recent runs kept in the history.
+const MaxSyncRuns = 100
+
+// SyncRun is the summary of a single sync run kept for statistics.
+type SyncRun struct {
+	Direction string `json:"direction"`
+	// CorrelationID matches the run's result, logs and alerts
+	CorrelationID string `json:"correlation_id,omitempty"`
+	StartedAt     int64  `json:"started_at"`
+	DurationMs    int64  `json:"duration_ms"`
+	Matched       int    `json:"matched"`
+	Updated       int    `json:"updated"`
+	Created       int    `json:"created"`
+	Skipped       int    `json:"skipped"`
+	Failed        int    `json:"failed"`
+
+	// Complete is false when the run timed out, was aborted or was truncated, or only covered
+	// the records a request listed
+	Complete bool `json:"complete"`
+
+	// Targeted is set when the run only covered the records a request listed
+	Targeted bool `json:"targeted,omitempty"`
+}
+
+// GetSyncRuns returns the stored run history, oldest first.
+func (kv Client) GetSyncRuns() ([]SyncRun, error) {
+	var runs []SyncRun
+	if err := kv.client.KV.Get(syncHistoryKey, &runs); err != nil {
+		return nil, errors.Wrap(err, "failed to get sync run history")
+	}
+	return runs, nil
+}
+
+// AddSyncRun appends a run to the history, dropping the oldest runs beyond MaxSyncRuns.
+func (kv Client) AddSyncRun(run SyncRun) error {
+	return kv.updateSyncRuns(func(runs []SyncRun) []SyncRun {
+		runs = append(runs, run)
+		if len(runs) > MaxSyncRuns {
+			runs = runs[len(runs)-MaxSyncRuns:]
+		}
+		return runs
+	})
+}
+
+// updateSyncRuns atomically replaces the run history with update's result. An empty result
+// deletes the key.
+func (kv Client) updateSyncRuns(update func(runs []SyncRun) []SyncRun) error {
+	if err := updateList(kv, syncHistoryKey, update); err != nil {
+		return errors.Wrap(err, "failed to save sync run history")
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package kvstore
+
+import (
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/pkg/errors"
+)
+
+// initialSyncKey is the KV key claimed by the node running the sync on activation.
+const initialSyncKey = "initial_sync_claim"
+
+// ClaimInitialSync atomically claims the sync on activation for ttl, so only one node of a
+// cluster runs it and re-activations within ttl don't repeat it. Returns false when it is
+// already claimed.
+func (kv Client) ClaimInitialSync(ttl time.Duration) (bool, error) {
+	claimed, err := kv.client.KV.Set(initialSyncKey, time.Now().UnixMilli(), pluginapi.SetAtomic(nil), pluginapi.SetExpiry(ttl))
+	if err != nil {
+		return false, errors.Wrap(err, "failed to claim initial sync")
+	}
+	return claimed, nil
+}
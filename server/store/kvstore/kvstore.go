@@ -1,6 +1,40 @@
 package kvstore
 
+import "time"
+
 type KVStore interface {
 	// Define your methods here. This package is used to access the KVStore pluginapi methods.
 	GetTemplateData(userID string) (string, error)
+
+	// Dead-letter list of records that failed during a sync
+	GetFailedEntries() ([]FailedEntry, error)
+	AddFailedEntry(entry FailedEntry) error
+	UpdateFailedEntries(update func(entries []FailedEntry) []FailedEntry) error
+
+	// Watermark of the last fully completed sync per direction
+	GetLastSync(direction string) (time.Time, error)
+	SetLastSync(direction string, t time.Time) error
+
+	// Summaries of recent sync runs
+	GetSyncRuns() ([]SyncRun, error)
+	AddSyncRun(run SyncRun) error
+
+	// Pause state of the scheduled sync job
+	GetSyncPause() (*SyncPause, error)
+	SetSyncPause(pause *SyncPause) error
+
+	// Credential emails waiting to be resent
+	GetEmailRetries() ([]EmailRetry, error)
+	AddEmailRetry(retry EmailRetry) error
+	UpdateEmailRetries(update func(retries []EmailRetry) []EmailRetry) error
+
+	// Direction the scheduled sync job runs, set at runtime
+	GetScheduledSyncDirection() (string, error)
+	SetScheduledSyncDirection(direction string) error
+
+	// Claim guarding the sync run on activation
+	ClaimInitialSync(ttl time.Duration) (bool, error)
+
+	// Retention of stored sync data
+	Cleanup(cutoff int64) (int, error)
 }
@@ -0,0 +1,52 @@
+package kvstore
+
+import (
+	"encoding/json"
+
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/pkg/errors"
+)
+
+// maxListUpdateAttempts is how often a list update is retried when another writer changed the
+// list between reading and saving it.
+const maxListUpdateAttempts = 5
+
+// updateList replaces the JSON list stored under key with update's result using compare-and-set,
+// so concurrent syncs and jobs on any node don't lose each other's changes. update is re-run on
+// the latest list whenever the list changed in between. An empty result deletes the key.
+func updateList[T any](kv Client, key string, update func([]T) []T) error {
+	for attempt := 0; attempt < maxListUpdateAttempts; attempt++ {
+		var stored []byte
+		if err := kv.client.KV.Get(key, &stored); err != nil {
+			return errors.Wrap(err, "failed to read the stored list")
+		}
+
+		var list []T
+		if len(stored) > 0 {
+			if err := json.Unmarshal(stored, &list); err != nil {
+				return errors.Wrap(err, "failed to decode the stored list")
+			}
+		}
+
+		updated := update(list)
+		if len(updated) == 0 && len(stored) == 0 {
+			return nil
+		}
+
+		// A nil value deletes the key
+		var value interface{}
+		if len(updated) > 0 {
+			value = updated
+		}
+
+		saved, err := kv.client.KV.Set(key, value, pluginapi.SetAtomic(stored))
+		if err != nil {
+			return errors.Wrap(err, "failed to save the list")
+		}
+		if saved {
+			return nil
+		}
+	}
+
+	return errors.Errorf("the list kept changing, gave up after %d attempts", maxListUpdateAttempts)
+}
@@ -0,0 +1,48 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateFailedEntriesKeepsConcurrentChanges(t *testing.T) {
+	kv, data := newMemoryKVStore(t)
+	require.NoError(t, kv.AddFailedEntry(FailedEntry{Email: "a@example.com", Direction: "mm-to-erp", FailedAt: 1}))
+
+	calls := 0
+	err := kv.UpdateFailedEntries(func(entries []FailedEntry) []FailedEntry {
+		calls++
+		if calls == 1 {
+			// Another sync records a failure between the read and the save
+			concurrent, err := json.Marshal(append(entries, FailedEntry{Email: "b@example.com", Direction: "mm-to-erp", FailedAt: 2}))
+			require.NoError(t, err)
+			data[failedEntriesKey] = concurrent
+		}
+
+		kept := entries[:0]
+		for _, entry := range entries {
+			if entry.Email != "a@example.com" {
+				kept = append(kept, entry)
+			}
+		}
+		return kept
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls)
+
+	entries, err := kv.GetFailedEntries()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "b@example.com", entries[0].Email)
+}
+
+func TestUpdateFailedEntriesDeletesEmptyList(t *testing.T) {
+	kv, data := newMemoryKVStore(t)
+	require.NoError(t, kv.AddFailedEntry(FailedEntry{Email: "a@example.com", Direction: "mm-to-erp", FailedAt: 1}))
+
+	require.NoError(t, kv.UpdateFailedEntries(func([]FailedEntry) []FailedEntry { return nil }))
+	assert.NotContains(t, data, failedEntriesKey)
+}
@@ -0,0 +1,42 @@
+package kvstore
+
+import (
+	"github.com/pkg/errors"
+)
+
+// syncPauseKey is the KV key holding the pause state of the scheduled sync job.
+const syncPauseKey = "sync_pause"
+
+// SyncPause records that an admin paused the scheduled sync job.
+type SyncPause struct {
+	PausedBy string `json:"paused_by"`
+	PausedAt int64  `json:"paused_at"`
+
+	// ResumeAt is when the pause ends by itself (milliseconds since epoch), 0 if it lasts until
+	// an admin resumes the job
+	ResumeAt int64 `json:"resume_at"`
+}
+
+// GetSyncPause returns the current pause, or nil when the scheduled sync isn't paused.
+func (kv Client) GetSyncPause() (*SyncPause, error) {
+	var pause *SyncPause
+	if err := kv.client.KV.Get(syncPauseKey, &pause); err != nil {
+		return nil, errors.Wrap(err, "failed to get sync pause state")
+	}
+	return pause, nil
+}
+
+// SetSyncPause stores the pause state. A nil pause resumes the scheduled sync.
+func (kv Client) SetSyncPause(pause *SyncPause) error {
+	if pause == nil {
+		if err := kv.client.KV.Delete(syncPauseKey); err != nil {
+			return errors.Wrap(err, "failed to clear sync pause state")
+		}
+		return nil
+	}
+
+	if _, err := kv.client.KV.Set(syncPauseKey, pause); err != nil {
+		return errors.Wrap(err, "failed to save sync pause state")
+	}
+	return nil
+}
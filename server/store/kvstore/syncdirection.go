@@ -0,0 +1,26 @@
+package kvstore
+
+import (
+	"github.com/pkg/errors"
+)
+
+// scheduledSyncDirectionKey is the KV key holding what the scheduled sync job syncs.
+const scheduledSyncDirectionKey = "scheduled_sync_direction"
+
+// GetScheduledSyncDirection returns the direction the scheduled sync job runs, or "" when no
+// admin has set one.
+func (kv Client) GetScheduledSyncDirection() (string, error) {
+	var direction string
+	if err := kv.client.KV.Get(scheduledSyncDirectionKey, &direction); err != nil {
+		return "", errors.Wrap(err, "failed to get scheduled sync direction")
+	}
+	return direction, nil
+}
+
+// SetScheduledSyncDirection stores the direction the scheduled sync job runs.
+func (kv Client) SetScheduledSyncDirection(direction string) error {
+	if _, err := kv.client.KV.Set(scheduledSyncDirectionKey, direction); err != nil {
+		return errors.Wrap(err, "failed to save scheduled sync direction")
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package kvstore
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// lastSyncKeyPrefix prefixes the KV key of each direction's last completed sync watermark.
+const lastSyncKeyPrefix = "sync_last_completed_"
+
+// GetLastSync returns when the last fully completed sync in direction started, or the zero time
+// if no sync in that direction has completed yet.
+func (kv Client) GetLastSync(direction string) (time.Time, error) {
+	var millis int64
+	if err := kv.client.KV.Get(lastSyncKeyPrefix+direction, &millis); err != nil {
+		return time.Time{}, errors.Wrapf(err, "failed to get last sync time for %s", direction)
+	}
+	if millis == 0 {
+		return time.Time{}, nil
+	}
+	return time.UnixMilli(millis), nil
+}
+
+// SetLastSync stores the watermark for direction. Only call it for runs that completed fully.
+func (kv Client) SetLastSync(direction string, t time.Time) error {
+	if _, err := kv.client.KV.Set(lastSyncKeyPrefix+direction, t.UnixMilli()); err != nil {
+		return errors.Wrapf(err, "failed to save last sync time for %s", direction)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package kvstore
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newMemoryKVStore returns a KV store client backed by an in-memory map through plugintest.API
+func newMemoryKVStore(t *testing.T) (Client, map[string][]byte) {
+	data := map[string][]byte{}
+
+	api := &plugintest.API{}
+	api.On("KVGet", mock.AnythingOfType("string")).Return(
+		func(key string) []byte { return data[key] },
+		func(string) *model.AppError { return nil },
+	).Maybe()
+	api.On("KVSetWithOptions", mock.AnythingOfType("string"), mock.Anything, mock.Anything).Return(
+		func(key string, value []byte, options model.PluginKVSetOptions) bool {
+			if options.Atomic && !bytes.Equal(data[key], options.OldValue) {
+				return false
+			}
+			if value == nil {
+				delete(data, key)
+				return true
+			}
+			data[key] = value
+			return true
+		},
+		func(string, []byte, model.PluginKVSetOptions) *model.AppError { return nil },
+	).Maybe()
+
+	return Client{client: pluginapi.NewClient(api, nil)}, data
+}
+
+func TestGetLastSyncUnset(t *testing.T) {
+	kv, _ := newMemoryKVStore(t)
+
+	last, err := kv.GetLastSync("mm-to-erp")
+	require.NoError(t, err)
+	assert.True(t, last.IsZero())
+}
+
+func TestSetLastSyncRoundTrip(t *testing.T) {
+	kv, _ := newMemoryKVStore(t)
+	when := time.Date(2024, 5, 17, 8, 30, 0, 0, time.UTC)
+
+	require.NoError(t, kv.SetLastSync("mm-to-erp", when))
+
+	last, err := kv.GetLastSync("mm-to-erp")
+	require.NoError(t, err)
+	assert.True(t, when.Equal(last), "expected %v, got %v", when, last)
+}
+
+func TestLastSyncIsPerDirection(t *testing.T) {
+	kv, data := newMemoryKVStore(t)
+	mmToERP := time.Date(2024, 5, 17, 8, 30, 0, 0, time.UTC)
+	erpToMM := time.Date(2024, 5, 18, 9, 0, 0, 0, time.UTC)
+
+	require.NoError(t, kv.SetLastSync("mm-to-erp", mmToERP))
+	require.NoError(t, kv.SetLastSync("erp-to-mm", erpToMM))
+	assert.Len(t, data, 2)
+
+	last, err := kv.GetLastSync("mm-to-erp")
+	require.NoError(t, err)
+	assert.True(t, mmToERP.Equal(last))
+
+	last, err = kv.GetLastSync("erp-to-mm")
+	require.NoError(t, err)
+	assert.True(t, erpToMM.Equal(last))
+}
+
+func TestGetLastSyncError(t *testing.T) {
+	api := &plugintest.API{}
+	api.On("KVGet", "sync_last_completed_mm-to-erp").Return(nil, model.NewAppError("KVGet", "app.kv.get", nil, "boom", 500))
+	kv := Client{client: pluginapi.NewClient(api, nil)}
+
+	_, err := kv.GetLastSync("mm-to-erp")
+	assert.Error(t, err)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/syncresult"
+)
+
+// ndjsonContentType is the content type used when streaming sync results line by line.
+const ndjsonContentType = "application/x-ndjson"
+
+// wantsResultStream reports whether the client asked for per-user results to be streamed as
+// newline-delimited JSON, either with ?stream=true or an Accept header of application/x-ndjson.
+func wantsResultStream(r *http.Request) bool {
+	if r.URL.Query().Get("stream") == "true" {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), ndjsonContentType)
+}
+
+// resultStream writes sync results to the response as they are produced, one JSON object per line,
+// so very large syncs do not need to buffer their whole result set in memory.
+type resultStream struct {
+	w       http.ResponseWriter
+	encoder *json.Encoder
+	flusher http.Flusher
+	index   int
+}
+
+// streamResultLine is a single per-user entry in a streamed sync response.
+type streamResultLine struct {
+	SchemaVersion int    `json:"schema_version"`
+	Type          string `json:"type"`
+	Index         int    `json:"index"`
+	Message       string `json:"message"`
+}
+
+// streamSummaryLine is the final line of a streamed sync response.
+type streamSummaryLine struct {
+	SchemaVersion int         `json:"schema_version"`
+	Type          string      `json:"type"`
+	Summary       interface{} `json:"summary"`
+}
+
+// newResultStream prepares the response for streaming and writes the headers.
+func newResultStream(w http.ResponseWriter) *resultStream {
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	return &resultStream{
+		w:       w,
+		encoder: json.NewEncoder(w),
+		flusher: flusher,
+	}
+}
+
+// WriteResult writes a single per-user result line and flushes it to the client.
+func (s *resultStream) WriteResult(message string) error {
+	line := streamResultLine{
+		SchemaVersion: syncresult.SchemaVersion,
+		Type:          "result",
+		Index:         s.index,
+		Message:       message,
+	}
+	s.index++
+	return s.write(line)
+}
+
+// WriteSummary writes the final summary line and flushes it to the client.
+func (s *resultStream) WriteSummary(summary interface{}) error {
+	return s.write(streamSummaryLine{
+		SchemaVersion: syncresult.SchemaVersion,
+		Type:          "summary",
+		Summary:       summary,
+	})
+}
+
+func (s *resultStream) write(v interface{}) error {
+	// Encode appends the trailing newline that delimits each record
+	if err := s.encoder.Encode(v); err != nil {
+		return err
+	}
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+	return nil
+}
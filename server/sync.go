@@ -0,0 +1,1515 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/erpnext"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/syncresult"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// Sync directions, used to tag failed records so they can be retried the right way
+const (
+	directionMMToERP = "mm-to-erp"
+	directionERPToMM = "erp-to-mm"
+)
+
+// syncOutcome describes what a sync did with a single record
+type syncOutcome int
+
+const (
+	outcomeNone syncOutcome = iota
+	outcomeMatched
+	outcomeUpdated
+	outcomeCreated
+	outcomeSkipped
+	outcomeDeactivated
+)
+
+// erpUserOutcome describes what the mm→erp sync did with the ERPNext login of an employee
+type erpUserOutcome int
+
+const (
+	erpUserNone erpUserOutcome = iota
+	erpUserCreated
+	erpUserExisted
+	erpUserSkipped
+)
+
+// recordSyncResult is the result of syncing a single user or employee
+type recordSyncResult struct {
+	Outcome syncOutcome
+	ERPUser erpUserOutcome
+	Message string
+
+	// SkipReason is a short reason when the record was skipped
+	SkipReason string
+
+	// Notes are extra details appended to the message, e.g. defaults applied on creation
+	Notes []string
+
+	// Err is set when any step for the record failed
+	Err error
+
+	// PendingChatID is set when the employee's chat ID write was left to a chatIDBatch. The
+	// result is recorded once the batch has been written.
+	PendingChatID *pendingChatIDWrite
+
+	// PendingEmail is the username of a created user whose credential email was queued for retry
+	PendingEmail string
+
+	// ChatIDClaim is the chat ID the record wrote to an employee, for the post-sync verification
+	ChatIDClaim *chatIDClaim
+}
+
+// Text returns the result message with any notes appended to its first line, so they never
+// run into the credential lines of created users
+func (r recordSyncResult) Text() string {
+	if len(r.Notes) == 0 {
+		return r.Message
+	}
+	notes := fmt.Sprintf(" (%s)", strings.Join(r.Notes, "; "))
+	if i := strings.Index(r.Message, "\n"); i >= 0 {
+		return r.Message[:i] + notes + r.Message[i:]
+	}
+	return r.Message + notes
+}
+
+// finished sets the result message for a record that was handled without error
+func (r recordSyncResult) finished(message string) recordSyncResult {
+	r.Message = message
+	return r
+}
+
+// skipReasonAdditiveOnly is the skip reason for records whose only change would update something
+// that already exists, which AdditiveOnly forbids
+const skipReasonAdditiveOnly = "Additive Only"
+
+// noteAdditiveOnly is added to records that were synced but had an update left out by AdditiveOnly
+const noteAdditiveOnly = "update skipped (additive only)"
+
+// skipReasonCreationLimit is the skip reason for employees not given an account because the run
+// reached MaxNewAccountsPerRun
+const skipReasonCreationLimit = "Creation Limit"
+
+// skipReasonDeactivationLimit is the skip reason for inactive employees whose accounts were left
+// active because the run reached MaxDeactivationsPerRun
+const skipReasonDeactivationLimit = "Deactivation Limit"
+
+// skipped marks the record as deliberately not processed
+func (r recordSyncResult) skipped(reason, message string) recordSyncResult {
+	r.Outcome = outcomeSkipped
+	r.SkipReason = reason
+	r.Message = message
+	return r
+}
+
+// recordTo adds the record's outcome to a sync run result
+func (r recordSyncResult) recordTo(result *syncresult.Result) {
+	if r.PendingEmail != "" {
+		result.RecordPendingEmailRetry(r.PendingEmail)
+	}
+
+	switch r.ERPUser {
+	case erpUserCreated:
+		result.RecordERPUserCreated()
+	case erpUserExisted:
+		result.RecordERPUserExisted()
+	case erpUserSkipped:
+		result.RecordERPUserSkipped()
+	}
+
+	if r.Err != nil {
+		// A failure after an earlier step succeeded still counts that step
+		switch r.Outcome {
+		case outcomeMatched:
+			result.RecordPartialFailure(syncresult.StatusMatched, r.Text())
+		case outcomeUpdated:
+			result.RecordPartialFailure(syncresult.StatusUpdated, r.Text())
+		case outcomeCreated:
+			result.RecordPartialFailure(syncresult.StatusCreated, r.Text())
+		default:
+			result.RecordFailed(r.Text())
+		}
+		return
+	}
+
+	switch r.Outcome {
+	case outcomeMatched:
+		result.RecordMatched(r.Text())
+	case outcomeUpdated:
+		result.RecordUpdated(r.Text())
+	case outcomeCreated:
+		result.RecordCreated(r.Text())
+	case outcomeSkipped:
+		result.RecordSkipped(r.SkipReason, r.Text())
+	case outcomeDeactivated:
+		result.RecordDeactivated(r.Text())
+	default:
+		result.RecordNote(r.Text())
+	}
+}
+
+// failed sets the result message and cause for a record that could not be handled
+func (r recordSyncResult) failed(err error, message string) recordSyncResult {
+	r.Message = message
+	r.Err = err
+	return r
+}
+
+// ensureChatIDField makes sure the chat-id field (custom_chat_id unless ERPNextChatIDField says
+// otherwise) exists on the ERPNext Employee doctype, creating it when missing. Returns true when
+// the field had to be created.
+func (p *Plugin) ensureChatIDField(client *erpnext.Client) (bool, error) {
+	fieldName := client.ChatIDFieldName()
+	p.API.LogInfo("Checking if chat ID field exists in ERPNext", "field", fieldName)
+
+	exists, err := client.CheckCustomFieldExists(fieldName, "Employee")
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to check if %s field exists", fieldName)
+	}
+
+	if exists {
+		p.API.LogInfo("Chat ID field already exists in ERPNext", "field", fieldName)
+		return false, nil
+	}
+
+	p.API.LogInfo("Creating chat ID field in ERPNext", "field", fieldName)
+
+	// Create the custom field
+	err = client.CreateCustomField(
+		fieldName,          // Field name
+		"Workdone User ID", // Label
+		"Employee",         // Document type
+		"Data",             // Field type
+		false,              // Not required
+		p.getConfiguration().getChatIDFieldFlags(),
+	)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to create %s field", fieldName)
+	}
+
+	p.API.LogInfo("Successfully created chat ID field in ERPNext", "field", fieldName)
+	return true, nil
+}
+
+// ensureCreatedBySourceField makes sure the CreatedBySourceField custom field exists on the
+// ERPNext User doctype, creating it when missing. Returns true when the field had to be created.
+func (p *Plugin) ensureCreatedBySourceField(client *erpnext.Client, fieldName string) (bool, error) {
+	exists, err := client.CheckCustomFieldExists(fieldName, "User")
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to check if %s field exists", fieldName)
+	}
+	if exists {
+		return false, nil
+	}
+
+	p.API.LogInfo("Creating sync source field in ERPNext", "field", fieldName)
+
+	err = client.CreateCustomField(
+		fieldName,    // Field name
+		"Created By", // Label
+		"User",       // Document type
+		"Data",       // Field type
+		false,        // Not required
+		erpnext.DefaultCustomFieldFlags,
+	)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to create %s field", fieldName)
+	}
+
+	p.API.LogInfo("Successfully created sync source field in ERPNext", "field", fieldName)
+	return true, nil
+}
+
+// ensureRoleProfile makes sure the named role profile exists in ERPNext, creating it when missing.
+// Returns true when the role profile had to be created.
+func (p *Plugin) ensureRoleProfile(client *erpnext.Client, roleProfile string) (bool, error) {
+	p.API.LogInfo("Checking if role profile exists in ERPNext", "role_profile", roleProfile)
+
+	exists, err := client.CheckRoleProfileExists(roleProfile)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to check if '%s' role profile exists", roleProfile)
+	}
+
+	if exists {
+		p.API.LogInfo("Role profile already exists in ERPNext", "role_profile", roleProfile)
+		return false, nil
+	}
+
+	p.API.LogInfo("Creating role profile in ERPNext", "role_profile", roleProfile)
+
+	if err := client.CreateRoleProfile(roleProfile); err != nil {
+		return false, errors.Wrapf(err, "failed to create '%s' role profile", roleProfile)
+	}
+
+	p.API.LogInfo("Successfully created role profile in ERPNext", "role_profile", roleProfile)
+	return true, nil
+}
+
+// verifyRoleProfile confirms the ERPNext user named userName was created with roleProfile,
+// applying it with a follow-up update through whichever field the user document uses when it
+// wasn't. Returns a note describing a fix or a failure to confirm, or "" when the profile was set.
+func (p *Plugin) verifyRoleProfile(client *erpnext.Client, userName, roleProfile string) string {
+	profiles, err := client.GetUserRoleProfiles(userName)
+	if err != nil {
+		p.API.LogWarn("Failed to verify ERPNext user role profile", "erp_user", userName, "role_profile", roleProfile, "error", err.Error())
+		return fmt.Sprintf("role profile %q could not be confirmed: %s", roleProfile, err.Error())
+	}
+	if profiles.Has(roleProfile) {
+		return ""
+	}
+
+	p.API.LogWarn("ERPNext user was created without its role profile, applying it",
+		"erp_user", userName,
+		"role_profile", roleProfile,
+		"child_table", profiles.ChildTable)
+
+	if err := client.SetUserRoleProfile(userName, roleProfile, profiles.ChildTable); err != nil {
+		p.API.LogWarn("Failed to apply ERPNext user role profile", "erp_user", userName, "role_profile", roleProfile, "error", err.Error())
+		return fmt.Sprintf("role profile %q could not be confirmed, applying it failed: %s", roleProfile, err.Error())
+	}
+
+	// Only trust the follow-up once ERPNext reports the profile
+	if profiles, err = client.GetUserRoleProfiles(userName); err != nil || !profiles.Has(roleProfile) {
+		p.API.LogWarn("ERPNext user role profile still not set after update", "erp_user", userName, "role_profile", roleProfile)
+		return fmt.Sprintf("role profile %q could not be confirmed after applying it", roleProfile)
+	}
+
+	return fmt.Sprintf("role profile %q was missing after creation and has been applied", roleProfile)
+}
+
+// erpNextUsernameRetries is how many numbered suffixes uniqueERPNextUsername tries
+const erpNextUsernameRetries = 5
+
+// uniqueERPNextUsername returns baseUsername, or baseUsername with a numeric suffix when an
+// ERPNext user already has it, the same way Mattermost usernames are made unique. When the check
+// fails the last candidate is used and ERPNext has the final say on creation.
+func (p *Plugin) uniqueERPNextUsername(client *erpnext.Client, baseUsername string) string {
+	username := baseUsername
+	for retries := 0; retries < erpNextUsernameRetries; retries++ {
+		exists, err := client.UsernameExists(username)
+		if err != nil {
+			p.API.LogWarn("Failed to check ERPNext username availability", "username", username, "error", err.Error())
+			break
+		}
+		if !exists {
+			break
+		}
+		// Username exists, add a suffix
+		username = fmt.Sprintf("%s_%d", baseUsername, retries+1)
+	}
+	return username
+}
+
+// findEmailConflicts finds Mattermost users sharing an email address (ignoring case and padding) and
+// picks one of each group deterministically: the oldest account, then the lowest ID. Returns the
+// user chosen for every other user in a group, keyed by the other user's ID.
+func findEmailConflicts(users []*model.User) map[string]*model.User {
+	byEmail := map[string][]*model.User{}
+	for _, user := range users {
+		if user.Email == "" {
+			continue
+		}
+		email := erpnext.NormalizeEmail(user.Email)
+		byEmail[email] = append(byEmail[email], user)
+	}
+
+	conflicts := map[string]*model.User{}
+	for _, group := range byEmail {
+		if len(group) < 2 {
+			continue
+		}
+
+		kept := group[0]
+		for _, user := range group[1:] {
+			if user.CreateAt < kept.CreateAt || (user.CreateAt == kept.CreateAt && user.Id < kept.Id) {
+				kept = user
+			}
+		}
+		for _, user := range group {
+			if user.Id != kept.Id {
+				conflicts[user.Id] = kept
+			}
+		}
+	}
+	return conflicts
+}
+
+// employeeHasEmail reports whether any of the employee's email fields holds one of emails,
+// ignoring case and padding
+func employeeHasEmail(employee *erpnext.Employee, emails ...string) bool {
+	for _, candidate := range []string{employee.CompanyEmail, employee.PersonalEmail, employee.UserID} {
+		if candidate == "" {
+			continue
+		}
+		for _, email := range emails {
+			if email != "" && erpnext.NormalizeEmail(candidate) == erpnext.NormalizeEmail(email) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// findEmployeeNumberConflicts finds Mattermost users sharing an employee number when employee
+// numbers are the match key. There is no telling which user the number really belongs to, so
+// every user of a shared number is returned, keyed by ID, with the number.
+func (c *configuration) findEmployeeNumberConflicts(users []*model.User) map[string]string {
+	conflicts := map[string]string{}
+	if c.getSyncMatchKey() != matchKeyEmployeeNumber {
+		return conflicts
+	}
+
+	byNumber := map[string][]*model.User{}
+	for _, user := range users {
+		if number := c.employeeNumberForUser(user); number != "" {
+			byNumber[number] = append(byNumber[number], user)
+		}
+	}
+	for number, group := range byNumber {
+		if len(group) < 2 {
+			continue
+		}
+		for _, user := range group {
+			conflicts[user.Id] = number
+		}
+	}
+	return conflicts
+}
+
+// prepareERPNextInstances makes sure every ERPNext instance has the chat ID field and the default
+// role profile before an mm→erp sync writes to it
+func (p *Plugin) prepareERPNextInstances() error {
+	defaultRoleProfile := p.getConfiguration().getDefaultRoleProfile()
+	for _, instance := range p.erpNextInstances {
+		client := p.erpNextClients[instance.Name]
+
+		if _, err := p.ensureChatIDField(client); err != nil {
+			p.API.LogError("Failed to prepare chat ID field", "instance", instance.Name, "error", err)
+			return errors.Wrapf(err, "ERPNext instance '%s'", instance.Name)
+		}
+
+		if _, err := p.ensureRoleProfile(client, defaultRoleProfile); err != nil {
+			p.API.LogError("Failed to prepare default role profile", "instance", instance.Name, "role_profile", defaultRoleProfile, "error", err)
+			return errors.Wrapf(err, "ERPNext instance '%s'", instance.Name)
+		}
+
+		if sourceField, _ := p.getConfiguration().getCreatedBySource(); sourceField != "" {
+			if _, err := p.ensureCreatedBySourceField(client, sourceField); err != nil {
+				p.API.LogError("Failed to prepare sync source field", "instance", instance.Name, "field", sourceField, "error", err)
+				return errors.Wrapf(err, "ERPNext instance '%s'", instance.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// fetchActiveUsers fetches every active Mattermost user page by page. truncated is set when the
+// MaxUserPages safety bound stopped the fetch before the last page.
+func (p *Plugin) fetchActiveUsers() (users []*model.User, truncated bool, err error) {
+	perPage := 200
+	maxPages := p.getConfiguration().getMaxUserPages()
+	page := 0
+
+	// Fetch all users with pagination
+	for {
+		pageUsers, appErr := p.API.GetUsers(&model.UserGetOptions{
+			Page:    page,
+			PerPage: perPage,
+			Active:  true, // Only fetch active (non-deleted) users
+		})
+		if appErr != nil {
+			p.API.LogError("Failed to fetch users from Mattermost", "error", appErr.Error(), "page", page)
+			return nil, false, errors.Wrap(appErr, "Failed to fetch users")
+		}
+
+		// Add users to our collection
+		users = append(users, pageUsers...)
+
+		p.API.LogInfo(fmt.Sprintf("Fetched page %d: %d users (total so far: %d)", page+1, len(pageUsers), len(users)))
+
+		// If we got fewer users than the page size, we've reached the end
+		if len(pageUsers) < perPage {
+			break
+		}
+
+		page++
+
+		// Safety bound only; a short page normally ends the loop long before this
+		if page >= maxPages {
+			truncated = true
+			p.API.LogError("Reached maximum page limit during user sync, remaining users are NOT synced",
+				"pages_fetched", page,
+				"users_fetched", len(users),
+				"max_pages", maxPages)
+			break
+		}
+	}
+
+	// Users created or deleted while paging shift the pages, so a user can come back twice
+	users, duplicates := dedupeUsersByID(users)
+	if duplicates > 0 {
+		p.API.LogWarn("Dropped Mattermost users returned more than once while paging", "duplicates", duplicates)
+	}
+
+	// Log summary of users fetched
+	p.API.LogInfo(fmt.Sprintf("Fetched %d total users from Mattermost across %d pages", len(users), page+1))
+	return users, truncated, nil
+}
+
+// dedupeUsersByID drops every repeat of a user ID, keeping the first occurrence and the order, and
+// returns how many were dropped
+func dedupeUsersByID(users []*model.User) ([]*model.User, int) {
+	seen := make(map[string]struct{}, len(users))
+	unique := users[:0]
+	for _, user := range users {
+		if _, ok := seen[user.Id]; ok {
+			continue
+		}
+		seen[user.Id] = struct{}{}
+		unique = append(unique, user)
+	}
+	return unique, len(users) - len(unique)
+}
+
+// syncUserToERPNext maps a single Mattermost user onto an ERPNext employee, creating the
+// employee and the ERPNext user when they do not exist yet. With dryRun nothing is written to
+// ERPNext; the result says what would have been done. With batchChatIDs a chat ID that is the
+// only change to an existing employee is returned in PendingChatID instead of written. ERPNext
+// requests are made within ctx.
+func (p *Plugin) syncUserToERPNext(ctx context.Context, user *model.User, dryRun, batchChatIDs bool) recordSyncResult {
+	var res recordSyncResult
+
+	// Skip if user has no email
+	if user.Email == "" {
+		p.API.LogDebug("Skipping user with no email", "username", user.Username)
+		return res.skipped("No Email", fmt.Sprintf("%s (%s) - Skipped (No Email)", user.Username, user.Email))
+	}
+
+	// Skip if user is a bot
+	if user.IsBot {
+		p.API.LogDebug("Skipping bot user", "username", user.Username)
+		return res.skipped("Bot", fmt.Sprintf("%s (%s) - Skipped (Bot)", user.Username, user.Email))
+	}
+
+	// Guests are external collaborators rather than employees unless configured otherwise
+	if user.IsGuest() && !p.getConfiguration().IncludeGuests {
+		p.API.LogDebug("Skipping guest user", "username", user.Username)
+		return res.skipped("Guest", fmt.Sprintf("%s (%s) - Skipped (Guest)", user.Username, user.Email))
+	}
+
+	// Skip if user is deleted
+	if user.DeleteAt > 0 {
+		p.API.LogDebug("Skipping deleted user", "username", user.Username, "deleteAt", user.DeleteAt)
+		return res.skipped("Deleted", fmt.Sprintf("%s (%s) - Skipped (Deleted)", user.Username, user.Email))
+	}
+
+	// Skip service accounts and external users that must never reach ERPNext
+	if p.getConfiguration().isEmailExcluded(user.Email) {
+		p.API.LogDebug("Skipping excluded user", "username", user.Username, "email", user.Email)
+		return res.skipped("Excluded", fmt.Sprintf("%s (%s) - Skipped (Excluded)", user.Username, user.Email))
+	}
+
+	// Skip integration and service accounts that are regular users rather than bots
+	if p.getConfiguration().isUsernameExcluded(user.Username) {
+		p.API.LogDebug("Skipping excluded username", "username", user.Username)
+		return res.skipped("Excluded Username", fmt.Sprintf("%s (%s) - Skipped (Excluded Username)", user.Username, user.Email))
+	}
+
+	// Unverified emails may be typos, so only confirmed addresses become employees
+	if p.getConfiguration().RequireVerifiedEmail && !user.EmailVerified {
+		p.API.LogDebug("Skipping user with unverified email", "username", user.Username)
+		return res.skipped("Unverified Email", fmt.Sprintf("%s (%s) - Skipped (Unverified Email)", user.Username, user.Email))
+	}
+
+	// Users left only in archived teams are no longer active in the organization
+	if p.getConfiguration().SkipArchivedTeamMembers {
+		onlyArchived, err := p.isOnlyInArchivedTeams(user.Id)
+		if err != nil {
+			p.API.LogWarn("Failed to check team memberships, syncing user anyway", "username", user.Username, "error", err.Error())
+		} else if onlyArchived {
+			p.API.LogDebug("Skipping user only in archived teams", "username", user.Username)
+			return res.skipped("Archived Teams Only", fmt.Sprintf("%s (%s) - Skipped (Archived Teams Only)", user.Username, user.Email))
+		}
+	}
+
+	// ERPNext requires a first name on both the employee and the user, so only their creation
+	// needs one; mapping onto existing records doesn't
+	firstName := strings.TrimSpace(user.FirstName)
+	if firstName == "" && p.getConfiguration().DeriveFirstNameFromEmail {
+		firstName = firstNameFromEmail(user.Email)
+	}
+
+	// Route the user to the ERPNext instance serving their email domain
+	client := p.erpNextClientForEmail(user.Email).WithContext(ctx)
+	if client == nil {
+		p.API.LogDebug("Skipping user with no matching ERPNext instance", "username", user.Username, "email", user.Email)
+		return res.skipped("No ERPNext Instance For Domain", fmt.Sprintf("%s (%s) - Skipped (No ERPNext Instance For Domain)", user.Username, user.Email))
+	}
+
+	// Try to find matching employee in ERPNext, by employee number when that is the match key
+	config := p.getConfiguration()
+
+	// Email written to ERPNext records, which differs from user.Email under EmailDomainRewrite
+	erpEmail := config.rewriteEmailDomain(user.Email)
+
+	var employeeNumber string
+	var employee *erpnext.Employee
+	var err error
+	if config.getSyncMatchKey() == matchKeyEmployeeNumber {
+		employeeNumber = config.employeeNumberForUser(user)
+		if employeeNumber == "" {
+			p.API.LogDebug("Skipping user with no employee number", "username", user.Username, "email", user.Email)
+			return res.skipped("No Employee Number", fmt.Sprintf("%s (%s) - Skipped (No Employee Number)", user.Username, user.Email))
+		}
+		employee, err = client.GetEmployeeByNumber(employeeNumber)
+
+		// Users can edit their own props, so a number only maps the user onto an employee that
+		// also carries their email or is already mapped to them
+		if err == nil && employee != nil && employee.CustomChatID != user.Id && !employeeHasEmail(employee, user.Email, erpEmail) {
+			p.API.LogWarn("Employee number matches an employee with a different email, not mapping it",
+				"username", user.Username,
+				"email", user.Email,
+				"employee_number", employeeNumber,
+				"employee_id", employee.Name)
+			return res.skipped("Employee Number Mismatch", fmt.Sprintf("%s (%s) - Skipped (Employee Number Mismatch, employee %s has number %s but a different email)", user.Username, user.Email, employee.Name, employeeNumber))
+		}
+	} else {
+		// Employees recorded under a personal email or linked user still match, avoiding duplicates
+		employee, err = client.GetEmployeeByAnyEmail(user.Email)
+
+		// Records created by an earlier run carry the rewritten email
+		if err == nil && employee == nil && erpEmail != user.Email {
+			employee, err = client.GetEmployeeByAnyEmail(erpEmail)
+		}
+	}
+	if err != nil {
+		p.API.LogError("Error finding employee",
+			"email", user.Email,
+			"employee_number", employeeNumber,
+			"error", err)
+		return res.failed(err, fmt.Sprintf("%s (%s) - Error: %s", user.Username, user.Email, err.Error()))
+	}
+
+	var isNewEmployee bool = false
+
+	// Employee name, status, current image and linked ERPNext user, used once the employee exists
+	var employeeName, employeeStatus, employeeImage, employeeUserID string
+
+	// Phone number to write to the employee when SyncPhoneNumbers is on
+	cellNumber := config.phoneNumberForUser(user)
+
+	if employee != nil {
+		// Employee found - check if we need to update the custom_chat_id, cell number or
+		// onboarded status. The phone number is left alone when ERPNext owns it.
+		updateCellNumber := config.cellNumberForEmployeeUpdate(user)
+		if config.AdditiveOnly && employee.CellNumber != "" {
+			updateCellNumber = ""
+		}
+		desired := &erpnext.Employee{
+			Name:             employee.Name,
+			CustomChatID:     user.Id,
+			CellNumber:       updateCellNumber,
+			StatusFieldValue: config.getOnboardedStatusValue(),
+		}
+		phoneChanged := updateCellNumber != "" && updateCellNumber != employee.CellNumber
+		statusChanged := desired.StatusFieldValue != "" && desired.StatusFieldValue != employee.StatusFieldValue
+		// AdditiveOnly maps an employee once and leaves it alone from then on
+		additiveBlocked := config.AdditiveOnly && employee.CustomChatID != ""
+		if additiveBlocked && employeeNeedsUpdate(employee, desired) {
+			p.API.LogInfo("Skipping employee update in additive only mode", "email", user.Email, "employee_id", employee.Name)
+			res.Notes = append(res.Notes, noteAdditiveOnly)
+		}
+		if !additiveBlocked && p.shouldUpdateEmployee(employee, desired) {
+			if dryRun {
+				res.Outcome = outcomeUpdated
+				return res.finished(fmt.Sprintf("%s (%s) - Would update employee %s", user.Username, user.Email, employee.Name))
+			}
+
+			if batchChatIDs && !phoneChanged && !statusChanged {
+				// Written together with other chat IDs once the batch is full
+				res.PendingChatID = &pendingChatIDWrite{
+					client:       client,
+					employeeName: employee.Name,
+					chatID:       user.Id,
+					email:        user.Email,
+					label:        fmt.Sprintf("%s (%s)", user.Username, user.Email),
+				}
+			} else {
+				// Need to update the custom_chat_id field
+				p.API.LogInfo("Updating custom_chat_id for existing employee",
+					"email", user.Email,
+					"employee_id", employee.Name,
+					"mattermost_id", user.Id)
+
+				// Call API to update the employee
+				_, err := client.UpdateEmployee(desired)
+				if err != nil {
+					p.API.LogError("Failed to update employee custom_chat_id in ERPNext",
+						"email", user.Email,
+						"error", err)
+					return res.failed(err, fmt.Sprintf("%s (%s) - Update Failed: %s", user.Username, user.Email, err.Error()))
+				}
+				if note := p.addSyncComment(client, employee.Name, syncCommentUpdated); note != "" {
+					res.Notes = append(res.Notes, note)
+				}
+				res.ChatIDClaim = &chatIDClaim{client: client, employeeName: employee.Name, chatID: user.Id, label: fmt.Sprintf("%s (%s)", user.Username, user.Email)}
+			}
+
+			res.Outcome = outcomeUpdated
+		} else {
+			// Already mapped correctly
+			res.Outcome = outcomeMatched
+			if dryRun {
+				return res.finished(fmt.Sprintf("%s (%s) - Already Mapped to employee %s", user.Username, user.Email, employee.Name))
+			}
+		}
+		employeeName = employee.Name
+		employeeStatus = employee.Status
+		employeeImage = employee.Image
+		employeeUserID = employee.UserID
+	} else {
+		if firstName == "" {
+			p.API.LogDebug("Skipping user with no first name", "username", user.Username)
+			return res.skipped("No First Name", fmt.Sprintf("%s (%s) - Skipped (No First Name)", user.Username, user.Email))
+		}
+
+		if dryRun {
+			res.Outcome = outcomeCreated
+			return res.finished(fmt.Sprintf("%s (%s) - Would create employee", user.Username, user.Email))
+		}
+
+		// Employee not found - create a new one
+		p.API.LogInfo("Creating new employee for Mattermost user",
+			"username", user.Username,
+			"email", user.Email)
+
+		// Invalid statuses were already reported when the configuration was loaded
+		status, _ := config.getNewEmployeeStatus()
+
+		// Create new employee with fixed values as specified
+		newEmployee := &erpnext.Employee{
+			CompanyEmail:  erpEmail,
+			PersonalEmail: config.personalEmailForUser(user),
+			FirstName:     firstName,
+			LastName:      user.LastName,
+			Gender:        "Male",       // Fixed as specified
+			DateOfBirth:   "2000-01-01", // Fixed as specified
+			DateOfJoining: config.dateOfJoiningForUser(user),
+			Status:        status,
+			CustomChatID:  user.Id, // Store Mattermost ID
+			CellNumber:    cellNumber,
+
+			StatusFieldValue: config.getOnboardedStatusValue(),
+
+			EmployeeNumber: employeeNumber,
+		}
+		// Invalid child tables were already reported when the configuration was loaded
+		newEmployee.ChildTables, _ = config.getEmployeeChildTables()
+
+		// Call API to create the employee
+		createdEmployee, err := client.CreateEmployee(newEmployee)
+
+		// Retry once with configured defaults when ERPNext reports missing mandatory fields
+		var mandatoryErr *erpnext.MandatoryFieldError
+		if errors.As(err, &mandatoryErr) {
+			applied, defaultsErr := p.applyDefaultFieldValues(newEmployee, mandatoryErr.Fields)
+			if defaultsErr != nil {
+				p.API.LogError("Can't retry employee creation with default field values", "email", user.Email, "error", defaultsErr.Error())
+				res.Notes = append(res.Notes, "defaults not applied: "+defaultsErr.Error())
+			}
+			if len(applied) > 0 {
+				p.API.LogInfo("Retrying employee creation with default field values",
+					"email", user.Email,
+					"fields", strings.Join(applied, ", "))
+				res.Notes = append(res.Notes, fmt.Sprintf("defaults applied: %s", strings.Join(applied, ", ")))
+				createdEmployee, err = client.CreateEmployee(newEmployee)
+			}
+		}
+		if err != nil {
+			p.API.LogError("Failed to create employee in ERPNext",
+				"email", user.Email,
+				"error", err)
+			return res.failed(err, fmt.Sprintf("%s (%s) - Creation Failed: %s", user.Username, user.Email, err.Error()))
+		}
+
+		res.Outcome = outcomeCreated
+		isNewEmployee = true
+		employeeName = createdEmployee.Name
+		res.ChatIDClaim = &chatIDClaim{client: client, employeeName: createdEmployee.Name, chatID: user.Id, label: fmt.Sprintf("%s (%s)", user.Username, user.Email)}
+		employeeStatus = status
+		if note := p.addSyncComment(client, createdEmployee.Name, syncCommentCreated); note != "" {
+			res.Notes = append(res.Notes, note)
+		}
+
+		// ERPNext composes employee_name itself, which may not match our first and last name
+		if composed := createdEmployee.EmployeeName; composed != "" && composed != localFullName(firstName, user.LastName) {
+			res.Notes = append(res.Notes, fmt.Sprintf("ERPNext named the employee %q", composed))
+		}
+	}
+
+	// Push the Mattermost profile picture, unless ERPNext owns the image of existing employees;
+	// problems with the image are noted but never fail the user
+	pushImage := isNewEmployee || (config.writesField(fieldImage, directionMMToERP) && (!config.AdditiveOnly || employeeImage == ""))
+	if config.PushProfileImages && employeeName != "" && pushImage {
+		if note := p.pushProfileImageToERPNext(client, user, employeeName, employeeImage); note != "" {
+			res.Notes = append(res.Notes, note)
+		}
+	}
+
+	// Now check if ERPNext user exists for this employee
+	p.API.LogInfo("Checking if ERPNext user exists for employee", "email", user.Email)
+
+	erpUser, err := client.GetUserByEmail(user.Email)
+	if err == nil && erpUser == nil && erpEmail != user.Email {
+		erpUser, err = client.GetUserByEmail(erpEmail)
+	}
+	if err != nil {
+		p.API.LogError("Error checking ERPNext user by email", "email", user.Email, "error", err)
+		// Continue with the next user instead of failing completely
+		if isNewEmployee {
+			res.Message = fmt.Sprintf("%s (%s) - Employee Created, User Check Failed: %s", user.Username, user.Email, err.Error())
+		} else {
+			res.Message = fmt.Sprintf("%s (%s) - Employee Updated, User Check Failed: %s", user.Username, user.Email, err.Error())
+		}
+		res.Err = err
+		return res
+	}
+
+	var erpUserName string
+	if erpUser != nil {
+		// ERPNext user already exists
+		erpUserName = erpUser.Name
+		res.ERPUser = erpUserExisted
+		if isNewEmployee {
+			res.Message = fmt.Sprintf("%s (%s) - Employee Created, ERPNext User Already Exists", user.Username, user.Email)
+		} else {
+			res.Message = fmt.Sprintf("%s (%s) - Already Mapped, ERPNext User Exists", user.Username, user.Email)
+		}
+	} else if employeeStatus != "" && employeeStatus != "Active" && p.getConfiguration().getNonActiveEmployeeUserPolicy() == nonActiveUserSkip {
+		// Don't grant a login to staff that already left or are otherwise not active
+		p.API.LogInfo("Skipping ERPNext user for non-active employee",
+			"email", user.Email,
+			"employee_id", employeeName,
+			"status", employeeStatus)
+		res.ERPUser = erpUserSkipped
+		if isNewEmployee {
+			res.Message = fmt.Sprintf("%s (%s) - Employee Created, ERPNext User Skipped (Employee %s)", user.Username, user.Email, employeeStatus)
+		} else {
+			res.Message = fmt.Sprintf("%s (%s) - Already Mapped, ERPNext User Skipped (Employee %s)", user.Username, user.Email, employeeStatus)
+		}
+	} else if firstName == "" {
+		// Only reached for existing employees, new ones always have a first name
+		p.API.LogInfo("Skipping ERPNext user for user with no first name", "email", user.Email, "employee_id", employeeName)
+		res.ERPUser = erpUserSkipped
+		res.Message = fmt.Sprintf("%s (%s) - Already Mapped, ERPNext User Skipped (No First Name)", user.Username, user.Email)
+	} else {
+		// Need to create ERPNext user
+		p.API.LogInfo("Creating ERPNext user for employee", "email", user.Email)
+
+		// Generate username from email (take part before @)
+		emailParts := strings.Split(user.Email, "@")
+		username := emailParts[0]
+		if len(username) == 0 {
+			username = fmt.Sprintf("user_%s", user.Id[:8]) // Fallback to partial Mattermost ID
+		}
+		if p.getConfiguration().UniqueERPNextUsernames {
+			username = p.uniqueERPNextUsername(client, username)
+		}
+
+		newERPUser := &erpnext.User{
+			Email:            erpEmail,
+			FirstName:        firstName,
+			LastName:         user.LastName,
+			Username:         username,
+			Enabled:          1, // 1 for enabled
+			RoleProfileName:  p.getConfiguration().roleProfileForUser(user),
+			SendWelcomeEmail: 0, // No ERPNext welcome email
+		}
+		// Reserved fields were already dropped and reported when the configuration was loaded
+		newERPUser.ExtraFields, _ = p.getConfiguration().getERPNextUserExtraFields()
+		newERPUser.NamingSeries = strings.TrimSpace(p.getConfiguration().ERPNextUserNamingSeries)
+
+		// Mark the user as created by the sync, so ERPNext admins can tell it from manual accounts
+		if sourceField, sourceValue := p.getConfiguration().getCreatedBySource(); sourceField != "" {
+			newERPUser.ExtraFields[sourceField] = sourceValue
+		}
+
+		// Non-active employees may get a disabled login instead, ready to be enabled if they return
+		if employeeStatus != "" && employeeStatus != "Active" && p.getConfiguration().getNonActiveEmployeeUserPolicy() == nonActiveUserDisabled {
+			newERPUser.Enabled = 0
+			res.Notes = append(res.Notes, fmt.Sprintf("ERPNext user created disabled (employee %s)", employeeStatus))
+		}
+
+		createdERPUser, err := client.CreateUser(newERPUser)
+		var seriesErr *erpnext.NamingSeriesError
+		if errors.As(err, &seriesErr) {
+			err = errors.Wrap(err, "set ERPNext User Naming Series in the plugin settings")
+		}
+		if err != nil {
+			p.API.LogError("Failed to create ERPNext user", "email", user.Email, "error", err)
+			if isNewEmployee {
+				res.Message = fmt.Sprintf("%s (%s) - Employee Created, ERPNext User Creation Failed: %s", user.Username, user.Email, err.Error())
+			} else {
+				res.Message = fmt.Sprintf("%s (%s) - Employee Updated, ERPNext User Creation Failed: %s", user.Username, user.Email, err.Error())
+			}
+			res.Err = err
+			return res
+		}
+
+		erpUserName = createdERPUser.Name
+		res.ERPUser = erpUserCreated
+		if p.getConfiguration().VerifyRoleProfile && newERPUser.RoleProfileName != "" {
+			if note := p.verifyRoleProfile(client, erpUserName, newERPUser.RoleProfileName); note != "" {
+				res.Notes = append(res.Notes, note)
+			}
+		}
+		if isNewEmployee {
+			res.Message = fmt.Sprintf("%s (%s) - Employee & ERPNext User Created", user.Username, user.Email)
+		} else {
+			res.Message = fmt.Sprintf("%s (%s) - Employee Updated, ERPNext User Created", user.Username, user.Email)
+		}
+	}
+
+	// Link the employee to the ERPNext user so the HR module associates the login with it; a
+	// failed link is noted but doesn't fail the user. A link HR already set is never replaced,
+	// a different one is only reported.
+	if employeeName != "" && erpUserName != "" && employeeUserID != erpUserName {
+		if employeeUserID != "" {
+			res.Notes = append(res.Notes, fmt.Sprintf("employee linked to ERPNext user %s, not %s; link left unchanged", employeeUserID, erpUserName))
+		} else if err := client.LinkEmployeeToUser(employeeName, erpUserName); err != nil {
+			p.API.LogError("Failed to link employee to ERPNext user",
+				"employee_id", employeeName,
+				"erp_user", erpUserName,
+				"error", err)
+			res.Notes = append(res.Notes, "user link failed: "+err.Error())
+		} else {
+			res.Notes = append(res.Notes, "linked to ERPNext user "+erpUserName)
+		}
+	}
+
+	return res
+}
+
+// syncEmployeeToMattermost maps a single ERPNext employee onto a Mattermost user, creating
+// the Mattermost user when no account with the employee's email exists. ERPNext requests and
+// retries are made within ctx.
+func (p *Plugin) syncEmployeeToMattermost(ctx context.Context, employee erpnext.Employee, limits *employeeSyncLimits) recordSyncResult {
+	var res recordSyncResult
+	client := p.erpNextClient.WithContext(ctx)
+
+	// Stray whitespace or capitals would keep the email from matching its Mattermost user and
+	// lead to a duplicate account, so match on the normalized email and flag it for cleanup
+	email, padded := normalizeEmployeeEmail(employee.CompanyEmail)
+	if padded {
+		p.API.LogWarn("Employee company email has surrounding whitespace", "employee_id", employee.Name, "email", email)
+		res.Notes = append(res.Notes, "company_email has surrounding whitespace, clean it up in ERPNext")
+	}
+	employee.CompanyEmail = email
+
+	// Mapped accounts of departed employees are found by chat ID, so their email doesn't matter
+	if employee.Status != "Active" && employee.CustomChatID != "" && p.getConfiguration().getInactiveEmployeeMode() == inactiveEmployeeDeactivate {
+		return p.deactivateInactiveEmployeeUser(ctx, employee, res, limits)
+	}
+
+	if email != "" && !model.IsValidEmail(email) {
+		p.API.LogWarn("Skipping employee with malformed company email", "employee_id", employee.Name, "email", email)
+		return res.skipped("Malformed Email", fmt.Sprintf("%s %s (%s) - Skipped (Malformed Email %q, clean it up in ERPNext)", employee.FirstName, employee.LastName, employee.Name, email))
+	}
+
+	// Skip if employee has no company email, unless they may still be matched by name
+	if employee.CompanyEmail == "" && !p.getConfiguration().MatchByName {
+		p.API.LogDebug("Skipping employee with no company email", "employee_id", employee.Name)
+		return res.skipped("No Email", fmt.Sprintf("%s %s (%s) - Skipped (No Email)", employee.FirstName, employee.LastName, employee.Name))
+	}
+
+	// Skip if employee status is not Active
+	if employee.Status != "Active" {
+		p.API.LogDebug("Skipping inactive employee", "employee_id", employee.Name, "status", employee.Status)
+		return res.skipped("Inactive", fmt.Sprintf("%s %s (%s) - Skipped (Inactive)", employee.FirstName, employee.LastName, employee.Name))
+	}
+
+	// Check if this employee already has a Mattermost account mapped
+	if employee.CustomChatID != "" {
+		// Check if the user still exists in Mattermost
+		user, appErr := p.API.GetUser(employee.CustomChatID)
+		if appErr == nil && user != nil && user.DeleteAt == 0 {
+			// User exists and is not deleted
+			res.Outcome = outcomeMatched
+			if note := p.syncPhoneNumberToMattermost(user, employee); note != "" {
+				res.Notes = append(res.Notes, note)
+			}
+			marked, err := p.markEmployeeOnboarded(client, employee)
+			if err != nil {
+				return res.failed(err, fmt.Sprintf("%s %s (%s) - Already Mapped, Onboarded Status Update Failed: %s", employee.FirstName, employee.LastName, employee.CompanyEmail, err.Error()))
+			}
+			if marked {
+				res.Notes = append(res.Notes, "onboarded status set")
+			}
+			return res.finished(fmt.Sprintf("%s %s (%s) - Already Mapped", employee.FirstName, employee.LastName, employee.CompanyEmail))
+		}
+
+		// If we get here, the mapped user doesn't exist or is deleted
+		// We'll try to find a user by email or create a new one
+		p.API.LogDebug("Mapped user no longer exists, will search for existing or create new",
+			"employee_email", employee.CompanyEmail, "old_user_id", employee.CustomChatID)
+
+		// Remapping would overwrite the employee's existing chat ID
+		if p.getConfiguration().AdditiveOnly {
+			return res.skipped(skipReasonAdditiveOnly, fmt.Sprintf("%s %s (%s) - Skipped (Additive Only, mapped user is gone and remapping would update the employee)", employee.FirstName, employee.LastName, employee.CompanyEmail))
+		}
+	}
+
+	// Try multiple approaches to find a Mattermost user with the same email
+	var existingUser *model.User = nil
+	var appErr *model.AppError = nil
+
+	// First try: use GetUserByEmail which is most reliable for exact email matching
+	if employee.CompanyEmail != "" {
+		existingUser, appErr = p.API.GetUserByEmail(employee.CompanyEmail)
+	}
+
+	// If direct email lookup failed, try search as a fallback
+	if employee.CompanyEmail != "" && (appErr != nil || existingUser == nil) {
+		p.API.LogDebug("Direct email lookup failed, trying search", "email", employee.CompanyEmail, "error", appErr)
+
+		// Try searching with broader criteria
+		userSearchOpts := &model.UserSearch{
+			AllowInactive: false,
+			Term:          employee.CompanyEmail,
+			Limit:         10, // Increased limit to catch more potential matches
+		}
+
+		userList, searchErr := p.API.SearchUsers(userSearchOpts)
+
+		if searchErr == nil && len(userList) > 0 {
+			// Look for exact email match in search results
+			for _, user := range userList {
+				if strings.EqualFold(user.Email, employee.CompanyEmail) && user.DeleteAt == 0 {
+					existingUser = user
+					p.API.LogInfo("Found user by search", "user_id", user.Id, "email", user.Email)
+					break
+				}
+			}
+		}
+	}
+
+	// Last resort when no user has the email: a single user with exactly the same
+	// accent-normalized name. Users already mapped to another employee are no candidates, and
+	// several candidates are left for an admin rather than guessed. A deactivated user with the
+	// email is the employee's own account, never replaced by a namesake.
+	matchedByName := false
+	if existingUser == nil && p.getConfiguration().MatchByName {
+		candidates, err := p.findUsersByName(employee.FirstName, employee.LastName)
+		if err != nil {
+			p.API.LogWarn("Failed to search users by name", "employee_id", employee.Name, "error", err.Error())
+		}
+		candidates, err = p.excludeUsersMappedElsewhere(client, employee.Name, candidates)
+		if err != nil {
+			p.API.LogError("Failed to check the name matches for existing mappings", "employee_id", employee.Name, "error", err.Error())
+			return res.failed(err, fmt.Sprintf("%s %s (%s) - Error: %s", employee.FirstName, employee.LastName, employee.Name, err.Error()))
+		}
+
+		switch len(candidates) {
+		case 0:
+		case 1:
+			existingUser = candidates[0]
+			matchedByName = true
+			p.API.LogInfo("Matched employee to user by name", "employee_id", employee.Name, "user_id", existingUser.Id)
+		default:
+			usernames := make([]string, 0, len(candidates))
+			for _, candidate := range candidates {
+				usernames = append(usernames, candidate.Username)
+			}
+			p.API.LogWarn("Several users match employee name, needs manual review",
+				"employee_id", employee.Name,
+				"candidates", strings.Join(usernames, ", "))
+			return res.skipped(skipReasonAmbiguousName, fmt.Sprintf("%s %s (%s) - Skipped (Ambiguous name match, review manually: %s)",
+				employee.FirstName, employee.LastName, employee.Name, strings.Join(usernames, ", ")))
+		}
+	}
+
+	// Reactivating or replacing the employee's own deactivated account is left to an admin
+	if existingUser != nil && existingUser.DeleteAt != 0 {
+		p.API.LogInfo("Skipping employee whose Mattermost user is deactivated", "employee_id", employee.Name, "user_id", existingUser.Id)
+		return res.skipped("Deactivated User", fmt.Sprintf("%s %s (%s) - Skipped (Mattermost user %s is deactivated)", employee.FirstName, employee.LastName, employee.CompanyEmail, existingUser.Username))
+	}
+
+	// Employees without an email can only be mapped by name
+	if employee.CompanyEmail == "" && existingUser == nil {
+		p.API.LogDebug("Skipping employee with no company email and no name match", "employee_id", employee.Name)
+		return res.skipped("No Email", fmt.Sprintf("%s %s (%s) - Skipped (No Email)", employee.FirstName, employee.LastName, employee.Name))
+	}
+
+	// Found existing user with matching email
+	if existingUser != nil && existingUser.DeleteAt == 0 {
+		// Update the employee's custom_chat_id in ERPNext
+		updatedEmployee := &erpnext.Employee{
+			Name:             employee.Name,
+			CustomChatID:     existingUser.Id,
+			StatusFieldValue: p.getConfiguration().getOnboardedStatusValue(),
+		}
+
+		// The chat ID may already point at this user, e.g. when the earlier lookup of the mapped
+		// user failed transiently
+		if !p.shouldUpdateEmployee(&employee, updatedEmployee) {
+			res.Outcome = outcomeMatched
+			if note := p.syncPhoneNumberToMattermost(existingUser, employee); note != "" {
+				res.Notes = append(res.Notes, note)
+			}
+			marked, err := p.markEmployeeOnboarded(client, employee)
+			if err != nil {
+				return res.failed(err, fmt.Sprintf("%s %s (%s) - Already Mapped, Onboarded Status Update Failed: %s", employee.FirstName, employee.LastName, employee.CompanyEmail, err.Error()))
+			}
+			if marked {
+				res.Notes = append(res.Notes, "onboarded status set")
+			}
+			return res.finished(fmt.Sprintf("%s %s (%s) - Already Mapped", employee.FirstName, employee.LastName, employee.CompanyEmail))
+		}
+
+		_, err := client.UpdateEmployee(updatedEmployee)
+		if err != nil {
+			p.API.LogError("Failed to update employee custom_chat_id in ERPNext",
+				"employee_id", employee.Name,
+				"error", err)
+			return res.failed(err, fmt.Sprintf("%s %s (%s) - Update Failed: %s", employee.FirstName, employee.LastName, employee.CompanyEmail, err.Error()))
+		}
+		if note := p.addSyncComment(client, employee.Name, syncCommentUpdated); note != "" {
+			res.Notes = append(res.Notes, note)
+		}
+		res.ChatIDClaim = &chatIDClaim{client: client, employeeName: employee.Name, chatID: existingUser.Id, label: fmt.Sprintf("%s %s (%s)", employee.FirstName, employee.LastName, employee.CompanyEmail)}
+
+		res.Outcome = outcomeUpdated
+		res.Message = fmt.Sprintf("%s %s (%s) - Mapped to existing user", employee.FirstName, employee.LastName, employee.CompanyEmail)
+		if matchedByName {
+			res.Message = fmt.Sprintf("%s %s (%s) - Mapped to existing user %s by name", employee.FirstName, employee.LastName, employee.Name, existingUser.Username)
+		}
+		if note := p.syncPhoneNumberToMattermost(existingUser, employee); note != "" {
+			res.Notes = append(res.Notes, note)
+		}
+	} else {
+		// The run already created as many accounts as MaxNewAccountsPerRun allows. The slot is
+		// kept once the account exists, whatever happens to the record afterwards.
+		if !limits.newAccountSlot() {
+			return res.skipped(skipReasonCreationLimit, fmt.Sprintf("%s %s (%s) - Skipped (New account limit reached)", employee.FirstName, employee.LastName, employee.CompanyEmail))
+		}
+		accountCreated := false
+		defer func() {
+			if !accountCreated {
+				limits.releaseNewAccountSlot()
+			}
+		}()
+
+		// Need to create a new Mattermost user
+		p.API.LogInfo("Creating new Mattermost user for ERPNext employee",
+			"employee_name", employeeDisplayName(employee),
+			"email", employee.CompanyEmail)
+
+		// In deterministic mode any fallback parts come from the employee, so a re-run proposes
+		// the same username for the same person
+		usernameSeed := ""
+		if p.getConfiguration().DeterministicUsernames {
+			usernameSeed = strings.ToLower(employee.CompanyEmail) + ":" + employee.Name
+		}
+
+		// Generate username from name (slug of employee name)
+		baseUsername := p.ensureValidUsername(p.generateUsername(employee.FirstName, employee.LastName, usernameSeed), usernameSeed)
+		username := baseUsername
+
+		// Check if username already exists and make it unique if needed
+		for retries := 0; retries < 5; retries++ {
+			_, userErr := p.API.GetUserByUsername(username)
+			if userErr != nil {
+				// Username doesn't exist, we can use it
+				break
+			}
+			// Username exists, add a suffix
+			username = fmt.Sprintf("%s_%d", baseUsername, retries+1)
+		}
+
+		// SSO accounts authenticate through the identity provider, so they get no password
+		authService := p.getConfiguration().getCreatedUserAuthService()
+
+		// Generate random password
+		password := ""
+		if authService == "" {
+			password = p.GenerateRandomPassword(12)
+		}
+
+		// Fall back to the configured last name when ERPNext has none
+		lastName := employee.LastName
+		if lastName == "" {
+			lastName = p.getConfiguration().DefaultLastName
+		}
+
+		// Create new user with enhanced error handling
+		newUser := &model.User{
+			Email:         employee.CompanyEmail,
+			Username:      username,
+			Password:      password,
+			EmailVerified: true,
+			FirstName:     employee.FirstName,
+			LastName:      lastName,
+		}
+		if authService != "" {
+			authData := employee.CompanyEmail
+			newUser.AuthService = authService
+			newUser.AuthData = &authData
+		}
+		if config := p.getConfiguration(); config.SyncPhoneNumbers {
+			if number, ok := normalizePhoneNumber(employee.CellNumber); ok {
+				newUser.SetProp(config.getPhoneNumberAttribute(), number)
+			}
+		}
+		if config := p.getConfiguration(); config.SyncBranch {
+			if branch := strings.TrimSpace(employee.Branch); branch != "" {
+				newUser.SetProp(config.getBranchAttribute(), branch)
+			}
+		}
+
+		// Transient server errors are retried with backoff inside createUserWithRetry
+		createdUser, createRetries, appErr := p.createUserWithRetry(ctx, newUser)
+		if appErr != nil {
+			p.API.LogError("Failed to create Mattermost user",
+				"email", employee.CompanyEmail,
+				"username", username,
+				"retries", createRetries,
+				"error", appErr.Error())
+
+			// Try with a different username if it's a username conflict
+			if strings.Contains(appErr.Error(), "username") {
+				// Generate a more unique username
+				var uniqueUsername string
+				if usernameSeed != "" {
+					uniqueUsername = fmt.Sprintf("%s_%s", username, p.seededString(usernameSeed, "conflict", 4))
+				} else {
+					timestamp := time.Now().Unix()
+					uniqueUsername = fmt.Sprintf("%s_%d", username, timestamp%10000)
+				}
+				uniqueUsername = p.ensureValidUsername(uniqueUsername, usernameSeed)
+				newUser.Username = uniqueUsername
+
+				var conflictRetries int
+				createdUser, conflictRetries, appErr = p.createUserWithRetry(ctx, newUser)
+				createRetries += conflictRetries
+				if appErr != nil {
+					return res.failed(appErr, fmt.Sprintf("%s %s (%s) - User Creation Failed (retry, %d transient retries): %s", employee.FirstName, employee.LastName, employee.CompanyEmail, createRetries, appErr.Error()))
+				}
+				username = uniqueUsername // Update for the response
+			} else {
+				return res.failed(appErr, fmt.Sprintf("%s %s (%s) - User Creation Failed after %d retries: %s", employee.FirstName, employee.LastName, employee.CompanyEmail, createRetries, appErr.Error()))
+			}
+		}
+
+		accountCreated = true
+
+		// Mark the account as provisioned by the plugin so it can be found and cleaned up later,
+		// also when the ERPNext update below fails
+		if p.getConfiguration().TagCreatedUsers {
+			p.tagCreatedUser(createdUser.Id, employee.Name)
+		}
+
+		// Update the employee's custom_chat_id in ERPNext
+		updatedEmployee := &erpnext.Employee{
+			Name:             employee.Name,
+			CustomChatID:     createdUser.Id,
+			StatusFieldValue: p.getConfiguration().getOnboardedStatusValue(),
+		}
+
+		_, err := client.UpdateEmployee(updatedEmployee)
+		if err != nil {
+			p.API.LogError("Failed to update employee custom_chat_id in ERPNext after user creation",
+				"employee_id", employee.Name,
+				"user_id", createdUser.Id,
+				"error", err)
+			return res.failed(err, fmt.Sprintf("%s %s (%s) - User Created but Update Failed: %s", employee.FirstName, employee.LastName, employee.CompanyEmail, err.Error()))
+		}
+		if note := p.addSyncComment(client, employee.Name, syncCommentUpdated); note != "" {
+			res.Notes = append(res.Notes, note)
+		}
+		res.ChatIDClaim = &chatIDClaim{client: client, employeeName: employee.Name, chatID: createdUser.Id, label: fmt.Sprintf("%s %s (%s)", employee.FirstName, employee.LastName, employee.CompanyEmail)}
+
+		// Bring over the ERPNext photo; problems with the image are noted but never fail the user
+		if p.getConfiguration().SyncProfileImages {
+			if note := p.syncProfileImageFromERPNext(client, createdUser.Id, employee); note != "" {
+				res.Notes = append(res.Notes, note)
+			}
+		}
+
+		// Greet the new user with getting-started info from the plugin bot
+		if p.getConfiguration().WelcomeMessageEnabled {
+			if p.SendWelcomeMessage(createdUser) {
+				res.Notes = append(res.Notes, "welcome message sent")
+			} else {
+				res.Notes = append(res.Notes, "welcome message failed")
+			}
+		}
+
+		retryStatus := ""
+		if createRetries > 0 {
+			retryStatus = fmt.Sprintf(" (after %d retries)", createRetries)
+		}
+
+		res.Outcome = outcomeCreated
+
+		// SSO users sign in through the identity provider, there are no credentials to send
+		if authService != "" {
+			res.Message = fmt.Sprintf("%s %s (%s) - New User Created%s (SSO: %s)\nUsername: %s",
+				employee.FirstName, employee.LastName, employee.CompanyEmail,
+				retryStatus, authService, username)
+			return res
+		}
+
+		// Attempt to send email notification with credentials
+		emailErr := p.SendCredentialEmail(employee.CompanyEmail, username, password)
+
+		// Add credentials to result details with email status
+		emailStatus := ""
+		if emailErr == nil {
+			emailStatus = " (Email sent)"
+		} else if errors.Is(emailErr, errEmailSuppressed) {
+			emailStatus = " (Email suppressed by allowlist)"
+		} else if p.queueCredentialEmailRetry(createdUser.Id, employee.CompanyEmail, emailErr) {
+			emailStatus = " (Email failed, queued for retry)"
+			res.PendingEmail = username
+		} else {
+			emailStatus = " (Email delivery attempted)"
+		}
+
+		res.Message = fmt.Sprintf("%s %s (%s) - New User Created%s%s\nUsername: %s\nPassword: %s",
+			employee.FirstName, employee.LastName, employee.CompanyEmail,
+			retryStatus, emailStatus, username, password)
+	}
+
+	return res
+}
+
+// applyDefaultFieldValues fills the given missing fields on an employee from the configured
+// DefaultFieldValues. Returns the names of the fields that received a default, or the error when
+// DefaultFieldValues is invalid.
+func (p *Plugin) applyDefaultFieldValues(employee *erpnext.Employee, missingFields []string) ([]string, error) {
+	defaults, err := p.getConfiguration().getDefaultFieldValues()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []string
+	for _, field := range missingFields {
+		value, ok := defaults[field]
+		if !ok {
+			continue
+		}
+		if employee.ExtraFields == nil {
+			employee.ExtraFields = map[string]interface{}{}
+		}
+		employee.ExtraFields[field] = value
+		applied = append(applied, field)
+	}
+
+	return applied, nil
+}
+
+// employeeNeedsUpdate reports whether writing desired would change any field UpdateEmployee
+// writes on existing. Fields left empty in desired aren't written and so aren't compared.
+func employeeNeedsUpdate(existing, desired *erpnext.Employee) bool {
+	if existing == nil {
+		return true
+	}
+	if existing.CustomChatID != desired.CustomChatID {
+		return true
+	}
+	if desired.CellNumber != "" && existing.CellNumber != desired.CellNumber {
+		return true
+	}
+	if desired.StatusFieldValue != "" && existing.StatusFieldValue != desired.StatusFieldValue {
+		return true
+	}
+	return false
+}
+
+// markEmployeeOnboarded sets the configured onboarded status field on an employee whose
+// Mattermost user was confirmed live and mapped. Returns true when the employee was written.
+func (p *Plugin) markEmployeeOnboarded(client *erpnext.Client, employee erpnext.Employee) (bool, error) {
+	value := p.getConfiguration().getOnboardedStatusValue()
+	if value == "" {
+		return false, nil
+	}
+
+	// The employee is already mapped, so setting the status would update it
+	if p.getConfiguration().AdditiveOnly {
+		return false, nil
+	}
+
+	desired := &erpnext.Employee{
+		Name:             employee.Name,
+		CustomChatID:     employee.CustomChatID,
+		StatusFieldValue: value,
+	}
+	if !p.shouldUpdateEmployee(&employee, desired) {
+		return false, nil
+	}
+
+	if _, err := client.UpdateEmployee(desired); err != nil {
+		p.API.LogError("Failed to set onboarded status on employee", "employee_id", employee.Name, "error", err.Error())
+		return false, err
+	}
+	p.addSyncComment(client, employee.Name, syncCommentUpdated)
+	return true, nil
+}
+
+// shouldUpdateEmployee gates employee updates on employeeNeedsUpdate, so repeated syncs don't
+// send needless writes to ERPNext, unless AlwaysUpdateEmployees is set
+func (p *Plugin) shouldUpdateEmployee(existing, desired *erpnext.Employee) bool {
+	if p.getConfiguration().AlwaysUpdateEmployees {
+		return true
+	}
+	return employeeNeedsUpdate(existing, desired)
+}
+
+// isOnlyInArchivedTeams reports whether every team the user belongs to is archived. Users in no
+// team at all are not affected.
+func (p *Plugin) isOnlyInArchivedTeams(userID string) (bool, error) {
+	members, err := p.client.Team.ListMembersForUser(userID, 0, 200)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to list team memberships")
+	}
+
+	teamCount := 0
+	for _, member := range members {
+		if member.DeleteAt != 0 {
+			continue
+		}
+		teamCount++
+
+		team, err := p.client.Team.Get(member.TeamId)
+		if err != nil {
+			return false, errors.Wrapf(err, "failed to get team %s", member.TeamId)
+		}
+		if team.DeleteAt == 0 {
+			return false, nil
+		}
+	}
+	return teamCount > 0, nil
+}
+
+// syncPhoneNumberToMattermost copies the employee's cell number to the user's phone number
+// attribute when SyncPhoneNumbers is on and they differ. Returns a note for the result, or ""
+// when nothing changed.
+func (p *Plugin) syncPhoneNumberToMattermost(user *model.User, employee erpnext.Employee) string {
+	config := p.getConfiguration()
+	if !config.SyncPhoneNumbers || employee.CellNumber == "" {
+		return ""
+	}
+
+	// Mattermost owns the phone number, so the mm→erp sync writes it and this one mustn't revert it
+	if !config.writesField(fieldPhone, directionERPToMM) {
+		return ""
+	}
+
+	number, ok := normalizePhoneNumber(employee.CellNumber)
+	if !ok {
+		return "invalid cell number not synced"
+	}
+
+	attribute := config.getPhoneNumberAttribute()
+	current, _ := user.GetProp(attribute)
+	if current == number {
+		return ""
+	}
+
+	// A phone number may be added but never replaced in additive only mode
+	if config.AdditiveOnly && current != "" {
+		return "phone number " + noteAdditiveOnly
+	}
+
+	user.SetProp(attribute, number)
+	if _, appErr := p.API.UpdateUser(user); appErr != nil {
+		p.API.LogError("Failed to update Mattermost user phone number",
+			"user_id", user.Id,
+			"error", appErr.Error())
+		return "phone number update failed: " + appErr.Error()
+	}
+	return "phone number updated"
+}
+
+// normalizeEmployeeEmail returns an employee's company email in the form it is matched in, and
+// whether ERPNext stores it with surrounding whitespace that should be cleaned up
+func normalizeEmployeeEmail(raw string) (string, bool) {
+	return erpnext.NormalizeEmail(raw), strings.TrimSpace(raw) != raw
+}
+
+// firstNameFromEmail derives a first name from the local part of an email address, e.g.
+// "jane.doe@example.com" becomes "Jane Doe". Returns "" when nothing usable is left.
+func firstNameFromEmail(email string) string {
+	local := email
+	if at := strings.LastIndex(email, "@"); at >= 0 {
+		local = email[:at]
+	}
+
+	words := strings.FieldsFunc(local, func(r rune) bool {
+		return r == '.' || r == '_' || r == '-' || r == '+'
+	})
+	for i, word := range words {
+		runes := []rune(word)
+		words[i] = strings.ToUpper(string(runes[0])) + string(runes[1:])
+	}
+	return strings.Join(words, " ")
+}
+
+// recordSyncFailure adds a failed record to the dead-letter list so it can be retried later
+func (p *Plugin) recordSyncFailure(direction, email string, cause error) {
+	if p.kvstore == nil || email == "" || cause == nil {
+		return
+	}
+
+	entry := kvstore.FailedEntry{
+		Email:     email,
+		Error:     cause.Error(),
+		Direction: direction,
+		FailedAt:  model.GetMillis(),
+	}
+	if err := p.kvstore.AddFailedEntry(entry); err != nil {
+		p.API.LogError("Failed to store failed sync entry", "email", email, "direction", direction, "error", err)
+	}
+}
+
+// recordCompletedSync adds the run to the sync history and moves the direction's watermark to the
+// start of this run, but only when the run covered every record: a timed-out, aborted,
+// truncated or targeted run leaves the watermark alone and isn't a full sync
+func (p *Plugin) recordCompletedSync(direction string, startedAt time.Time, result *syncresult.Result) {
+	if p.kvstore == nil {
+		return
+	}
+
+	complete := !result.TimedOut && !result.Aborted && !result.Truncated && !result.Targeted
+
+	// Every run goes into the history used by /erpsync syncstats
+	if err := p.kvstore.AddSyncRun(kvstore.SyncRun{
+		Direction:     direction,
+		CorrelationID: result.CorrelationID,
+		StartedAt:     startedAt.UnixMilli(),
+		DurationMs:    time.Since(startedAt).Milliseconds(),
+		Matched:       result.MatchedCount,
+		Updated:       result.UpdatedCount,
+		Created:       result.CreatedCount,
+		Skipped:       result.SkippedCount,
+		Failed:        result.FailedCount,
+		Complete:      complete,
+		Targeted:      result.Targeted,
+	}); err != nil {
+		p.API.LogError("Failed to save sync run history", "direction", direction, "error", err.Error())
+	}
+
+	if !complete {
+		return
+	}
+	if err := p.kvstore.SetLastSync(direction, startedAt); err != nil {
+		p.API.LogError("Failed to save last sync time", "direction", direction, "error", err.Error())
+	}
+}
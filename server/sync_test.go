@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/erpnext"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeEmployeeEmail(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		raw      string
+		expected string
+		padded   bool
+	}{
+		{name: "clean email is kept", raw: "jane.doe@example.com", expected: "jane.doe@example.com"},
+		{name: "mixed case is lowered", raw: "Jane.Doe@Example.COM", expected: "jane.doe@example.com"},
+		{name: "trailing space is trimmed", raw: "jane.doe@example.com ", expected: "jane.doe@example.com", padded: true},
+		{name: "padded mixed case", raw: "\t Jane.Doe@Example.com\n", expected: "jane.doe@example.com", padded: true},
+		{name: "empty", raw: "", expected: ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			email, padded := normalizeEmployeeEmail(tc.raw)
+			assert.Equal(t, tc.expected, email)
+			assert.Equal(t, tc.padded, padded)
+		})
+	}
+}
+
+func TestFindEmailConflictsIgnoresPaddingAndCase(t *testing.T) {
+	users := []*model.User{
+		{Id: "a", Username: "admin", Email: "jane@example.com"},
+		{Id: "b", Username: "jane", Email: " Jane@Example.com "},
+		{Id: "c", Username: "john", Email: "john@example.com"},
+	}
+
+	conflicts := findEmailConflicts(users)
+	assert.Len(t, conflicts, 1)
+	assert.Contains(t, conflicts, "b")
+}
+
+func TestDedupeUsersByID(t *testing.T) {
+	users := []*model.User{
+		{Id: "a", Username: "alice"},
+		{Id: "b", Username: "bob"},
+		{Id: "a", Username: "alice"},
+		{Id: "c", Username: "carol"},
+		{Id: "b", Username: "bob"},
+	}
+
+	unique, duplicates := dedupeUsersByID(users)
+	assert.Equal(t, 2, duplicates)
+	assert.Equal(t, []string{"a", "b", "c"}, []string{unique[0].Id, unique[1].Id, unique[2].Id})
+	assert.Len(t, unique, 3)
+}
+
+// newERPNextStub serves handler as the ERPNext instance of p and returns the server, closed when
+// the test ends
+func newERPNextStub(t *testing.T, p *Plugin, handler http.HandlerFunc) *httptest.Server {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	p.erpNextClient = erpnext.NewClient(server.URL, "key", "secret")
+	return server
+}
+
+// expectNoEmailMatch makes the mock API find no Mattermost user for email
+func expectNoEmailMatch(api *plugintest.API, email string) {
+	api.On("GetUserByEmail", email).Return(nil, model.NewAppError("GetUserByEmail", "app.user.missing_account.const", nil, "", http.StatusNotFound))
+	api.On("SearchUsers", mock.Anything).Return([]*model.User{}, nil)
+}
+
+func TestCreatedUserIsTaggedWhenEmployeeUpdateFails(t *testing.T) {
+	api := &plugintest.API{}
+	allowLogs(api)
+	expectNoEmailMatch(api, "jane@example.com")
+	api.On("GetUserByUsername", mock.Anything).Return(nil, model.NewAppError("GetUserByUsername", "app.user.get_by_username.app_error", nil, "", http.StatusNotFound))
+	api.On("CreateUser", mock.Anything).Return(&model.User{Id: "new-user-id", Username: "jane.doe"}, nil)
+	api.On("UpdatePreferencesForUser", "new-user-id", mock.Anything).Return(nil).Once()
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{TagCreatedUsers: true})
+	newERPNextStub(t, p, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"exc_type": "ValidationError"}`, http.StatusBadRequest)
+	})
+
+	employee := erpnext.Employee{Name: "HR-EMP-1", FirstName: "Jane", LastName: "Doe", Status: "Active", CompanyEmail: "jane@example.com"}
+	res := p.syncEmployeeToMattermost(context.Background(), employee, nil)
+
+	require.Error(t, res.Err)
+	assert.Contains(t, res.Message, "User Created but Update Failed")
+	api.AssertExpectations(t)
+}
+
+func TestEmployeeNumberMatchRequiresTheUsersEmail(t *testing.T) {
+	user := &model.User{Id: "user-id", Username: "jane.doe", Email: "jane@example.com", FirstName: "Jane"}
+	user.SetProp(defaultEmployeeNumberAttribute, "42")
+
+	for _, tc := range []struct {
+		name     string
+		employee string
+		outcome  syncOutcome
+		message  string
+	}{
+		{name: "employee with the user's email", employee: `{"name": "HR-EMP-1", "employee_number": "42", "company_email": "Jane@Example.com"}`, outcome: outcomeUpdated, message: "Would update employee HR-EMP-1"},
+		{name: "employee already mapped to the user", employee: `{"name": "HR-EMP-1", "employee_number": "42", "custom_chat_id": "user-id"}`, outcome: outcomeMatched, message: "Already Mapped to employee HR-EMP-1"},
+		{name: "someone else's employee", employee: `{"name": "HR-EMP-2", "employee_number": "42", "company_email": "boss@example.com"}`, outcome: outcomeSkipped, message: "Employee Number Mismatch"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			api := &plugintest.API{}
+			allowLogs(api)
+			p := &Plugin{}
+			p.SetAPI(api)
+			p.setConfiguration(&configuration{SyncMatchKey: matchKeyEmployeeNumber})
+			newERPNextStub(t, p, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodGet, r.Method)
+				_, _ = w.Write([]byte(`{"data": [` + tc.employee + `]}`))
+			})
+
+			res := p.syncUserToERPNext(context.Background(), user, true, false)
+			require.NoError(t, res.Err)
+			assert.Equal(t, tc.outcome, res.Outcome)
+			assert.Contains(t, res.Message, tc.message)
+		})
+	}
+}
+
+func TestFindEmployeeNumberConflicts(t *testing.T) {
+	newUser := func(id, number string) *model.User {
+		user := &model.User{Id: id}
+		user.SetProp(defaultEmployeeNumberAttribute, number)
+		return user
+	}
+	users := []*model.User{newUser("a", "42"), newUser("b", " 42 "), newUser("c", "7"), newUser("d", "")}
+
+	assert.Empty(t, (&configuration{}).findEmployeeNumberConflicts(users), "email matching doesn't use numbers")
+	assert.Equal(t, map[string]string{"a": "42", "b": "42"}, (&configuration{SyncMatchKey: matchKeyEmployeeNumber}).findEmployeeNumberConflicts(users))
+}
+
+func TestNewAccountLimitCountsAccountsWhoseRecordFailed(t *testing.T) {
+	api := &plugintest.API{}
+	allowLogs(api)
+	expectNoEmailMatch(api, "jane@example.com")
+	expectNoEmailMatch(api, "john@example.com")
+	api.On("GetUserByUsername", mock.Anything).Return(nil, model.NewAppError("GetUserByUsername", "app.user.get_by_username.app_error", nil, "", http.StatusNotFound))
+	api.On("CreateUser", mock.Anything).Return(&model.User{Id: "new-user-id", Username: "jane.doe"}, nil).Once()
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{})
+	newERPNextStub(t, p, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"exc_type": "ValidationError"}`, http.StatusBadRequest)
+	})
+	limits := &employeeSyncLimits{newAccounts: newRunLimit(1)}
+
+	// The account is created, then the ERPNext update fails the record
+	res := p.syncEmployeeToMattermost(context.Background(), erpnext.Employee{Name: "HR-EMP-1", FirstName: "Jane", LastName: "Doe", Status: "Active", CompanyEmail: "jane@example.com"}, limits)
+	require.Error(t, res.Err)
+
+	res = p.syncEmployeeToMattermost(context.Background(), erpnext.Employee{Name: "HR-EMP-2", FirstName: "John", LastName: "Doe", Status: "Active", CompanyEmail: "john@example.com"}, limits)
+	assert.Equal(t, skipReasonCreationLimit, res.SkipReason)
+	api.AssertNumberOfCalls(t, "CreateUser", 1)
+}
+
+func TestNewAccountLimitGivesBackSlotsOfFailedCreations(t *testing.T) {
+	limits := &employeeSyncLimits{newAccounts: newRunLimit(1)}
+
+	require.True(t, limits.newAccountSlot())
+	assert.False(t, limits.newAccountSlot())
+	limits.releaseNewAccountSlot()
+	assert.True(t, limits.newAccountSlot())
+
+	var unlimited *employeeSyncLimits
+	assert.True(t, unlimited.newAccountSlot())
+}
+
+func TestExistingUserLinkIsNotReplaced(t *testing.T) {
+	api := &plugintest.API{}
+	allowLogs(api)
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{})
+	newERPNextStub(t, p, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method != http.MethodGet:
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusInternalServerError)
+		case r.URL.Path == "/api/resource/User":
+			_, _ = w.Write([]byte(`{"data": [{"name": "jane@example.com", "email": "jane@example.com"}]}`))
+		default:
+			_, _ = w.Write([]byte(`{"data": [{"name": "HR-EMP-1", "company_email": "jane@example.com", "custom_chat_id": "user-id", "user_id": "jdoe@example.com"}]}`))
+		}
+	})
+
+	user := &model.User{Id: "user-id", Username: "jane.doe", Email: "jane@example.com", FirstName: "Jane"}
+	res := p.syncUserToERPNext(context.Background(), user, false, false)
+
+	require.NoError(t, res.Err)
+	assert.Contains(t, res.Notes, "employee linked to ERPNext user jdoe@example.com, not jane@example.com; link left unchanged")
+}
+
+func TestUsersWithoutFirstNameStillMapOntoExistingEmployees(t *testing.T) {
+	user := &model.User{Id: "user-id", Username: "jdoe", Email: "jane@example.com"}
+
+	for _, tc := range []struct {
+		name     string
+		employee string
+		outcome  syncOutcome
+		message  string
+	}{
+		{name: "existing employee", employee: `{"name": "HR-EMP-1", "company_email": "jane@example.com"}`, outcome: outcomeUpdated, message: "Would update employee HR-EMP-1"},
+		{name: "employee would be created", employee: "", outcome: outcomeSkipped, message: "Skipped (No First Name)"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			api := &plugintest.API{}
+			allowLogs(api)
+			p := &Plugin{}
+			p.SetAPI(api)
+			p.setConfiguration(&configuration{})
+			newERPNextStub(t, p, func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`{"data": [` + tc.employee + `]}`))
+			})
+
+			res := p.syncUserToERPNext(context.Background(), user, true, false)
+			require.NoError(t, res.Err)
+			assert.Equal(t, tc.outcome, res.Outcome)
+			assert.Contains(t, res.Message, tc.message)
+		})
+	}
+}
+
+func TestNameMatchSkipsUsersMappedToAnotherEmployee(t *testing.T) {
+	api := &plugintest.API{}
+	allowLogs(api)
+	api.On("GetUserByEmail", "jane.doe@example.com").Return(nil, model.NewAppError("GetUserByEmail", "app.user.missing_account.const", nil, "", http.StatusNotFound))
+	api.On("SearchUsers", mock.Anything).Return([]*model.User{
+		{Id: "user-a", Username: "jane.a", FirstName: "Jane", LastName: "Doe"},
+		{Id: "user-b", Username: "jane.b", FirstName: "Jane", LastName: "Doe"},
+	}, nil)
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{MatchByName: true})
+	var updated string
+	newERPNextStub(t, p, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut:
+			updated = r.URL.Path
+			_, _ = w.Write([]byte(`{"data": {"name": "HR-EMP-2"}}`))
+		case strings.Contains(r.URL.Query().Get("filters"), "user-a"):
+			_, _ = w.Write([]byte(`{"data": [{"name": "HR-EMP-1", "custom_chat_id": "user-a"}]}`))
+		default:
+			_, _ = w.Write([]byte(`{"data": []}`))
+		}
+	})
+
+	employee := erpnext.Employee{Name: "HR-EMP-2", FirstName: "Jane", LastName: "Doe", Status: "Active", CompanyEmail: "jane.doe@example.com"}
+	res := p.syncEmployeeToMattermost(context.Background(), employee, nil)
+
+	require.NoError(t, res.Err)
+	assert.Contains(t, res.Message, "Mapped to existing user jane.b by name")
+	assert.Equal(t, "/api/resource/Employee/HR-EMP-2", updated)
+}
+
+func TestDeactivatedEmailMatchIsNotReplacedByNameMatch(t *testing.T) {
+	api := &plugintest.API{}
+	allowLogs(api)
+	api.On("GetUserByEmail", "jane.doe@example.com").Return(&model.User{Id: "user-old", Username: "jane.doe", Email: "jane.doe@example.com", DeleteAt: 1}, nil)
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{MatchByName: true})
+	newERPNextStub(t, p, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+	})
+
+	employee := erpnext.Employee{Name: "HR-EMP-1", FirstName: "Jane", LastName: "Doe", Status: "Active", CompanyEmail: "jane.doe@example.com"}
+	res := p.syncEmployeeToMattermost(context.Background(), employee, nil)
+
+	assert.Equal(t, "Deactivated User", res.SkipReason)
+	api.AssertNotCalled(t, "SearchUsers", mock.Anything)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/erpnext"
+)
+
+// Actions named in the sync comments added to employees
+const (
+	syncCommentCreated = "Created"
+	syncCommentUpdated = "Updated"
+)
+
+// addSyncComment leaves a comment on the employee's ERPNext timeline saying the plugin created or
+// updated it, when AddSyncComments is on. Returns a note for the result when the comment failed,
+// or "" otherwise; a missing comment never fails the record.
+func (p *Plugin) addSyncComment(client *erpnext.Client, employeeName, action string) string {
+	if !p.getConfiguration().AddSyncComments || employeeName == "" {
+		return ""
+	}
+
+	text := fmt.Sprintf("%s by %s on %s", action, erpnext.CommentAuthor, time.Now().UTC().Format("2006-01-02"))
+	if err := client.AddComment("Employee", employeeName, text); err != nil {
+		p.API.LogWarn("Failed to add sync comment to employee", "employee_id", employeeName, "error", err.Error())
+		return "sync comment failed: " + err.Error()
+	}
+	return ""
+}
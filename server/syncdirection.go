@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/syncresult"
+	"github.com/pkg/errors"
+)
+
+// Directions the scheduled sync job can run, set at runtime with /erpsync syncdirection
+const (
+	scheduledDirectionOff  = "off"
+	scheduledDirectionBoth = "both"
+)
+
+// validScheduledDirections are the values /erpsync syncdirection accepts
+var validScheduledDirections = []string{directionMMToERP, directionERPToMM, scheduledDirectionBoth, scheduledDirectionOff}
+
+// scheduledSyncDirection returns what the scheduled sync job syncs. Until an admin picks a
+// direction the job doesn't sync at all.
+func (p *Plugin) scheduledSyncDirection() (string, error) {
+	if p.kvstore == nil {
+		return scheduledDirectionOff, nil
+	}
+	direction, err := p.kvstore.GetScheduledSyncDirection()
+	if err != nil {
+		return scheduledDirectionOff, err
+	}
+	for _, valid := range validScheduledDirections {
+		if direction == valid {
+			return direction, nil
+		}
+	}
+	return scheduledDirectionOff, nil
+}
+
+// setScheduledSyncDirection stores what the scheduled sync job syncs from now on
+func (p *Plugin) setScheduledSyncDirection(userID, direction string) error {
+	if p.kvstore == nil {
+		return errors.New("KV store is not available")
+	}
+	if err := p.kvstore.SetScheduledSyncDirection(direction); err != nil {
+		return err
+	}
+	p.API.LogInfo("Scheduled sync direction changed", "user_id", userID, "direction", direction)
+	return nil
+}
+
+// describeScheduledDirection renders the scheduled sync direction for the slash command
+func describeScheduledDirection(direction string) string {
+	switch direction {
+	case directionMMToERP:
+		return "The scheduled sync runs hourly from Mattermost to ERPNext."
+	case directionERPToMM:
+		return "The scheduled sync runs hourly from ERPNext to Mattermost."
+	case scheduledDirectionBoth:
+		return "The scheduled sync runs hourly in both directions, Mattermost to ERPNext first."
+	default:
+		return "The scheduled sync is off."
+	}
+}
+
+// runScheduledSync runs the syncs the scheduled direction asks for, with no admin watching so
+// problems are alerted
+func (p *Plugin) runScheduledSync(direction string) {
+	if direction == scheduledDirectionOff {
+		return
+	}
+	if p.erpNextClient == nil {
+		p.API.LogWarn("Skipping scheduled sync, ERPNext is not configured")
+		return
+	}
+
+	if direction == directionMMToERP || direction == scheduledDirectionBoth {
+		startTime := time.Now()
+		ctx, cancel := p.syncContext(startTime, userSyncMaxDuration)
+		defer cancel()
+		users, truncated, err := p.loadUsersForSync()
+		if err != nil {
+			p.API.LogError("Scheduled sync failed to load Mattermost users", "error", err.Error())
+			return
+		}
+		result := syncresult.New(directionMMToERP)
+		if truncated {
+			result.MarkTruncated()
+		}
+		p.runUserSync(ctx, "", startTime, users, result, false)
+	}
+
+	if direction == directionERPToMM || direction == scheduledDirectionBoth {
+		startTime := time.Now()
+		ctx, cancel := p.syncContext(startTime, employeeSyncMaxDuration)
+		defer cancel()
+		employees, err := p.loadEmployeesForSync(ctx)
+		if err != nil {
+			p.API.LogError("Scheduled sync failed to load ERPNext employees", "error", err.Error())
+			return
+		}
+		p.runEmployeeSync(ctx, "", startTime, employees, syncresult.New(directionERPToMM))
+	}
+
+	p.API.LogInfo(fmt.Sprintf("Scheduled %s sync finished", direction))
+}
@@ -0,0 +1,408 @@
+// Package syncresult provides the result accounting shared by every sync entry point, so that
+// counters, per-record entries and their rendering stay consistent across handlers and commands.
+package syncresult
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// SchemaVersion is the version of the shape of the plugin API's JSON responses, sent as their
+// top-level schema_version so frontends and scripts can branch on it. Increment it whenever a
+// response changes in a way consumers could trip over, like a renamed or removed field.
+//
+// Version 1 is the sync result with its counters and user_results, the failures_only report,
+// the NDJSON stream lines, and the check, retry-failed, pause/resume and schema responses.
+const SchemaVersion = 1
+
+// Status is the outcome of a single record in a sync run
+type Status string
+
+const (
+	StatusMatched Status = "matched"
+	StatusUpdated Status = "updated"
+	StatusCreated Status = "created"
+
+	// StatusDeactivated is a Mattermost account deactivated because its employee isn't Active
+	StatusDeactivated Status = "deactivated"
+	StatusSkipped     Status = "skipped"
+	StatusFailed      Status = "failed"
+	StatusInfo        Status = "info"
+)
+
+// Entry is the detailed result for a single record
+type Entry struct {
+	Status  Status `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message"`
+}
+
+// Result accumulates the counters and per-record entries of a sync run
+type Result struct {
+	// CorrelationID identifies the run in logs, history and alerts
+	CorrelationID    string `json:"correlation_id"`
+	Direction        string `json:"direction"`
+	MatchedCount     int    `json:"matched_count"`
+	UpdatedCount     int    `json:"updated_count"`
+	CreatedCount     int    `json:"created_count"`
+	SkippedCount     int    `json:"skipped_count"`
+	DeactivatedCount int    `json:"deactivated_count"`
+	FailedCount      int    `json:"failed_count"`
+	ERPUsersCreated  int    `json:"erp_users_created"`
+	ERPUsersAlready  int    `json:"erp_users_already_exist"`
+	ERPUsersSkipped  int    `json:"erp_users_skipped"`
+	TotalProcessed   int    `json:"total_processed"`
+	TimedOut         bool   `json:"timed_out"`
+	Truncated        bool   `json:"truncated"`
+	Targeted         bool   `json:"targeted"`
+	Aborted          bool   `json:"aborted"`
+	AbortReason      string `json:"abort_reason,omitempty"`
+	ProcessingTime   string `json:"processing_time"`
+
+	// PendingEmailRetries are the usernames whose credential email failed and was queued to be
+	// resent by the scheduled job
+	PendingEmailRetries []string `json:"pending_email_retries,omitempty"`
+
+	// RetryBudgetUsedSeconds is the retry wait time the run spent, out of RetryBudgetSeconds
+	// (0 when unlimited)
+	RetryBudgetUsedSeconds float64 `json:"retry_budget_used_seconds"`
+	RetryBudgetSeconds     float64 `json:"retry_budget_seconds,omitempty"`
+
+	// Entries holds the per-record results, unless an entry handler consumes them
+	Entries []Entry `json:"-"`
+
+	// ResultsTruncated is set once more entries were recorded than SetMaxEntries allows.
+	// OmittedEntries of them were dropped; the counters still cover every record.
+	ResultsTruncated bool `json:"results_truncated"`
+	OmittedEntries   int  `json:"omitted_entries,omitempty"`
+	maxEntries       int
+
+	startTime    time.Time
+	entryHandler func(Entry)
+}
+
+// New starts a result for a sync run in the given direction
+func New(direction string) *Result {
+	return &Result{
+		CorrelationID: model.NewId(),
+		Direction:     direction,
+		Entries:       []Entry{},
+		startTime:     time.Now(),
+	}
+}
+
+// SetEntryHandler routes every new entry to handler instead of keeping it in Entries,
+// e.g. to stream entries to the client as they are produced
+func (r *Result) SetEntryHandler(handler func(Entry)) {
+	r.entryHandler = handler
+}
+
+func (r *Result) addEntry(entry Entry) {
+	if r.entryHandler != nil {
+		r.entryHandler(entry)
+		return
+	}
+
+	if r.maxEntries > 0 && len(r.Entries) >= r.maxEntries {
+		r.OmittedEntries++
+		r.ResultsTruncated = true
+
+		// Failures and notes are what admins act on, so they push out the oldest other entry
+		if !keepPreferentially(entry) {
+			return
+		}
+		evict := -1
+		for i, kept := range r.Entries {
+			if !keepPreferentially(kept) {
+				evict = i
+				break
+			}
+		}
+		if evict < 0 {
+			return
+		}
+		r.Entries = append(r.Entries[:evict], r.Entries[evict+1:]...)
+	}
+	r.Entries = append(r.Entries, entry)
+}
+
+// keepPreferentially reports whether an entry is kept over others once the entries are capped
+func keepPreferentially(entry Entry) bool {
+	return entry.Status == StatusFailed || entry.Status == StatusInfo
+}
+
+// SetMaxEntries caps the number of entries kept, 0 for no limit. Failures and notes are kept in
+// preference to other entries, and the counters stay exact.
+func (r *Result) SetMaxEntries(max int) {
+	r.maxEntries = max
+}
+
+// RecordMatched records a record that was already in sync
+func (r *Result) RecordMatched(message string) {
+	r.MatchedCount++
+	r.addEntry(Entry{Status: StatusMatched, Message: message})
+}
+
+// RecordUpdated records a record whose mapping was updated
+func (r *Result) RecordUpdated(message string) {
+	r.UpdatedCount++
+	r.addEntry(Entry{Status: StatusUpdated, Message: message})
+}
+
+// RecordCreated records a record that was created on the target side
+func (r *Result) RecordCreated(message string) {
+	r.CreatedCount++
+	r.addEntry(Entry{Status: StatusCreated, Message: message})
+}
+
+// RecordDeactivated records a Mattermost account deactivated because its employee isn't Active
+// in ERPNext
+func (r *Result) RecordDeactivated(message string) {
+	r.DeactivatedCount++
+	r.addEntry(Entry{Status: StatusDeactivated, Message: message})
+}
+
+// RecordSkipped records a record that was deliberately not processed
+func (r *Result) RecordSkipped(reason, message string) {
+	r.SkippedCount++
+	r.addEntry(Entry{Status: StatusSkipped, Reason: reason, Message: message})
+}
+
+// RecordFailed records a record that could not be processed
+func (r *Result) RecordFailed(message string) {
+	r.FailedCount++
+	r.addEntry(Entry{Status: StatusFailed, Message: message})
+}
+
+// RecordPartialFailure records a record where an earlier step completed with the given status
+// before a later step failed. Both the completed step and the failure are counted.
+func (r *Result) RecordPartialFailure(completed Status, message string) {
+	switch completed {
+	case StatusMatched:
+		r.MatchedCount++
+	case StatusUpdated:
+		r.UpdatedCount++
+	case StatusCreated:
+		r.CreatedCount++
+	}
+	r.FailedCount++
+	r.addEntry(Entry{Status: StatusFailed, Message: message})
+}
+
+// RecordNote records an informational entry that isn't tied to a single record
+func (r *Result) RecordNote(message string) {
+	r.addEntry(Entry{Status: StatusInfo, Message: message})
+}
+
+// RecordERPUserCreated counts an ERPNext login created for an employee
+func (r *Result) RecordERPUserCreated() {
+	r.ERPUsersCreated++
+}
+
+// RecordERPUserExisted counts an employee whose ERPNext login already existed
+func (r *Result) RecordERPUserExisted() {
+	r.ERPUsersAlready++
+}
+
+// RecordERPUserSkipped counts an employee left without an ERPNext login because they aren't Active
+func (r *Result) RecordERPUserSkipped() {
+	r.ERPUsersSkipped++
+}
+
+// RecordPendingEmailRetry notes a created user whose credential email is queued for retry
+func (r *Result) RecordPendingEmailRetry(username string) {
+	r.PendingEmailRetries = append(r.PendingEmailRetries, username)
+}
+
+// RecordRetryBudget records how much of the run's retry budget was spent
+func (r *Result) RecordRetryBudget(used, limit time.Duration) {
+	r.RetryBudgetUsedSeconds = used.Seconds()
+	r.RetryBudgetSeconds = limit.Seconds()
+}
+
+// MarkTimedOut flags the run as stopped early because it reached its time budget
+func (r *Result) MarkTimedOut() {
+	r.TimedOut = true
+}
+
+// MarkTruncated flags the run as covering only part of the records because the source list
+// hit its safety limit
+func (r *Result) MarkTruncated() {
+	r.Truncated = true
+}
+
+// MarkTargeted flags the run as covering only the records a request listed, not the whole
+// directory
+func (r *Result) MarkTargeted() {
+	r.Targeted = true
+}
+
+// MarkAborted flags the run as stopped early because it could not continue
+func (r *Result) MarkAborted(reason string) {
+	r.Aborted = true
+	r.AbortReason = reason
+}
+
+// Finish computes the totals and processing time. Call it once processing is done.
+func (r *Result) Finish() {
+	r.TotalProcessed = r.MatchedCount + r.UpdatedCount + r.CreatedCount + r.SkippedCount + r.DeactivatedCount
+	r.ProcessingTime = time.Since(r.startTime).String()
+}
+
+// Summary returns a one-line, human readable summary of the counters
+func (r *Result) Summary() string {
+	summary := fmt.Sprintf(
+		"Total Processed: %d, Matched: %d, Updated: %d, Created: %d, Skipped: %d, Failed: %d",
+		r.TotalProcessed, r.MatchedCount, r.UpdatedCount, r.CreatedCount, r.SkippedCount, r.FailedCount,
+	)
+	if r.ERPUsersCreated > 0 || r.ERPUsersAlready > 0 {
+		summary += fmt.Sprintf(", ERPNext Users Created: %d, ERPNext Users Already Exist: %d", r.ERPUsersCreated, r.ERPUsersAlready)
+	}
+	if r.DeactivatedCount > 0 {
+		summary += fmt.Sprintf(", Deactivated (ERP Inactive): %d", r.DeactivatedCount)
+	}
+	if r.ERPUsersSkipped > 0 {
+		summary += fmt.Sprintf(", ERPNext Users Skipped (Not Active): %d", r.ERPUsersSkipped)
+	}
+	summary += fmt.Sprintf(", Timed Out: %v", r.TimedOut)
+	if r.Truncated {
+		summary += ", Truncated: true"
+	}
+	if r.Targeted {
+		summary += ", Targeted: true"
+	}
+	if r.Aborted {
+		summary += fmt.Sprintf(", Aborted: %s", r.AbortReason)
+	}
+	if r.OmittedEntries > 0 {
+		summary += fmt.Sprintf(", Results Omitted: %d", r.OmittedEntries)
+	}
+	if len(r.PendingEmailRetries) > 0 {
+		summary += fmt.Sprintf(", Pending Email Retry: %s", strings.Join(r.PendingEmailRetries, ", "))
+	}
+	if r.RetryBudgetSeconds > 0 {
+		summary += fmt.Sprintf(", Retry Budget Used: %.1fs of %.0fs", r.RetryBudgetUsedSeconds, r.RetryBudgetSeconds)
+	} else if r.RetryBudgetUsedSeconds > 0 {
+		summary += fmt.Sprintf(", Retry Wait: %.1fs", r.RetryBudgetUsedSeconds)
+	}
+	return summary
+}
+
+// MarshalJSON renders the counters plus the entry messages as user_results, the shape the
+// webapp and existing scripts consume
+func (r *Result) MarshalJSON() ([]byte, error) {
+	type resultAlias Result
+	userResults := make([]string, 0, len(r.Entries)+1)
+	for _, entry := range r.Entries {
+		userResults = append(userResults, entry.Message)
+	}
+	if r.OmittedEntries > 0 {
+		userResults = append(userResults, r.omittedNote())
+	}
+
+	return json.Marshal(struct {
+		SchemaVersion int `json:"schema_version"`
+		*resultAlias
+		UserResults []string `json:"user_results"`
+	}{
+		SchemaVersion: SchemaVersion,
+		resultAlias:   (*resultAlias)(r),
+		UserResults:   userResults,
+	})
+}
+
+// omittedNote says how many entries were dropped by the entry cap
+func (r *Result) omittedNote() string {
+	return fmt.Sprintf("%d more results omitted to bound the response size, the counts cover every record", r.OmittedEntries)
+}
+
+// Markdown renders the summary and entries as Markdown tables
+func (r *Result) Markdown() string {
+	var b strings.Builder
+
+	b.WriteString("| Matched | Updated | Created | Skipped | Failed | Total |\n")
+	b.WriteString("|---|---|---|---|---|---|\n")
+	fmt.Fprintf(&b, "| %d | %d | %d | %d | %d | %d |\n",
+		r.MatchedCount, r.UpdatedCount, r.CreatedCount, r.SkippedCount, r.FailedCount, r.TotalProcessed)
+
+	if r.TimedOut {
+		b.WriteString("\n**The sync stopped early because it reached its time limit.**\n")
+	}
+	if r.Truncated {
+		b.WriteString("\n**Only part of the records were synced because the list reached its safety limit.**\n")
+	}
+	if r.Aborted {
+		fmt.Fprintf(&b, "\n**The sync was aborted: %s**\n", r.AbortReason)
+	}
+	if r.DeactivatedCount > 0 {
+		fmt.Fprintf(&b, "\n**Deactivated (ERP inactive):** %d\n", r.DeactivatedCount)
+	}
+	if len(r.PendingEmailRetries) > 0 {
+		fmt.Fprintf(&b, "\n**Credential emails queued for retry:** %s\n", strings.Join(r.PendingEmailRetries, ", "))
+	}
+
+	if r.OmittedEntries > 0 {
+		fmt.Fprintf(&b, "\n**%s**\n", r.omittedNote())
+	}
+
+	if len(r.Entries) == 0 {
+		return b.String()
+	}
+
+	b.WriteString("\n| Status | Details |\n")
+	b.WriteString("|---|---|\n")
+	for _, entry := range r.Entries {
+		fmt.Fprintf(&b, "| %s | %s |\n", entry.Status, markdownCell(entry.Message))
+	}
+
+	return b.String()
+}
+
+// Failures returns the entries of records that failed
+func (r *Result) Failures() []Entry {
+	failures := []Entry{}
+	for _, entry := range r.Entries {
+		if entry.Status == StatusFailed {
+			failures = append(failures, entry)
+		}
+	}
+	return failures
+}
+
+// CSV renders the entries as CSV with a header row
+func (r *Result) CSV() ([]byte, error) {
+	return EntriesCSV(r.Entries)
+}
+
+// EntriesCSV renders entries as CSV with a header row
+func EntriesCSV(entries []Entry) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+
+	if err := writer.Write([]string{"status", "reason", "message"}); err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if err := writer.Write([]string{string(entry.Status), entry.Reason, entry.Message}); err != nil {
+			return nil, err
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// markdownCell makes a message safe to place in a single Markdown table cell
+func markdownCell(message string) string {
+	message = strings.ReplaceAll(message, "|", "\\|")
+	return strings.ReplaceAll(message, "\n", "<br>")
+}
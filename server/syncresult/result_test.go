@@ -0,0 +1,68 @@
+package syncresult
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxEntriesKeepsFailures(t *testing.T) {
+	r := New("mm-to-erp")
+	r.SetMaxEntries(3)
+
+	r.RecordMatched("a")
+	r.RecordFailed("b")
+	r.RecordCreated("c")
+	r.RecordUpdated("d")
+	r.RecordFailed("e")
+	r.RecordFailed("f")
+	r.RecordFailed("g")
+
+	assert.Equal(t, 1, r.MatchedCount)
+	assert.Equal(t, 4, r.FailedCount)
+	assert.True(t, r.ResultsTruncated)
+	assert.Equal(t, 4, r.OmittedEntries)
+
+	messages := []string{}
+	for _, entry := range r.Entries {
+		messages = append(messages, entry.Message)
+	}
+	assert.Equal(t, []string{"b", "e", "f"}, messages)
+
+	data, err := json.Marshal(r)
+	require.NoError(t, err)
+	var body struct {
+		ResultsTruncated bool     `json:"results_truncated"`
+		UserResults      []string `json:"user_results"`
+	}
+	require.NoError(t, json.Unmarshal(data, &body))
+	assert.True(t, body.ResultsTruncated)
+	assert.Len(t, body.UserResults, 4)
+	assert.Contains(t, body.UserResults[3], "4 more results omitted")
+}
+
+func TestNoMaxEntriesKeepsEverything(t *testing.T) {
+	r := New("erp-to-mm")
+	for i := 0; i < 10; i++ {
+		r.RecordMatched("matched")
+	}
+	assert.Len(t, r.Entries, 10)
+	assert.False(t, r.ResultsTruncated)
+}
+
+func TestMarshalIncludesSchemaVersion(t *testing.T) {
+	r := New("mm-to-erp")
+	r.RecordMatched("matched")
+	r.Finish()
+
+	data, err := json.Marshal(r)
+	require.NoError(t, err)
+	var body struct {
+		SchemaVersion *int `json:"schema_version"`
+	}
+	require.NoError(t, json.Unmarshal(data, &body))
+	require.NotNil(t, body.SchemaVersion)
+	assert.Equal(t, SchemaVersion, *body.SchemaVersion)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/erpnext"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/syncresult"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// maxSyncTargetsBodySize bounds the request body of a targeted sync
+const maxSyncTargetsBodySize = 1 << 20
+
+// syncTargetsRequest is the optional body of the sync endpoints, restricting a run to the listed
+// emails. Emails is kept raw to tell a missing key from an empty list.
+type syncTargetsRequest struct {
+	Emails json.RawMessage `json:"emails"`
+}
+
+// readSyncTargets returns the normalized, deduplicated emails a sync request is restricted to,
+// or nil for a full sync when the body is empty or has no emails key. An emails key without a
+// single valid email is an error, so a request meant for a few users never syncs everyone.
+func readSyncTargets(r *http.Request) ([]string, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSyncTargetsBodySize))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read request body")
+	}
+	if strings.TrimSpace(string(body)) == "" {
+		return nil, nil
+	}
+
+	var request syncTargetsRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, errors.Wrap(err, "request body must be a JSON object like {\"emails\": [...]}")
+	}
+
+	if len(request.Emails) == 0 {
+		return nil, nil
+	}
+
+	var listed []string
+	if err := json.Unmarshal(request.Emails, &listed); err != nil {
+		return nil, errors.Wrap(err, "emails must be a list of email addresses")
+	}
+
+	var emails []string
+	seen := map[string]bool{}
+	for _, raw := range listed {
+		email := erpnext.NormalizeEmail(raw)
+		if email == "" || seen[email] {
+			continue
+		}
+		seen[email] = true
+		emails = append(emails, email)
+	}
+	if len(emails) == 0 {
+		return nil, errors.New("emails lists no email address, leave the key out to sync everyone")
+	}
+	return emails, nil
+}
+
+// loadUsersByEmail makes sure every ERPNext instance is ready and looks up the Mattermost user of
+// each email individually, instead of fetching the whole directory. Returns the emails that have
+// no Mattermost user.
+func (p *Plugin) loadUsersByEmail(emails []string) ([]*model.User, []string, error) {
+	if err := p.prepareERPNextInstances(); err != nil {
+		return nil, nil, err
+	}
+
+	var users []*model.User
+	var missing []string
+	for _, email := range emails {
+		user, appErr := p.API.GetUserByEmail(email)
+		if appErr != nil && appErr.StatusCode != http.StatusNotFound {
+			return nil, nil, errors.Wrapf(appErr, "failed to look up Mattermost user %s", email)
+		}
+		if user == nil {
+			missing = append(missing, email)
+			continue
+		}
+		users = append(users, user)
+	}
+	return users, missing, nil
+}
+
+// loadEmployeesByEmail makes sure the chat ID field exists and looks up the ERPNext employee of
+// each email individually within ctx, instead of fetching every employee. Returns the emails that
+// have no employee and the lookups that failed, so one unreachable record doesn't fail the rest.
+func (p *Plugin) loadEmployeesByEmail(ctx context.Context, emails []string) ([]erpnext.Employee, []string, map[string]error, error) {
+	client := p.erpNextClient.WithContext(ctx)
+	if _, err := p.ensureChatIDField(client); err != nil {
+		p.API.LogError("Failed to prepare chat ID field", "error", err)
+		return nil, nil, nil, err
+	}
+
+	var employees []erpnext.Employee
+	var missing []string
+	failed := map[string]error{}
+	for _, email := range emails {
+		employee, err := client.GetEmployeeByEmail(email)
+		if err != nil {
+			p.API.LogError("Failed to look up listed employee", "email", email, "error", err)
+			failed[email] = errors.Wrapf(err, "failed to look up employee %s", email)
+			continue
+		}
+		if employee == nil {
+			missing = append(missing, email)
+			continue
+		}
+		employees = append(employees, *employee)
+	}
+	return employees, missing, failed, nil
+}
+
+// recordFailedTargets reports every listed email whose lookup failed and keeps it for retry,
+// in the order the emails were listed
+func (p *Plugin) recordFailedTargets(result *syncresult.Result, emails []string, failed map[string]error, direction string) {
+	for _, email := range emails {
+		err, ok := failed[email]
+		if !ok {
+			continue
+		}
+		result.RecordFailed(fmt.Sprintf("%s - Error: %s", email, err.Error()))
+		p.recordSyncFailure(direction, email, err)
+	}
+}
+
+// recordMissingTargets reports every listed email that has no record to sync
+func recordMissingTargets(result *syncresult.Result, missing []string, record string) {
+	for _, email := range missing {
+		result.RecordSkipped("Not Found", fmt.Sprintf("%s - Skipped (Not Found, no %s with this email)", email, record))
+	}
+}
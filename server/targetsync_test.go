@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/syncresult"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadSyncTargets(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		body     string
+		expected []string
+		invalid  bool
+	}{
+		{name: "empty body is a full sync", body: ""},
+		{name: "no emails is a full sync", body: `{}`},
+		{name: "emails are normalized and deduplicated", body: `{"emails": [" A@X.com", "b@x.com", "a@x.com", ""]}`, expected: []string{"a@x.com", "b@x.com"}},
+		{name: "malformed body", body: `["a@x.com"]`, invalid: true},
+		{name: "only blank emails", body: `{"emails": ["", "  "]}`, invalid: true},
+		{name: "empty email list", body: `{"emails": []}`, invalid: true},
+		{name: "null email list", body: `{"emails": null}`, invalid: true},
+		{name: "emails that aren't strings", body: `{"emails": [1, 2]}`, invalid: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/sync/mm-to-erp", strings.NewReader(tc.body))
+			emails, err := readSyncTargets(r)
+			if tc.invalid {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, emails)
+		})
+	}
+}
+
+func TestRecordMissingTargets(t *testing.T) {
+	result := syncresult.New(directionERPToMM)
+	recordMissingTargets(result, []string{"gone@example.com"}, "ERPNext employee")
+
+	assert.Equal(t, 1, result.SkippedCount)
+	require.Len(t, result.Entries, 1)
+	assert.Equal(t, "gone@example.com - Skipped (Not Found, no ERPNext employee with this email)", result.Entries[0].Message)
+}
+
+func TestFailedTargetLookupsAreRecordedPerEmail(t *testing.T) {
+	api := &plugintest.API{}
+	allowLogs(api)
+	p := &Plugin{}
+	p.SetAPI(api)
+	useMemoryKVStore(p, api)
+	newERPNextStub(t, p, func(w http.ResponseWriter, r *http.Request) {
+		filters := r.URL.Query().Get("filters")
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/api/resource/Custom Field"):
+			_, _ = w.Write([]byte(`{"data": [{"name": "Employee-custom_chat_id"}]}`))
+		case strings.Contains(filters, "broken@example.com"):
+			http.Error(w, `{"exc_type": "ValidationError"}`, http.StatusBadRequest)
+		case strings.Contains(filters, "jane@example.com"):
+			_, _ = w.Write([]byte(`{"data": [{"name": "HR-EMP-1", "company_email": "jane@example.com"}]}`))
+		default:
+			_, _ = w.Write([]byte(`{"data": []}`))
+		}
+	})
+
+	emails := []string{"broken@example.com", "jane@example.com", "gone@example.com"}
+	employees, missing, failed, err := p.loadEmployeesByEmail(context.Background(), emails)
+	require.NoError(t, err)
+	require.Len(t, employees, 1)
+	assert.Equal(t, "HR-EMP-1", employees[0].Name)
+	assert.Equal(t, []string{"gone@example.com"}, missing)
+	require.Contains(t, failed, "broken@example.com")
+
+	result := syncresult.New(directionERPToMM)
+	p.recordFailedTargets(result, emails, failed, directionERPToMM)
+	assert.Equal(t, 1, result.FailedCount)
+	entries, err := p.kvstore.GetFailedEntries()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "broken@example.com", entries[0].Email)
+}
+
+func TestTargetedRunIsNotAFullSync(t *testing.T) {
+	api := &plugintest.API{}
+	allowLogs(api)
+	p := &Plugin{}
+	p.SetAPI(api)
+	useMemoryKVStore(p, api)
+
+	result := syncresult.New(directionMMToERP)
+	result.MarkTargeted()
+	result.Finish()
+	p.recordCompletedSync(directionMMToERP, time.Now(), result)
+
+	last, err := p.kvstore.GetLastSync(directionMMToERP)
+	require.NoError(t, err)
+	assert.True(t, last.IsZero(), "a targeted run doesn't move the watermark")
+
+	runs, err := p.kvstore.GetSyncRuns()
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	assert.False(t, runs[0].Complete)
+	assert.True(t, runs[0].Targeted)
+
+	body, err := json.Marshal(result)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `"targeted":true`)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// minGeneratedUsernameLength is the shortest username the plugin creates
+const minGeneratedUsernameLength = 3
+
+// invalidUsernameChars matches characters Mattermost doesn't allow in usernames
+var invalidUsernameChars = regexp.MustCompile(`[^a-z0-9.\-_]`)
+
+// restrictedUsernames are the usernames Mattermost refuses to create, reserved whatever
+// ReservedUsernames says
+var restrictedUsernames = []string{"all", "channel", "matterbot", "system"}
+
+// defaultReservedUsernames are reserved on top of restrictedUsernames when ReservedUsernames is
+// empty: mention keywords and names users could mistake for an official account
+var defaultReservedUsernames = []string{"here", "admin", "administrator", "root", "support"}
+
+// reservedUsernameSuffixLength is the length of the suffix that sets a reserved username apart
+const reservedUsernameSuffixLength = 4
+
+// isReservedUsername reports whether username is restricted by Mattermost or listed in
+// ReservedUsernames (the defaults when empty)
+func (c *configuration) isReservedUsername(username string) bool {
+	reserved := splitList(c.ReservedUsernames)
+	if len(reserved) == 0 {
+		reserved = defaultReservedUsernames
+	}
+	for _, name := range append(reserved, restrictedUsernames...) {
+		if strings.EqualFold(strings.TrimPrefix(strings.TrimSpace(name), "@"), username) {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureValidUsername is the last step before creating a Mattermost user: it guarantees the
+// username satisfies Mattermost's rules (lowercase letters, digits and ".-_", starting with a
+// letter, within the length limits, not reserved). Invalid characters and leading non-letters
+// are dropped; when too little is left, a "user_" username derived from seed is used instead.
+func (p *Plugin) ensureValidUsername(username, seed string) string {
+	username = strings.ToLower(strings.TrimSpace(username))
+	username = invalidUsernameChars.ReplaceAllString(username, "_")
+
+	// Mattermost usernames must start with a letter
+	username = strings.TrimLeftFunc(username, func(r rune) bool {
+		return r < 'a' || r > 'z'
+	})
+
+	if len(username) > model.UserNameMaxLength {
+		username = username[:model.UserNameMaxLength]
+	}
+
+	// Names that slug to a reserved word keep it but get a suffix, e.g. "admin_x7k2", rather than
+	// failing CreateUser
+	if username != "" && p.getConfiguration().isReservedUsername(username) {
+		if len(username) > model.UserNameMaxLength-reservedUsernameSuffixLength-1 {
+			username = username[:model.UserNameMaxLength-reservedUsernameSuffixLength-1]
+		}
+		username += "_" + p.seededString(seed, "reserved", reservedUsernameSuffixLength)
+	}
+
+	// Names that slug to nothing usable, e.g. all digits or underscores, get a fresh username
+	if len(username) < minGeneratedUsernameLength || !model.IsValidUsername(username) {
+		username = "user_" + p.seededString(seed, "invalid", 6)
+	}
+
+	return username
+}
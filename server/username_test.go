@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/erpnext"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnsureValidUsername(t *testing.T) {
+	p := &Plugin{}
+
+	for _, tc := range []struct {
+		name     string
+		username string
+		expected string
+	}{
+		{name: "valid username is kept", username: "nguyen.van_a", expected: "nguyen.van_a"},
+		{name: "uppercase is lowered", username: "Nguyen.Van", expected: "nguyen.van"},
+		{name: "leading digits are dropped", username: "2024_nguyen", expected: "nguyen"},
+		{name: "leading punctuation is dropped", username: "_.-anna", expected: "anna"},
+		{name: "invalid characters are replaced", username: "an+b@c", expected: "an_b_c"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, p.ensureValidUsername(tc.username, "jane@example.com:EMP-1"))
+		})
+	}
+
+	for _, tc := range []struct {
+		name     string
+		username string
+	}{
+		{name: "all underscores", username: "___"},
+		{name: "all digits", username: "12345"},
+		{name: "too short after trimming", username: "1a"},
+		{name: "empty", username: ""},
+	} {
+		t.Run(tc.name+" is regenerated", func(t *testing.T) {
+			username := p.ensureValidUsername(tc.username, "jane@example.com:EMP-1")
+			assert.Regexp(t, `^user_[a-z0-9]{6}$`, username)
+			assert.True(t, model.IsValidUsername(username))
+		})
+	}
+
+	t.Run("regeneration is stable for the same seed", func(t *testing.T) {
+		assert.Equal(t,
+			p.ensureValidUsername("___", "jane@example.com:EMP-1"),
+			p.ensureValidUsername("12345", "jane@example.com:EMP-1"))
+	})
+
+	t.Run("long usernames are cut to the Mattermost limit", func(t *testing.T) {
+		username := p.ensureValidUsername(strings.Repeat("ab", 50), "")
+		assert.Len(t, username, model.UserNameMaxLength)
+		assert.True(t, model.IsValidUsername(username))
+	})
+}
+
+func TestEnsureValidUsernameReservedNames(t *testing.T) {
+	const seed = "jane@example.com:EMP-1"
+
+	t.Run("Mattermost restricted and default reserved names get a suffix", func(t *testing.T) {
+		p := &Plugin{}
+		for _, name := range []string{"system", "all", "channel", "matterbot", "here", "admin", "Root"} {
+			username := p.ensureValidUsername(name, seed)
+			assert.Regexp(t, `^`+strings.ToLower(name)+`_[a-z0-9]{4}$`, username)
+			assert.True(t, model.IsValidUsername(username))
+		}
+	})
+
+	t.Run("names that slug to a reserved word", func(t *testing.T) {
+		p := &Plugin{}
+		for _, tc := range []struct {
+			firstName string
+			lastName  string
+			reserved  string
+		}{
+			{firstName: "System", reserved: "system"},
+			{firstName: "Ádmin", reserved: "admin"},
+			{firstName: " Channel ", reserved: "channel"},
+		} {
+			username := p.ensureValidUsername(p.generateUsername(tc.firstName, tc.lastName, seed), seed)
+			assert.NotEqual(t, tc.reserved, username)
+			assert.Regexp(t, `^`+tc.reserved+`_[a-z0-9]{4}$`, username)
+		}
+	})
+
+	t.Run("suffix is stable for the same seed", func(t *testing.T) {
+		p := &Plugin{}
+		assert.Equal(t, p.ensureValidUsername("admin", seed), p.ensureValidUsername("admin", seed))
+	})
+
+	t.Run("configured list replaces the defaults", func(t *testing.T) {
+		p := &Plugin{}
+		p.setConfiguration(&configuration{ReservedUsernames: "hr, @payroll"})
+
+		assert.Regexp(t, `^hr_[a-z0-9]{4}$`, p.ensureValidUsername("hr", seed))
+		assert.Regexp(t, `^payroll_[a-z0-9]{4}$`, p.ensureValidUsername("payroll", seed))
+		assert.Equal(t, "admin", p.ensureValidUsername("admin", seed))
+		assert.Regexp(t, `^system_[a-z0-9]{4}$`, p.ensureValidUsername("system", seed))
+	})
+
+	t.Run("long reserved names leave room for the suffix", func(t *testing.T) {
+		long := strings.Repeat("a", model.UserNameMaxLength)
+		p := &Plugin{}
+		p.setConfiguration(&configuration{ReservedUsernames: long})
+
+		username := p.ensureValidUsername(long, seed)
+		assert.Len(t, username, model.UserNameMaxLength)
+		assert.True(t, model.IsValidUsername(username))
+	})
+}
+
+func TestUniqueERPNextUsername(t *testing.T) {
+	taken := map[string]bool{"j.smith": true, "j.smith_1": true}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var filters [][]string
+		require.NoError(t, json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters))
+		if taken[filters[0][2]] {
+			_, _ = w.Write([]byte(`{"data":[{"name":"someone@example.com"}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":[]}`))
+	}))
+	defer server.Close()
+	client := erpnext.NewClient(server.URL, "key", "secret")
+
+	api := &plugintest.API{}
+	allowLogs(api)
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	assert.Equal(t, "j.smith_2", p.uniqueERPNextUsername(client, "j.smith"))
+	assert.Equal(t, "jane", p.uniqueERPNextUsername(client, "jane"))
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/erpnext"
+)
+
+// configProblem is a single invalid setting found by IsValid
+type configProblem struct {
+	Message string
+
+	// Critical problems keep the ERPNext clients from being built
+	Critical bool
+}
+
+// configValidationError lists every problem found in a configuration
+type configValidationError struct {
+	Problems []configProblem
+}
+
+func (e *configValidationError) Error() string {
+	messages := make([]string, 0, len(e.Problems))
+	for _, problem := range e.Problems {
+		messages = append(messages, problem.Message)
+	}
+	return "invalid plugin configuration: " + strings.Join(messages, "; ")
+}
+
+// Critical reports whether any problem is serious enough to refuse building the ERPNext clients
+func (e *configValidationError) Critical() bool {
+	for _, problem := range e.Problems {
+		if problem.Critical {
+			return true
+		}
+	}
+	return false
+}
+
+// welcomeMessagePlaceholders are the placeholders SendWelcomeMessage substitutes
+var welcomeMessagePlaceholders = []string{"username", "first_name"}
+
+// placeholderPattern matches {{name}} placeholders in message templates
+var placeholderPattern = regexp.MustCompile(`{{([^{}]*)}}`)
+
+// IsValid checks the whole configuration and returns a *configValidationError listing every
+// problem found, or nil when the configuration is valid.
+func (c *configuration) IsValid() error {
+	var problems []configProblem
+	critical := func(format string, args ...interface{}) {
+		problems = append(problems, configProblem{Message: fmt.Sprintf(format, args...), Critical: true})
+	}
+	invalid := func(format string, args ...interface{}) {
+		problems = append(problems, configProblem{Message: fmt.Sprintf(format, args...)})
+	}
+
+	// The single-instance connection is either fully configured or not at all
+	if c.ERPNextURL != "" || c.ERPNextAPIKey != "" || c.ERPNextAPISecret != "" {
+		if err := validateERPNextURL(c.ERPNextURL); err != nil {
+			critical("ERPNextURL %s", err.Error())
+		}
+		if c.ERPNextAPIKey == "" || c.ERPNextAPISecret == "" {
+			critical("ERPNextAPIKey and ERPNextAPISecret are both required")
+		}
+	}
+
+	// Broken extra instances are reported but the valid ones are still used
+	instances, err := c.getERPNextInstances()
+	if err != nil {
+		invalid("ERPNextInstances: %s", err.Error())
+	}
+	for _, instance := range instances {
+		if instance.Name == defaultERPNextInstanceName {
+			continue
+		}
+		if err := validateERPNextURL(instance.URL); err != nil {
+			invalid("ERPNext instance %q url %s", instance.Name, err.Error())
+		}
+	}
+
+	for _, setting := range []struct {
+		name  string
+		value int
+	}{
+		{"CreateUserMaxRetries", c.CreateUserMaxRetries},
+		{"CircuitBreakerThreshold", c.CircuitBreakerThreshold},
+		{"HTTPMaxIdleConnsPerHost", c.HTTPMaxIdleConnsPerHost},
+		{"HTTPMaxConnsPerHost", c.HTTPMaxConnsPerHost},
+		{"HTTPIdleConnTimeoutSeconds", c.HTTPIdleConnTimeoutSeconds},
+		{"ERPNextReadTimeoutSeconds", c.ERPNextReadTimeoutSeconds},
+		{"ERPNextWriteTimeoutSeconds", c.ERPNextWriteTimeoutSeconds},
+		{"KVRetentionDays", c.KVRetentionDays},
+		{"MaxUserPages", c.MaxUserPages},
+		{"ProgressEventInterval", c.ProgressEventInterval},
+		{"MaxNewAccountsPerRun", c.MaxNewAccountsPerRun},
+		{"MaxDeactivationsPerRun", c.MaxDeactivationsPerRun},
+		{"SyncPauseAutoResumeMinutes", c.SyncPauseAutoResumeMinutes},
+		{"ChatIDWriteBatchSize", c.ChatIDWriteBatchSize},
+		{"ERPNextRateLimitMinRemaining", c.ERPNextRateLimitMinRemaining},
+		{"ERPNextRateLimitMaxWaitSeconds", c.ERPNextRateLimitMaxWaitSeconds},
+		{"SyncRetryBudgetSeconds", c.SyncRetryBudgetSeconds},
+		{"AlertFailureThreshold", c.AlertFailureThreshold},
+		{"MaxResultEntries", c.MaxResultEntries},
+		{"ERPNextMaxConcurrentRequests", c.ERPNextMaxConcurrentRequests},
+		{"VerifySamplePercent", c.VerifySamplePercent},
+		{"RecordTimeoutSeconds", c.RecordTimeoutSeconds},
+	} {
+		if setting.value < 0 {
+			invalid("%s must not be negative, got %d", setting.name, setting.value)
+		}
+	}
+	if c.VerifySamplePercent > 100 {
+		invalid("VerifySamplePercent must be at most 100, got %d", c.VerifySamplePercent)
+	}
+
+	if c.SyncOrder != "" && c.getSyncOrder() != c.SyncOrder {
+		invalid("SyncOrder %q must be one of %s, %s or %s", c.SyncOrder, syncOrderNone, syncOrderEmail, syncOrderAdminsFirst)
+	}
+	if c.NonActiveEmployeeUserPolicy != "" && c.getNonActiveEmployeeUserPolicy() != c.NonActiveEmployeeUserPolicy {
+		invalid("NonActiveEmployeeUserPolicy %q must be one of %s, %s or %s", c.NonActiveEmployeeUserPolicy, nonActiveUserCreate, nonActiveUserSkip, nonActiveUserDisabled)
+	}
+	if c.InactiveEmployeeMode != "" && c.getInactiveEmployeeMode() != c.InactiveEmployeeMode {
+		invalid("InactiveEmployeeMode %q must be %s or %s", c.InactiveEmployeeMode, inactiveEmployeeSkip, inactiveEmployeeDeactivate)
+	}
+	if c.ERPNextResponseShape != "" && c.getERPNextResponseShape() != c.ERPNextResponseShape {
+		invalid("ERPNextResponseShape %q must be one of %s, %s or %s", c.ERPNextResponseShape, erpnext.ResponseShapeAuto, erpnext.ResponseShapeData, erpnext.ResponseShapeMessage)
+	}
+	if c.DateOfJoiningSource != "" && c.getDateOfJoiningSource() != c.DateOfJoiningSource {
+		invalid("DateOfJoiningSource %q must be %s or %s", c.DateOfJoiningSource, dateOfJoiningFixed, dateOfJoiningAccountCreated)
+	}
+	for _, field := range erpnext.ProtectedEmployeeDateFields {
+		if strings.TrimSpace(c.ERPNextChatIDField) == field || strings.TrimSpace(c.OnboardedStatusField) == field {
+			invalid("%s is an employee date and can't be used as the chat ID or onboarded status field", field)
+		}
+	}
+	if c.SyncMatchKey != "" && c.getSyncMatchKey() != c.SyncMatchKey {
+		invalid("SyncMatchKey %q must be %s or %s", c.SyncMatchKey, matchKeyEmail, matchKeyEmployeeNumber)
+	}
+	if _, err := c.getNewEmployeeStatus(); err != nil {
+		invalid("%s", err.Error())
+	}
+	if sourceField := strings.TrimSpace(c.CreatedBySourceField); erpnext.IsReservedUserField(sourceField) {
+		invalid("CreatedBySourceField %q is a field the sync always sets itself, users are not marked", sourceField)
+	}
+	if _, err := c.getERPNextUserExtraFields(); err != nil {
+		invalid("%s", err.Error())
+	}
+	if _, err := c.getFieldSources(); err != nil {
+		invalid("%s", err.Error())
+	}
+	if _, err := c.getEmployeeChildTables(); err != nil {
+		invalid("%s", err.Error())
+	}
+	if _, err := c.getDefaultFieldValues(); err != nil {
+		invalid("%s", err.Error())
+	}
+
+	if c.WelcomeMessageEnabled {
+		if strings.TrimSpace(c.WelcomeMessage) == "" {
+			invalid("WelcomeMessage is empty while WelcomeMessageEnabled is on")
+		}
+		if err := validateMessageTemplate(c.WelcomeMessage, welcomeMessagePlaceholders); err != nil {
+			invalid("WelcomeMessage %s", err.Error())
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return &configValidationError{Problems: problems}
+}
+
+// validateERPNextURL checks that rawURL is an absolute http(s) URL
+func validateERPNextURL(rawURL string) error {
+	if strings.TrimSpace(rawURL) == "" {
+		return fmt.Errorf("is required")
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("is not a valid URL: %s", err.Error())
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("%q must start with http:// or https://", rawURL)
+	}
+	if parsed.Host == "" {
+		return fmt.Errorf("%q has no host", rawURL)
+	}
+	return nil
+}
+
+// validateMessageTemplate checks that every {{placeholder}} in message is a known one and that
+// no braces are left unbalanced
+func validateMessageTemplate(message string, known []string) error {
+	for _, match := range placeholderPattern.FindAllStringSubmatch(message, -1) {
+		isKnown := false
+		for _, name := range known {
+			if match[1] == name {
+				isKnown = true
+				break
+			}
+		}
+		if !isKnown {
+			return fmt.Errorf("uses unknown placeholder %q, available placeholders are {{%s}}", match[0], strings.Join(known, "}}, {{"))
+		}
+	}
+
+	remainder := placeholderPattern.ReplaceAllString(message, "")
+	if strings.Contains(remainder, "{{") || strings.Contains(remainder, "}}") {
+		return fmt.Errorf("has an unclosed placeholder")
+	}
+	return nil
+}
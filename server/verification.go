@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/erpnext"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/syncresult"
+)
+
+// chatIDClaim is a chat ID the sync reports having written to an employee
+type chatIDClaim struct {
+	client       *erpnext.Client
+	employeeName string
+	chatID       string
+
+	// label identifies the record in the discrepancy report
+	label string
+}
+
+// syncVerifier collects the chat IDs a sync run wrote so a sample of them can be re-read from
+// ERPNext once the run is done. A nil verifier collects nothing.
+type syncVerifier struct {
+	percent int
+	claims  []chatIDClaim
+}
+
+// newSyncVerifier returns a verifier re-reading percent of the written records, or nil when
+// verification is disabled
+func newSyncVerifier(percent int) *syncVerifier {
+	if percent <= 0 {
+		return nil
+	}
+	if percent > 100 {
+		percent = 100
+	}
+	return &syncVerifier{percent: percent}
+}
+
+// observe collects the chat ID a successfully recorded result wrote, if any
+func (v *syncVerifier) observe(res recordSyncResult) {
+	if v == nil || res.Err != nil || res.ChatIDClaim == nil {
+		return
+	}
+	v.claims = append(v.claims, *res.ChatIDClaim)
+}
+
+// sample returns a random selection of percent of the collected claims, at least one when any
+// were collected
+func (v *syncVerifier) sample() []chatIDClaim {
+	if v == nil || len(v.claims) == 0 {
+		return nil
+	}
+
+	count := (len(v.claims)*v.percent + 99) / 100
+	sample := append([]chatIDClaim(nil), v.claims...)
+	seededRand := rand.New(rand.NewSource(time.Now().UnixNano()))
+	seededRand.Shuffle(len(sample), func(i, j int) {
+		sample[i], sample[j] = sample[j], sample[i]
+	})
+	return sample[:count]
+}
+
+// verifySyncWrites re-reads a sample of the employees the run wrote a chat ID to and records a
+// note for every one whose chat ID doesn't match, e.g. because a server hook reverted the change
+// after ERPNext reported success. The re-reads are made within ctx.
+func (p *Plugin) verifySyncWrites(ctx context.Context, verifier *syncVerifier, result *syncresult.Result) {
+	// Don't hold up deactivation with reads
+	sample := verifier.sample()
+	if len(sample) == 0 || p.syncs.stopping() {
+		return
+	}
+
+	discrepancies := 0
+	for _, claim := range sample {
+		employee, err := claim.client.WithContext(ctx).GetEmployee(claim.employeeName)
+		var problem string
+		switch {
+		case err != nil:
+			problem = fmt.Sprintf("employee %s could not be re-read: %s", claim.employeeName, err.Error())
+		case employee == nil:
+			problem = fmt.Sprintf("employee %s no longer exists", claim.employeeName)
+		case employee.CustomChatID != claim.chatID:
+			problem = fmt.Sprintf("employee %s has chat ID %q, the sync wrote %q", claim.employeeName, employee.CustomChatID, claim.chatID)
+		default:
+			continue
+		}
+
+		discrepancies++
+		p.API.LogWarn("Post-sync verification found a discrepancy",
+			"employee_id", claim.employeeName,
+			"expected_chat_id", claim.chatID,
+			"problem", problem,
+			"correlation_id", result.CorrelationID)
+		result.RecordNote(fmt.Sprintf("VERIFY: %s - %s", claim.label, problem))
+	}
+
+	p.API.LogInfo("Post-sync verification done",
+		"verified", len(sample),
+		"written", len(verifier.claims),
+		"discrepancies", discrepancies,
+		"correlation_id", result.CorrelationID)
+	result.RecordNote(fmt.Sprintf("VERIFY: Re-read %d of %d written records, %d discrepancies", len(sample), len(verifier.claims), discrepancies))
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/erpnext"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/syncresult"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncVerifierSample(t *testing.T) {
+	assert.Nil(t, newSyncVerifier(0))
+
+	verifier := newSyncVerifier(10)
+	for i := 0; i < 25; i++ {
+		verifier.observe(recordSyncResult{ChatIDClaim: &chatIDClaim{employeeName: "HR-EMP"}})
+	}
+	verifier.observe(recordSyncResult{Err: assert.AnError, ChatIDClaim: &chatIDClaim{employeeName: "failed"}})
+	verifier.observe(recordSyncResult{})
+
+	assert.Len(t, verifier.claims, 25, "only successful writes are verified")
+	assert.Len(t, verifier.sample(), 3, "the sample is rounded up")
+}
+
+func TestVerifySyncWritesReportsDiscrepancies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/HR-EMP-1"):
+			_, _ = w.Write([]byte(`{"data":{"name":"HR-EMP-1","custom_chat_id":"user-1"}}`))
+		case strings.HasSuffix(r.URL.Path, "/HR-EMP-2"):
+			// A server hook cleared the chat ID after the write succeeded
+			_, _ = w.Write([]byte(`{"data":{"name":"HR-EMP-2","custom_chat_id":""}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := erpnext.NewClient(server.URL, "key", "secret")
+
+	api := &plugintest.API{}
+	allowLogs(api)
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	verifier := newSyncVerifier(100)
+	verifier.observe(recordSyncResult{ChatIDClaim: &chatIDClaim{client: client, employeeName: "HR-EMP-1", chatID: "user-1", label: "one"}})
+	verifier.observe(recordSyncResult{ChatIDClaim: &chatIDClaim{client: client, employeeName: "HR-EMP-2", chatID: "user-2", label: "two"}})
+	verifier.observe(recordSyncResult{ChatIDClaim: &chatIDClaim{client: client, employeeName: "HR-EMP-3", chatID: "user-3", label: "three"}})
+
+	result := syncresult.New(directionMMToERP)
+	p.verifySyncWrites(context.Background(), verifier, result)
+
+	messages := []string{}
+	for _, entry := range result.Entries {
+		messages = append(messages, entry.Message)
+	}
+	require.Len(t, messages, 3)
+	assert.Contains(t, messages, `VERIFY: two - employee HR-EMP-2 has chat ID "", the sync wrote "user-2"`)
+	assert.Contains(t, messages, "VERIFY: three - employee HR-EMP-3 no longer exists")
+	assert.Contains(t, messages, "VERIFY: Re-read 3 of 3 written records, 2 discrepancies")
+}
@@ -11,6 +11,7 @@ import type {PluginRegistry} from './types/mattermost-webapp';
 
 // Define SyncResult type to handle the response from API
 interface SyncResult {
+    schema_version: number;
     matched_count: number;
     updated_count: number;
     created_count: number;